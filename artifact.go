@@ -0,0 +1,92 @@
+package mkdeb
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+)
+
+type Artifact struct {
+	Size    int64
+	Hashes  map[HashAlgorithm][]byte
+	Members []ArMember
+}
+
+func (builder Builder) BuildArtifact(ctx context.Context, w io.Writer, manifest *Manifest) (*Artifact, error) {
+	aw := &artifactWriter{
+		file:    w,
+		hashers: make(map[HashAlgorithm]hash.Hash, len(standardHashes)),
+	}
+	for _, algo := range standardHashes {
+		aw.hashers[algo] = algo.New()
+	}
+
+	members, err := builder.build(ctx, aw, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := &Artifact{
+		Size:    aw.size,
+		Hashes:  make(map[HashAlgorithm][]byte, len(aw.hashers)),
+		Members: members,
+	}
+	for algo, hasher := range aw.hashers {
+		artifact.Hashes[algo] = hasher.Sum(nil)
+	}
+	return artifact, nil
+}
+
+func (builder Builder) PackagesStanza(manifest *Manifest, filename string, artifact *Artifact) []byte {
+	fields := builder.controlFields(manifest)
+
+	extra := []ControlField{
+		{Name: "Filename", Value: filename},
+		{Name: "Size", Value: strconv.FormatInt(artifact.Size, 10)},
+	}
+	for _, algo := range standardHashes {
+		if sum, found := artifact.Hashes[algo]; found {
+			extra = append(extra, ControlField{Name: algo.PackagesField(), Value: hex.EncodeToString(sum)})
+		}
+	}
+
+	return formatControlFields(insertControlFields(fields, extra))
+}
+
+type ReleaseEntry struct {
+	Filename string
+	Artifact *Artifact
+}
+
+func ReleaseChecksums(entries []ReleaseEntry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("SHA256:\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, " %s %16d %s\n", hex.EncodeToString(entry.Artifact.Hashes[HashSHA256]), entry.Artifact.Size, entry.Filename)
+	}
+	return buf.Bytes()
+}
+
+type artifactWriter struct {
+	file    io.Writer
+	hashers map[HashAlgorithm]hash.Hash
+	size    int64
+}
+
+func (aw *artifactWriter) Write(p []byte) (int, error) {
+	n, err := aw.file.Write(p)
+	if n > 0 {
+		aw.size += int64(n)
+		for _, hasher := range aw.hashers {
+			_, err2 := hasher.Write(p[:n])
+			if err2 != nil {
+				panic(err2)
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package mkdeb
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestBuildArtifactMembers(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+
+	var buf bytes.Buffer
+	artifact, err := Builder{}.BuildArtifact(context.Background(), &buf, manifest)
+	if err != nil {
+		t.Fatalf("BuildArtifact: %v", err)
+	}
+	pkg := buf.Bytes()
+
+	if artifact.Size != int64(len(pkg)) {
+		t.Errorf("Size: expected %d, got %d", len(pkg), artifact.Size)
+	}
+	sha256sum := sha256.Sum256(pkg)
+	if !bytes.Equal(artifact.Hashes[HashSHA256], sha256sum[:]) {
+		t.Errorf("SHA256: does not match the output")
+	}
+	md5sum := md5.Sum(pkg)
+	if !bytes.Equal(artifact.Hashes[HashMD5], md5sum[:]) {
+		t.Errorf("MD5: does not match the output")
+	}
+
+	members := testReadAr(t, pkg)
+	if len(artifact.Members) != len(members) {
+		t.Fatalf("expected %d members, got %d", len(members), len(artifact.Members))
+	}
+
+	for index, member := range artifact.Members {
+		if member.Name != members[index].name {
+			t.Errorf("member %d: expected name %q, got %q", index, members[index].name, member.Name)
+		}
+		if member.DataOffset != member.HeaderOffset+arHeaderSize {
+			t.Errorf("%s: data offset %d does not follow header offset %d", member.Name, member.DataOffset, member.HeaderOffset)
+		}
+		header := string(pkg[member.HeaderOffset:member.DataOffset])
+		if strings.TrimRight(header[:16], " ") != member.Name || !strings.HasSuffix(header, "`\n") {
+			t.Errorf("%s: no ar header at offset %d: %q", member.Name, member.HeaderOffset, header)
+		}
+		data := pkg[member.DataOffset : member.DataOffset+member.Size]
+		if !bytes.Equal(data, members[index].data) {
+			t.Errorf("%s: bytes at offset %d do not match the member data", member.Name, member.DataOffset)
+		}
+		if strings.HasPrefix(member.Name, "control.tar") || strings.HasPrefix(member.Name, "data.tar") {
+			testReadTar(t, testDecompress(t, member.Name, data))
+		}
+	}
+
+	last := artifact.Members[len(artifact.Members)-1]
+	if end := last.DataOffset + last.Size + (last.Size & 1); end != int64(len(pkg)) {
+		t.Errorf("expected the last member to end at %d, got %d", len(pkg), end)
+	}
+}
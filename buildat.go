@@ -0,0 +1,183 @@
+package mkdeb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+)
+
+func (builder Builder) BuildAt(w io.WriterAt, manifest *Manifest) error {
+	return builder.BuildAtContext(context.Background(), w, manifest)
+}
+
+func (builder Builder) BuildAtContext(ctx context.Context, w io.WriterAt, manifest *Manifest) error {
+	if builder.ControlCompression == CompressAuto {
+		builder.ControlCompression = CompressNone
+	}
+
+	err := builder.prepare(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	hashDuringData := builder.controlCompression == CompressNone
+	controlBuilder := builder
+	if hashDuringData {
+		builder.placeholderHashes(manifest)
+		controlBuilder.OnWarning = nil
+		controlBuilder.Strict = false
+	} else {
+		err = builder.hashFiles(ctx, manifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	var controlBuf bytes.Buffer
+	err = controlBuilder.BuildControlTarball(&controlBuf, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to build control tarball in memory: %w", err)
+	}
+
+	controlName := "control.tar" + builder.controlCompression.Suffix()
+	controlSize := int64(controlBuf.Len())
+	dataName := "data.tar" + builder.Compression.Suffix()
+	attrs := builder.arAttrs()
+
+	debianBinarySize := int64(len(defaultDebianBinary))
+	dataHeaderOffset := int64(len(arMagic)) +
+		arHeaderSize + debianBinarySize + (debianBinarySize & 1) +
+		arHeaderSize + controlSize + (controlSize & 1)
+	dataOffset := dataHeaderOffset + arHeaderSize
+
+	dw := &offsetWriter{file: w, offset: dataOffset}
+	err = builder.buildDataTarball(ctx, dw, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to build data tarball: %w", err)
+	}
+	dataSize := dw.offset - dataOffset
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	if hashDuringData {
+		controlBuf.Reset()
+		err = builder.BuildControlTarball(&controlBuf, manifest)
+		if err != nil {
+			return fmt.Errorf("failed to build control tarball in memory: %w", err)
+		}
+		if int64(controlBuf.Len()) != controlSize {
+			return fmt.Errorf("internal error: control tarball is %d bytes, expected %d", controlBuf.Len(), controlSize)
+		}
+	}
+
+	hw := &offsetWriter{file: w}
+	_, err = io.WriteString(hw, arMagic)
+	if err != nil {
+		return fmt.Errorf("Write: %w", err)
+	}
+
+	err = writeArEntry(hw, "debian-binary", debianBinarySize, attrs, bytes.NewReader(defaultDebianBinary))
+	if err != nil {
+		return err
+	}
+
+	err = writeArEntry(hw, controlName, controlSize, attrs, &controlBuf)
+	if err != nil {
+		return err
+	}
+
+	if hw.offset != dataHeaderOffset {
+		panic(fmt.Errorf("internal error: data member header at offset %d, expected %d", hw.offset, dataHeaderOffset))
+	}
+
+	err = writeArHeader(hw, dataName, dataSize, attrs)
+	if err != nil {
+		return err
+	}
+
+	return writeArPadding(dw, dataSize)
+}
+
+func (builder Builder) hashFiles(ctx context.Context, manifest *Manifest) error {
+	for index := range manifest.Files {
+		file := &manifest.Files[index]
+		file.isHashed = false
+		file.hashes = nil
+
+		if file.Type != TypeREG {
+			continue
+		}
+
+		if len(builder.Hashes) == 0 {
+			file.isHashed = true
+			continue
+		}
+
+		rc, err := file.Reader(builder.Root)
+		if err != nil {
+			return fmt.Errorf("files[%d]: Open: %w", index, err)
+		}
+
+		hw := &hashWriter{
+			file:    io.Discard,
+			hashers: make(map[HashAlgorithm]hash.Hash, len(builder.Hashes)),
+		}
+		for _, algo := range builder.Hashes {
+			hw.hashers[algo] = algo.New()
+		}
+
+		_, err = io.Copy(hw, ctxReader{ctx, rc})
+		if err != nil {
+			_ = rc.Close()
+			return fmt.Errorf("files[%d]: Copy: %w", index, err)
+		}
+
+		err = rc.Close()
+		if err != nil {
+			return fmt.Errorf("files[%d]: Close: %w", index, err)
+		}
+
+		file.hashes = make(map[HashAlgorithm][]byte, len(builder.Hashes))
+		for _, algo := range builder.Hashes {
+			file.hashes[algo] = hw.hashers[algo].Sum(nil)
+		}
+		file.isHashed = true
+	}
+
+	manifest.isHashed = true
+	return nil
+}
+
+func (builder Builder) placeholderHashes(manifest *Manifest) {
+	for index := range manifest.Files {
+		file := &manifest.Files[index]
+		file.hashes = nil
+		file.isHashed = file.Type == TypeREG
+		if !file.isHashed || len(builder.Hashes) == 0 {
+			continue
+		}
+
+		file.hashes = make(map[HashAlgorithm][]byte, len(builder.Hashes))
+		for _, algo := range builder.Hashes {
+			file.hashes[algo] = make([]byte, algo.New().Size())
+		}
+	}
+
+	manifest.isHashed = true
+}
+
+type offsetWriter struct {
+	file   io.WriterAt
+	offset int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.file.WriteAt(p, ow.offset)
+	ow.offset += int64(n)
+	return n, err
+}
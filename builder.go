@@ -3,21 +3,78 @@ package mkdeb
 import (
 	"archive/tar"
 	"bytes"
-	"encoding/hex"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"hash"
 	"io"
 	"io/fs"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Builder struct {
 	Root        fs.FS
+	TempDir     string
 	ZeroTime    time.Time
 	Compression CompressAlgorithm
+	TarFormat   tar.Format
 	Hashes      []HashAlgorithm
+	Exclude     []string
+	Dedup       bool
+
+	PruneEmptyDirs   bool
+	StripSpecialBits bool
+	NormalizePerms   bool
+	SkipMd5sums      bool
+	RelativeLinks    bool
+	StampBuiltBy     bool
+	CompatRelations  bool
+	Strict           bool
+	ZstdWindowSize   int
+
+	AlwaysWriteConffiles   bool
+	AlwaysWriteHashMembers bool
+	DpkgControlMembers     bool
+	DefaultNumericOwner    bool
+	CheckAllFiles          bool
+	SortConffiles          bool
+	ExtractBuildIds        bool
+
+	AllowedURLSchemes       []string
+	AllowedPrefixes         []string
+	DefaultSection          string
+	ControlCompression      CompressAlgorithm
+	CompressionLevel        int
+	ControlCompressionLevel int
+
+	AllowNetwork  bool
+	FetchCacheDir string
+	HTTPClient    *http.Client
+	MaxFetchSize  int64
+
+	AutoZstdThreshold int64
+	InMemoryThreshold int64
+	MaxScriptSize     int64
+
+	ArUID  int
+	ArGID  int
+	ArMode int64
+
+	Progress    func(done, total int64, currentFile string)
+	FileModTime func(name string) (time.Time, bool)
+	OnWarning   func(w Warning)
+
+	RewriteHeader func(hdr *tar.Header, file *File)
+
+	controlCompression CompressAlgorithm
+	prebuiltChecksums  map[HashAlgorithm][]byte
 }
 
 func (builder *Builder) fillDefaults() {
@@ -25,26 +82,301 @@ func (builder *Builder) fillDefaults() {
 		builder.ZeroTime = time.Unix(1577836800, 0)
 	}
 
+	if builder.Hashes == nil {
+		builder.Hashes = standardHashes[:]
+	}
+
+	if builder.AllowedURLSchemes == nil {
+		builder.AllowedURLSchemes = defaultURLSchemes[:]
+	}
+}
+
+var defaultURLSchemes = [...]string{"http", "https", "ftp"}
+
+func (builder Builder) validateURLs(manifest *Manifest) error {
+	if manifest.HomePage == "" {
+		return nil
+	}
+
+	u, err := url.Parse(manifest.HomePage)
+	if err != nil {
+		return fmt.Errorf("homePage: invalid URL %q: %w", manifest.HomePage, err)
+	}
+
+	if u.Scheme == "" {
+		return nil
+	}
+
+	for _, scheme := range builder.AllowedURLSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("homePage: URL scheme %q is not allowed; expected one of %q", u.Scheme, builder.AllowedURLSchemes)
+}
+
+const defaultAutoZstdThreshold = 4 << 20
+
+const defaultInMemoryThreshold = 16 << 20
+
+const defaultMaxScriptSize = 1 << 20
+
+func (builder *Builder) resolveCompression(manifest *Manifest) {
 	if builder.Compression == CompressAuto {
+		threshold := builder.AutoZstdThreshold
+		if threshold <= 0 {
+			threshold = defaultAutoZstdThreshold
+		}
+
 		builder.Compression = CompressGZIP
+		if manifest.installedSize >= threshold {
+			builder.Compression = CompressZSTD
+		}
 	}
 
-	if builder.Hashes == nil {
-		builder.Hashes = standardHashes[:]
+	builder.controlCompression = builder.ControlCompression
+	if builder.controlCompression == CompressAuto {
+		builder.controlCompression = CompressGZIP
+		if builder.Compression == CompressNone {
+			builder.controlCompression = CompressNone
+		}
+	}
+}
+
+func (builder Builder) tarFormat() tar.Format {
+	if builder.TarFormat == tar.FormatUnknown {
+		return tar.FormatPAX
+	}
+	return builder.TarFormat
+}
+
+func (builder Builder) validateTarFormat() error {
+	switch builder.tarFormat() {
+	case tar.FormatUSTAR, tar.FormatPAX, tar.FormatGNU:
+		return nil
+	default:
+		return fmt.Errorf("tarFormat: unsupported value %v", builder.TarFormat)
+	}
+}
+
+const maxUSTARDevice = 0o7777777
+
+func paxDeviceRecords(hdr *tar.Header) {
+	if hdr.Format != tar.FormatPAX || (hdr.Typeflag != tar.TypeChar && hdr.Typeflag != tar.TypeBlock) {
+		return
+	}
+	if hdr.Devmajor <= maxUSTARDevice && hdr.Devminor <= maxUSTARDevice {
+		return
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string, 2)
+	}
+	hdr.PAXRecords["SCHILY.devmajor"] = strconv.FormatInt(hdr.Devmajor, 10)
+	hdr.PAXRecords["SCHILY.devminor"] = strconv.FormatInt(hdr.Devminor, 10)
+	if hdr.Devmajor > maxUSTARDevice {
+		hdr.Devmajor = 0
+	}
+	if hdr.Devminor > maxUSTARDevice {
+		hdr.Devminor = 0
+	}
+}
+
+func (builder Builder) checkTarFormat(file *File) error {
+	format := builder.tarFormat()
+	if file.Sparse && format != tar.FormatPAX {
+		return fmt.Errorf("sparse: requires the PAX tar format, not %v", format)
+	}
+	if format == tar.FormatUSTAR && (file.Type == TypeCHR || file.Type == TypeBLK) {
+		if *file.Major > maxUSTARDevice {
+			return fmt.Errorf("major: value %d does not fit in a %v tar header (max %d)", *file.Major, format, maxUSTARDevice)
+		}
+		if *file.Minor > maxUSTARDevice {
+			return fmt.Errorf("minor: value %d does not fit in a %v tar header (max %d)", *file.Minor, format, maxUSTARDevice)
+		}
+	}
+	return nil
+}
+
+func (builder Builder) compressOptions(level int) CompressOptions {
+	return CompressOptions{
+		ZstdWindowSize: builder.ZstdWindowSize,
+		ModTime:        builder.ZeroTime,
+		Level:          level,
 	}
 }
 
 func (builder Builder) Build(w io.Writer, manifest *Manifest) error {
+	return builder.BuildContext(context.Background(), w, manifest)
+}
+
+func (builder Builder) BuildContext(ctx context.Context, w io.Writer, manifest *Manifest) error {
+	_, err := builder.build(ctx, w, manifest)
+	return err
+}
+
+func (builder Builder) build(ctx context.Context, w io.Writer, manifest *Manifest) ([]ArMember, error) {
+	err := builder.prepare(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.installedSize < builder.inMemoryThreshold() {
+		return builder.buildInMemory(ctx, w, manifest)
+	}
+	return builder.buildWithTempDir(ctx, w, manifest)
+}
+
+func (builder *Builder) prepare(ctx context.Context, manifest *Manifest) error {
 	builder.fillDefaults()
 
-	err := manifest.Resolve(builder.Root)
+	err := builder.arAttrs().Validate()
+	if err != nil {
+		return err
+	}
+
+	err = builder.applyExcludes(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.checkRoot(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.checkAllFiles(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.applyDefaultSection(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.Resolve(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.validateURLs(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.checkAllowedPrefixes(manifest)
 	if err != nil {
 		return err
 	}
 
-	tempDir, err := os.MkdirTemp("", "mkdeb-*.d")
+	err = builder.extractBuildIds(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.reportWarnings(manifest)
+	if err != nil {
+		return err
+	}
+
+	builder.pruneEmptyDirs(manifest)
+
+	err = builder.prepareHeaders(manifest)
+	if err != nil {
+		return err
+	}
+
+	return builder.prepareCompression(manifest)
+}
+
+func (builder Builder) applyDefaultSection(manifest *Manifest) error {
+	if manifest.Section == "" && builder.DefaultSection != "" {
+		if !isValidSection(builder.DefaultSection) {
+			return fmt.Errorf("default section: invalid Debian package section %q", builder.DefaultSection)
+		}
+		manifest.Section = builder.DefaultSection
+	}
+	return nil
+}
+
+func (builder Builder) reportWarnings(manifest *Manifest) error {
+	for _, warning := range manifest.Warnings() {
+		err := builder.report(warning)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (builder *Builder) prepareCompression(manifest *Manifest) error {
+	builder.resolveCompression(manifest)
+
+	err := builder.Compression.ValidateLevel(builder.CompressionLevel)
+	if err != nil {
+		return fmt.Errorf("compression: %w", err)
+	}
+
+	err = builder.controlCompression.ValidateLevel(builder.ControlCompressionLevel)
+	if err != nil {
+		return fmt.Errorf("controlCompression: %w", err)
+	}
+	return nil
+}
+
+func (builder Builder) checkScriptSize(members []controlMember) error {
+	limit := builder.MaxScriptSize
+	if limit == 0 {
+		limit = defaultMaxScriptSize
+	}
+	if limit < 0 {
+		return nil
+	}
+
+	var total int64
+	for _, member := range members {
+		if member.isExec {
+			total += int64(len(member.data))
+		}
+	}
+	if total > limit {
+		return fmt.Errorf("maintainer scripts total %d bytes, which exceeds the limit of %d bytes", total, limit)
+	}
+	return nil
+}
+
+func (builder Builder) inMemoryThreshold() int64 {
+	if builder.InMemoryThreshold == 0 {
+		return defaultInMemoryThreshold
+	}
+	return builder.InMemoryThreshold
+}
+
+func (builder Builder) buildInMemory(ctx context.Context, w io.Writer, manifest *Manifest) ([]ArMember, error) {
+	var dataBuf bytes.Buffer
+	err := builder.buildDataTarball(ctx, &dataBuf, manifest)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
+		return nil, fmt.Errorf("failed to build data tarball in memory: %w", err)
+	}
+
+	var controlBuf bytes.Buffer
+	err = builder.BuildControlTarball(&controlBuf, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build control tarball in memory: %w", err)
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.writeArFile(w, defaultDebianBinary, bytes.NewReader(controlBuf.Bytes()), bytes.NewReader(dataBuf.Bytes()))
+}
+
+func (builder Builder) buildWithTempDir(ctx context.Context, w io.Writer, manifest *Manifest) ([]ArMember, error) {
+	tempDir, err := os.MkdirTemp(builder.TempDir, "mkdeb-*.d")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
 	}
 
 	needRemoveTempDir := true
@@ -54,12 +386,10 @@ func (builder Builder) Build(w io.Writer, manifest *Manifest) error {
 		}
 	}()
 
-	suffix := builder.Compression.Suffix()
-
-	controlPath := filepath.Join(tempDir, "control.tar"+suffix)
+	controlPath := filepath.Join(tempDir, "control.tar"+builder.controlCompression.Suffix())
 	controlFile, err := os.OpenFile(controlPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %q: %w", controlPath, err)
+		return nil, fmt.Errorf("failed to create temporary file: %q: %w", controlPath, err)
 	}
 
 	needCloseControlFile := true
@@ -69,10 +399,10 @@ func (builder Builder) Build(w io.Writer, manifest *Manifest) error {
 		}
 	}()
 
-	dataPath := filepath.Join(tempDir, "data.tar"+suffix)
+	dataPath := filepath.Join(tempDir, "data.tar"+builder.Compression.Suffix())
 	dataFile, err := os.OpenFile(dataPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %q: %w", dataPath, err)
+		return nil, fmt.Errorf("failed to create temporary file: %q: %w", dataPath, err)
 	}
 
 	needCloseDataFile := true
@@ -82,19 +412,24 @@ func (builder Builder) Build(w io.Writer, manifest *Manifest) error {
 		}
 	}()
 
-	err = builder.BuildDataTarball(dataFile, manifest)
+	err = builder.buildDataTarball(ctx, dataFile, manifest)
 	if err != nil {
-		return fmt.Errorf("failed to build data tarball in temporary file: %w", err)
+		return nil, fmt.Errorf("failed to build data tarball in temporary file: %w", err)
 	}
 
 	err = builder.BuildControlTarball(controlFile, manifest)
 	if err != nil {
-		return fmt.Errorf("failed to build control tarball in temporary file: %w", err)
+		return nil, fmt.Errorf("failed to build control tarball in temporary file: %w", err)
 	}
 
-	err = builder.writeArFile(w, controlFile, dataFile)
+	err = ctx.Err()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	members, err := builder.writeArFile(w, defaultDebianBinary, controlFile, dataFile)
+	if err != nil {
+		return nil, err
 	}
 
 	needCloseDataFile = false
@@ -106,20 +441,227 @@ func (builder Builder) Build(w io.Writer, manifest *Manifest) error {
 	needRemoveTempDir = false
 	err = os.RemoveAll(tempDir)
 	if err != nil {
-		return fmt.Errorf("failed to clean up temporary directory: %q: %w", tempDir, err)
+		return nil, fmt.Errorf("failed to clean up temporary directory: %q: %w", tempDir, err)
 	}
 
+	return members, nil
+}
+
+func (builder Builder) applyExcludes(manifest *Manifest) error {
+	if len(builder.Exclude) == 0 {
+		return nil
+	}
+
+	rules, err := compileExcludes(builder.Exclude)
+	if err != nil {
+		return err
+	}
+
+	files := make([]File, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		isDir := file.Type == TypeDIR || (file.Type == TypeAUTO && strings.HasSuffix(file.Name, "/"))
+		if !isExcluded(rules, file.Name, isDir) {
+			files = append(files, file)
+		}
+	}
+	manifest.Files = files
 	return nil
 }
 
+func (builder Builder) checkRoot(manifest *Manifest) error {
+	if builder.Root != nil {
+		return nil
+	}
+
+	for index, file := range manifest.Files {
+		if name, ok := file.sourcePath(); ok {
+			return fmt.Errorf("files[%d]: reads %q from the filesystem, but Builder.Root is nil", index, name)
+		}
+	}
+	return nil
+}
+
+func (builder Builder) checkAllFiles(manifest *Manifest) error {
+	if !builder.CheckAllFiles {
+		return nil
+	}
+
+	var problems []string
+	for _, name := range manifest.SourcePaths() {
+		f, err := builder.Root.Open(name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		_ = f.Close()
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("%d source files are missing or unreadable:\n\t%s", len(problems), strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func (builder Builder) checkAllowedPrefixes(manifest *Manifest) error {
+	if len(builder.AllowedPrefixes) == 0 {
+		return nil
+	}
+
+	prefixes := make([]string, len(builder.AllowedPrefixes))
+	for index, prefix := range builder.AllowedPrefixes {
+		prefixes[index] = strings.Trim(prefix, "/")
+	}
+
+	for index, file := range manifest.Files {
+		if !isUnderPrefix(prefixes, strings.TrimRight(file.Name, "/"), file.Type == TypeDIR) {
+			return fmt.Errorf("files[%d]: %q is outside of the allowed prefixes %q", index, file.Name, builder.AllowedPrefixes)
+		}
+	}
+	return nil
+}
+
+func isUnderPrefix(prefixes []string, name string, isDir bool) bool {
+	for _, prefix := range prefixes {
+		if prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+		if isDir && (name == "." || strings.HasPrefix(prefix, name+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (builder Builder) pruneEmptyDirs(manifest *Manifest) {
+	if !builder.PruneEmptyDirs {
+		return
+	}
+
+	for {
+		parents := make(map[string]struct{}, len(manifest.Files))
+		for _, file := range manifest.Files {
+			parents[path.Dir(strings.TrimRight(file.Name, "/"))] = struct{}{}
+		}
+
+		files := make([]File, 0, len(manifest.Files))
+		for _, file := range manifest.Files {
+			if file.Type == TypeDIR && !file.KeepEmpty {
+				if _, found := parents[strings.TrimRight(file.Name, "/")]; !found {
+					continue
+				}
+			}
+			files = append(files, file)
+		}
+
+		if len(files) == len(manifest.Files) {
+			return
+		}
+		manifest.Files = files
+	}
+}
+
 func (builder Builder) BuildDataTarball(w io.Writer, manifest *Manifest) error {
 	if !manifest.isResolved {
 		panic(fmt.Errorf("must call manifest.Resolve first"))
 	}
 
 	builder.fillDefaults()
+	err := builder.prepareHeaders(manifest)
+	if err != nil {
+		return err
+	}
 
-	cw, err := builder.Compression.NewWriter(w)
+	return builder.buildDataTarball(context.Background(), w, manifest)
+}
+
+func (builder Builder) WriteDataTarball(w io.Writer, manifest *Manifest) error {
+	return builder.WriteDataTarballContext(context.Background(), w, manifest)
+}
+
+func (builder Builder) WriteDataTarballContext(ctx context.Context, w io.Writer, manifest *Manifest) error {
+	err := builder.prepare(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	return builder.buildDataTarball(ctx, w, manifest)
+}
+
+func (builder Builder) prepareHeaders(manifest *Manifest) error {
+	for index := range manifest.Files {
+		err := builder.prepareHeader(manifest, &manifest.Files[index])
+		if err != nil {
+			return fmt.Errorf("files[%d]: %w", index, err)
+		}
+	}
+	return nil
+}
+
+func (builder Builder) prepareHeader(manifest *Manifest, file *File) error {
+	file.tarHeader = nil
+
+	err := builder.checkTarFormat(file)
+	if err != nil {
+		return err
+	}
+
+	hdr := file.AsTarHeader()
+	hdr.Format = builder.tarFormat()
+	if hdr.ModTime.IsZero() && builder.FileModTime != nil {
+		if t, found := builder.FileModTime(file.Name); found {
+			hdr.ModTime = t
+		}
+	}
+	if hdr.ModTime.IsZero() {
+		hdr.ModTime = manifest.DefaultMTime
+	}
+	if hdr.ModTime.IsZero() {
+		hdr.ModTime = builder.ZeroTime
+	}
+	if builder.DefaultNumericOwner {
+		err = numericOwner(file, &hdr)
+		if err != nil {
+			return err
+		}
+	}
+	if builder.NormalizePerms {
+		hdr.Mode = normalizeMode(file, hdr.Mode)
+	}
+	if builder.StripSpecialBits {
+		hdr.Mode &^= 0o6000
+	}
+	if builder.RelativeLinks && hdr.Typeflag == tar.TypeSymlink {
+		hdr.Linkname = relativeLink(file.archiveName(), hdr.Linkname)
+	}
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	paxDeviceRecords(&hdr)
+	if builder.RewriteHeader != nil {
+		builder.RewriteHeader(&hdr, file)
+	}
+	file.tarHeader = &hdr
+	return nil
+}
+
+func (builder Builder) buildDataTarball(ctx context.Context, w io.Writer, manifest *Manifest) error {
+	if !manifest.isResolved {
+		panic(fmt.Errorf("must call manifest.Resolve first"))
+	}
+
+	builder.fillDefaults()
+	builder.resolveCompression(manifest)
+
+	err := builder.validateTarFormat()
+	if err != nil {
+		return err
+	}
+
+	err = builder.Compression.ValidateLevel(builder.CompressionLevel)
+	if err != nil {
+		return fmt.Errorf("compression: %w", err)
+	}
+
+	cw, err := builder.Compression.NewWriterOptions(w, builder.compressOptions(builder.CompressionLevel))
 	if err != nil {
 		return err
 	}
@@ -134,14 +676,75 @@ func (builder Builder) BuildDataTarball(w io.Writer, manifest *Manifest) error {
 		}
 	}()
 
+	dedupMap := make(map[dedupKey]int, len(manifest.Files))
+	extraConffiles := manifest.extraConffileSet()
+
+	var progressDone int64
+	progressTotal := manifest.installedSize
+
 	for index := range manifest.Files {
 		file := &manifest.Files[index]
 		file.isHashed = false
 		file.hashes = nil
 
-		hdr := file.AsTarHeader()
-		if hdr.ModTime.IsZero() {
-			hdr.ModTime = builder.ZeroTime
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		if file.tarHeader == nil {
+			err = builder.prepareHeader(manifest, file)
+			if err != nil {
+				return fmt.Errorf("files[%d]: %w", index, err)
+			}
+		}
+		hdr := *file.tarHeader
+		savedHdr := hdr
+		file.tarHeader = &savedHdr
+
+		_, isExtraConf := extraConffiles[file.archiveName()]
+		if builder.Dedup && file.Type == TypeREG && !file.IsConf && !isExtraConf && file.size > 0 {
+			var key dedupKey
+			key, err = builder.dedupKeyFor(ctx, file, &hdr)
+			if err != nil {
+				return fmt.Errorf("files[%d]: %w", index, err)
+			}
+
+			if oldIndex, found := dedupMap[key]; found {
+				oldFile := &manifest.Files[oldIndex]
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = oldFile.tarHeader.Name
+				hdr.Size = 0
+				savedHdr = hdr
+
+				err = tw.WriteHeader(&hdr)
+				if err != nil {
+					return fmt.Errorf("files[%d]: tar.WriteHeader: %w", index, err)
+				}
+
+				file.hashes = oldFile.hashes
+				file.isHashed = true
+
+				if builder.Progress != nil {
+					progressDone += file.size
+					builder.Progress(progressDone, progressTotal, file.archiveName())
+				}
+				continue
+			}
+			dedupMap[key] = index
+		}
+
+		if file.Type == TypeREG && file.Sparse {
+			err = builder.writeSparseFile(ctx, tw, cw, file, &hdr)
+			if err != nil {
+				return fmt.Errorf("files[%d]: %w", index, err)
+			}
+
+			if builder.Progress != nil {
+				progressDone += file.size
+				builder.Progress(progressDone, progressTotal, file.archiveName())
+			}
+			continue
 		}
 
 		err = tw.WriteHeader(&hdr)
@@ -156,15 +759,30 @@ func (builder Builder) BuildDataTarball(w io.Writer, manifest *Manifest) error {
 			}
 			needClose = true
 
-			hw := &hashWriter{
-				file:    tw,
-				hashers: make(map[HashAlgorithm]hash.Hash, len(builder.Hashes)),
+			var fw io.Writer = tw
+			if builder.Progress != nil {
+				fw = progressWriter{
+					file:     tw,
+					callback: builder.Progress,
+					done:     &progressDone,
+					total:    progressTotal,
+					name:     file.archiveName(),
+				}
 			}
-			for _, algo := range builder.Hashes {
-				hw.hashers[algo] = algo.New()
+
+			var hw *hashWriter
+			if len(builder.Hashes) != 0 {
+				hw = &hashWriter{
+					file:    fw,
+					hashers: make(map[HashAlgorithm]hash.Hash, len(builder.Hashes)),
+				}
+				for _, algo := range builder.Hashes {
+					hw.hashers[algo] = algo.New()
+				}
+				fw = hw
 			}
 
-			_, err = io.Copy(hw, rc)
+			_, err = io.Copy(fw, ctxReader{ctx, rc})
 			if err != nil {
 				return fmt.Errorf("files[%d]: Copy: %w", index, err)
 			}
@@ -175,9 +793,11 @@ func (builder Builder) BuildDataTarball(w io.Writer, manifest *Manifest) error {
 				return fmt.Errorf("files[%d]: Close: %w", index, err)
 			}
 
-			file.hashes = make(map[HashAlgorithm][]byte, len(builder.Hashes))
-			for _, algo := range builder.Hashes {
-				file.hashes[algo] = hw.hashers[algo].Sum(nil)
+			if hw != nil {
+				file.hashes = make(map[HashAlgorithm][]byte, len(builder.Hashes))
+				for _, algo := range builder.Hashes {
+					file.hashes[algo] = hw.hashers[algo].Sum(nil)
+				}
 			}
 
 			file.isHashed = true
@@ -198,6 +818,147 @@ func (builder Builder) BuildDataTarball(w io.Writer, manifest *Manifest) error {
 	return nil
 }
 
+func (builder Builder) writeSparseFile(ctx context.Context, tw *tar.Writer, w io.Writer, file *File, hdr *tar.Header) error {
+	rc, err := file.Reader(builder.Root)
+	if err != nil {
+		return fmt.Errorf("Open: %w", err)
+	}
+
+	var r io.Reader = ctxReader{ctx, rc}
+	var hw *hashWriter
+	if len(builder.Hashes) != 0 {
+		hw = &hashWriter{
+			file:    io.Discard,
+			hashers: make(map[HashAlgorithm]hash.Hash, len(builder.Hashes)),
+		}
+		for _, algo := range builder.Hashes {
+			hw.hashers[algo] = algo.New()
+		}
+		r = io.TeeReader(r, hw)
+	}
+
+	entries, realSize, err := scanSparse(r)
+	if err != nil {
+		_ = rc.Close()
+		return fmt.Errorf("scan: %w", err)
+	}
+
+	err = rc.Close()
+	if err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+
+	err = tw.Flush()
+	if err != nil {
+		return fmt.Errorf("tar.Flush: %w", err)
+	}
+
+	rc, err = file.Reader(builder.Root)
+	if err != nil {
+		return fmt.Errorf("Open: %w", err)
+	}
+
+	err = writeSparseEntry(w, hdr, entries, realSize, ctxReader{ctx, rc})
+	if err != nil {
+		_ = rc.Close()
+		return fmt.Errorf("sparse: %w", err)
+	}
+
+	err = rc.Close()
+	if err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+
+	if hw != nil {
+		file.hashes = make(map[HashAlgorithm][]byte, len(builder.Hashes))
+		for _, algo := range builder.Hashes {
+			file.hashes[algo] = hw.hashers[algo].Sum(nil)
+		}
+	}
+	file.isHashed = true
+	return nil
+}
+
+func numericOwner(file *File, hdr *tar.Header) error {
+	if file.User.Type == OwnerByName {
+		return fmt.Errorf("user: owner %q has no numeric ID", file.User.Name)
+	}
+	if file.Group.Type == OwnerByName {
+		return fmt.Errorf("group: owner %q has no numeric ID", file.Group.Name)
+	}
+	hdr.Uid, hdr.Uname = 0, "root"
+	if file.User.HasID() {
+		hdr.Uid, hdr.Uname = file.User.ID, ""
+	}
+	hdr.Gid, hdr.Gname = 0, "root"
+	if file.Group.HasID() {
+		hdr.Gid, hdr.Gname = file.Group.ID, ""
+	}
+	return nil
+}
+
+func normalizeMode(file *File, mode int64) int64 {
+	if file.Perm != 0 {
+		return mode
+	}
+
+	var perm int64
+	switch file.Type {
+	case TypeDIR:
+		perm = 0o755
+	case TypeREG:
+		perm = 0o644
+		if isInBinDir(file.Name) || (file.srcMode&0o111) != 0 {
+			perm = 0o755
+		}
+	default:
+		return mode
+	}
+	return (mode &^ 0o777) | perm
+}
+
+type dedupKey struct {
+	digest  [sha256.Size]byte
+	size    int64
+	mode    int64
+	uid     int
+	gid     int
+	uname   string
+	gname   string
+	modTime int64
+}
+
+func (builder Builder) dedupKeyFor(ctx context.Context, file *File, hdr *tar.Header) (dedupKey, error) {
+	rc, err := file.Reader(builder.Root)
+	if err != nil {
+		return dedupKey{}, fmt.Errorf("Open: %w", err)
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, ctxReader{ctx, rc})
+	if err != nil {
+		_ = rc.Close()
+		return dedupKey{}, fmt.Errorf("Copy: %w", err)
+	}
+
+	err = rc.Close()
+	if err != nil {
+		return dedupKey{}, fmt.Errorf("Close: %w", err)
+	}
+
+	key := dedupKey{
+		size:    hdr.Size,
+		mode:    hdr.Mode,
+		uid:     hdr.Uid,
+		gid:     hdr.Gid,
+		uname:   hdr.Uname,
+		gname:   hdr.Gname,
+		modTime: hdr.ModTime.UnixNano(),
+	}
+	h.Sum(key.digest[:0])
+	return key, nil
+}
+
 func (builder Builder) BuildControlTarball(w io.Writer, manifest *Manifest) error {
 	if !manifest.isResolved {
 		panic(fmt.Errorf("must call manifest.Resolve first"))
@@ -207,70 +968,170 @@ func (builder Builder) BuildControlTarball(w io.Writer, manifest *Manifest) erro
 	}
 
 	builder.fillDefaults()
+	builder.resolveCompression(manifest)
 
-	cw, err := builder.Compression.NewWriter(w)
+	err := builder.controlCompression.ValidateLevel(builder.ControlCompressionLevel)
+	if err != nil {
+		return fmt.Errorf("controlCompression: %w", err)
+	}
+
+	cw, err := builder.controlCompression.NewWriterOptions(w, builder.compressOptions(builder.ControlCompressionLevel))
 	if err != nil {
 		return err
 	}
 
 	tw := tar.NewWriter(cw)
 
-	err = builder.writeControlFile(tw, "control", false, manifest.ControlFile())
+	if builder.CompatRelations && manifest.Enhances != "" {
+		err = builder.warn(SeverityWarning, "enhances", "field dropped for compatibility with legacy tools")
+		if err != nil {
+			return err
+		}
+	}
+
+	var members []controlMember
+	addMember := func(name string, isExec bool, data []byte) {
+		if data != nil {
+			members = append(members, controlMember{name: name, isExec: isExec, data: data})
+		}
+	}
+
+	addMember("control", false, formatControlFields(builder.controlFields(manifest)))
+
+	for _, algo := range builder.Hashes {
+		if algo == HashMD5 && builder.SkipMd5sums {
+			continue
+		}
+		if algo != HashMD5 && builder.DpkgControlMembers {
+			continue
+		}
+		sums := builder.checksums(manifest, algo)
+		if len(sums) == 0 && !builder.AlwaysWriteHashMembers {
+			continue
+		}
+		if sums == nil {
+			sums = []byte{}
+		}
+		addMember(algo.FileName(), false, sums)
+	}
+
+	conffiles := manifest.ConfFiles()
+	if builder.SortConffiles {
+		conffiles = sortLines(conffiles)
+	}
+	if conffiles == nil && builder.AlwaysWriteConffiles {
+		conffiles = []byte{}
+	}
+
+	addMember("conffiles", false, conffiles)
+	addMember("preinst", true, manifest.PreInstallScript())
+	addMember("postinst", true, manifest.PostInstallScript())
+	addMember("prerm", true, manifest.PreRemoveScript())
+	addMember("postrm", true, manifest.PostRemoveScript())
+
+	err = builder.checkScriptSize(members)
 	if err != nil {
 		return err
 	}
 
-	var buf bytes.Buffer
-	buf.Grow(4096)
-	for _, algo := range builder.Hashes {
-		for _, file := range manifest.Files {
-			if file.isHashed {
-				buf.WriteString(hex.EncodeToString(file.hashes[algo]))
-				buf.WriteString("  ")
-				buf.WriteString(file.Name)
-				buf.WriteString("\n")
-			}
+	if builder.DpkgControlMembers {
+		err = builder.writeControlDir(tw, "./")
+		if err != nil {
+			return err
+		}
+
+		for index := range members {
+			members[index].name = "./" + members[index].name
 		}
-		err = builder.writeControlFile(tw, algo.FileName(), false, buf.Bytes())
+	}
+
+	for _, member := range members {
+		err = builder.writeControlFile(tw, member.name, member.isExec, member.data)
 		if err != nil {
 			return err
 		}
-		buf.Reset()
 	}
 
-	err = builder.writeControlFile(tw, "conffiles", false, manifest.ConfFiles())
+	err = tw.Close()
 	if err != nil {
 		return err
 	}
 
-	err = builder.writeControlFile(tw, "preinst", true, manifest.PreInstallScript())
+	err = cw.Close()
 	if err != nil {
 		return err
 	}
 
-	err = builder.writeControlFile(tw, "postinst", true, manifest.PostInstallScript())
-	if err != nil {
-		return err
+	return nil
+}
+
+func (builder Builder) checksums(manifest *Manifest, algo HashAlgorithm) []byte {
+	if builder.prebuiltChecksums != nil {
+		return builder.prebuiltChecksums[algo]
 	}
+	return manifest.Checksums(algo)
+}
 
-	err = builder.writeControlFile(tw, "prerm", true, manifest.PreRemoveScript())
-	if err != nil {
-		return err
+func (builder Builder) report(w Warning) error {
+	if builder.OnWarning != nil {
+		builder.OnWarning(w)
 	}
+	if builder.Strict && w.Severity >= SeverityWarning {
+		return fmt.Errorf("strict: %v", w)
+	}
+	return nil
+}
 
-	err = builder.writeControlFile(tw, "postrm", true, manifest.PostRemoveScript())
+func (builder Builder) warn(sev Severity, field string, format string, args ...any) error {
+	return builder.report(Warning{Severity: sev, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (builder Builder) ControlFile(ctx context.Context, manifest *Manifest) ([]byte, error) {
+	err := builder.prepare(ctx, manifest)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return formatControlFields(builder.controlFields(manifest)), nil
+}
 
-	err = tw.Close()
-	if err != nil {
-		return err
+func (builder Builder) controlFields(manifest *Manifest) []ControlField {
+	fields := manifest.ControlFields()
+
+	if builder.CompatRelations && manifest.Enhances != "" {
+		kept := fields[:0]
+		for _, field := range fields {
+			if field.Name != "Enhances" {
+				kept = append(kept, field)
+			}
+		}
+		fields = kept
 	}
 
-	err = cw.Close()
+	var extra []ControlField
+	if builder.StampBuiltBy {
+		extra = append(extra, ControlField{Name: "X-Built-By", Value: "mkdeb/" + toolVersion()})
+	}
+	return insertControlFields(fields, extra)
+}
+
+type controlMember struct {
+	name   string
+	isExec bool
+	data   []byte
+}
+
+func (builder Builder) writeControlDir(w *tar.Writer, name string) error {
+	hdr := tar.Header{
+		Format:   tar.FormatPAX,
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     unixModeDIR | 0o755,
+		ModTime:  builder.ZeroTime,
+	}
+
+	err := w.WriteHeader(&hdr)
 	if err != nil {
-		return err
+		return fmt.Errorf("tar.WriteHeader: %w", err)
 	}
 
 	return nil
@@ -308,55 +1169,150 @@ func (builder Builder) writeControlFile(w *tar.Writer, name string, isExec bool,
 	return nil
 }
 
-func (builder Builder) writeArFile(w io.Writer, controlFile *os.File, dataFile *os.File) error {
+var defaultDebianBinary = []byte("2.0\n")
+
+func AssembleAr(w io.Writer, debianBinary, control, data []byte, controlCompression, dataCompression CompressAlgorithm) error {
+	if controlCompression == CompressAuto {
+		controlCompression = DetectCompression(control)
+	}
+	if err := controlCompression.Validate(); err != nil {
+		return fmt.Errorf("control compression: %w", err)
+	}
+	if dataCompression == CompressAuto {
+		dataCompression = DetectCompression(data)
+	}
+	if err := dataCompression.Validate(); err != nil {
+		return fmt.Errorf("data compression: %w", err)
+	}
+	if len(debianBinary) == 0 {
+		debianBinary = defaultDebianBinary
+	}
+
+	var builder Builder
+	builder.Compression = dataCompression
+	builder.controlCompression = controlCompression
+	_, err := builder.writeArFile(w, debianBinary, bytes.NewReader(control), bytes.NewReader(data))
+	return err
+}
+
+func (builder Builder) writeArFile(w io.Writer, debianBinary []byte, controlFile io.ReadSeeker, dataFile io.ReadSeeker) ([]ArMember, error) {
 	controlSize, err := controlFile.Seek(0, io.SeekEnd)
 	if err != nil {
-		return fmt.Errorf("Seek: end: %w", err)
+		return nil, fmt.Errorf("Seek: end: %w", err)
 	}
 
 	_, err = controlFile.Seek(0, io.SeekStart)
 	if err != nil {
-		return fmt.Errorf("Seek: start: %w", err)
+		return nil, fmt.Errorf("Seek: start: %w", err)
 	}
 
 	dataSize, err := dataFile.Seek(0, io.SeekEnd)
 	if err != nil {
-		return fmt.Errorf("Seek: end: %w", err)
+		return nil, fmt.Errorf("Seek: end: %w", err)
 	}
 
 	_, err = dataFile.Seek(0, io.SeekStart)
 	if err != nil {
-		return fmt.Errorf("Seek: start: %w", err)
+		return nil, fmt.Errorf("Seek: start: %w", err)
 	}
 
-	arMagic := []byte("!<arch>\n")
-	_, err = w.Write(arMagic)
+	_, err = io.WriteString(w, arMagic)
 	if err != nil {
-		return fmt.Errorf("Write: %w", err)
+		return nil, fmt.Errorf("Write: %w", err)
+	}
+
+	members := make([]ArMember, 0, 3)
+	offset := int64(len(arMagic))
+	addMember := func(name string, size int64) {
+		members = append(members, ArMember{
+			Name:         name,
+			HeaderOffset: offset,
+			DataOffset:   offset + arHeaderSize,
+			Size:         size,
+		})
+		offset += arHeaderSize + size + (size & 1)
 	}
 
-	debianBinary := []byte("2.0\n")
-	err = writeArEntry(w, "debian-binary", int64(len(debianBinary)), bytes.NewReader(debianBinary))
+	attrs := builder.arAttrs()
+
+	err = writeArEntry(w, "debian-binary", int64(len(debianBinary)), attrs, bytes.NewReader(debianBinary))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	addMember("debian-binary", int64(len(debianBinary)))
 
-	suffix := builder.Compression.Suffix()
+	controlName := "control.tar" + builder.controlCompression.Suffix()
+	err = writeArEntry(w, controlName, controlSize, attrs, controlFile)
+	if err != nil {
+		return nil, err
+	}
+	addMember(controlName, controlSize)
 
-	err = writeArEntry(w, "control.tar"+suffix, controlSize, controlFile)
+	dataName := "data.tar" + builder.Compression.Suffix()
+	err = writeArEntry(w, dataName, dataSize, attrs, dataFile)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	addMember(dataName, dataSize)
+
+	return members, nil
+}
+
+const arMagic = "!<arch>\n"
+
+const arHeaderSize = 60
+
+type ArMember struct {
+	Name         string `json:"name"`
+	HeaderOffset int64  `json:"headerOffset"`
+	DataOffset   int64  `json:"dataOffset"`
+	Size         int64  `json:"size"`
+}
+
+type arAttrs struct {
+	uid  int
+	gid  int
+	mode int64
+}
+
+const defaultArMode = 0o100644
+
+func (builder Builder) arAttrs() arAttrs {
+	mode := builder.ArMode
+	if mode == 0 {
+		mode = defaultArMode
 	}
+	return arAttrs{uid: builder.ArUID, gid: builder.ArGID, mode: mode}
+}
 
-	err = writeArEntry(w, "data.tar"+suffix, dataSize, dataFile)
+func (attrs arAttrs) Validate() error {
+	if attrs.uid < 0 || attrs.uid > 999999 {
+		return fmt.Errorf("arUID: value %d does not fit in 6 decimal digits", attrs.uid)
+	}
+	if attrs.gid < 0 || attrs.gid > 999999 {
+		return fmt.Errorf("arGID: value %d does not fit in 6 decimal digits", attrs.gid)
+	}
+	if attrs.mode < 0 || attrs.mode > 0o77777777 {
+		return fmt.Errorf("arMode: value %o does not fit in 8 octal digits", attrs.mode)
+	}
+	return nil
+}
+
+func writeArEntry(w io.Writer, name string, size int64, attrs arAttrs, r io.Reader) error {
+	err := writeArHeader(w, name, size, attrs)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("Copy: %w", err)
+	}
+
+	return writeArPadding(w, size)
 }
 
-func writeArEntry(w io.Writer, name string, size int64, r io.Reader) error {
+func writeArHeader(w io.Writer, name string, size int64, attrs arAttrs) error {
 	if len(name) > 16 {
 		panic(fmt.Errorf("name %q exceeds 16 bytes", name))
 	}
@@ -367,8 +1323,11 @@ func writeArEntry(w io.Writer, name string, size int64, r io.Reader) error {
 	if len(sizeString) != 10 {
 		panic(fmt.Errorf("internal error: formatted size %q should be exactly %d bytes, but got %d bytes", sizeString, 10, len(sizeString)))
 	}
+	uidString := fmt.Sprintf("%-6d", attrs.uid)
+	gidString := fmt.Sprintf("%-6d", attrs.gid)
+	modeString := fmt.Sprintf("%-8o", attrs.mode)
 
-	hdr := [60]byte{
+	hdr := [arHeaderSize]byte{
 		'?', '?', '?', '?', '?', '?', '?', '?',
 		'?', '?', '?', '?', '?', '?', '?', '?',
 		'1', '5', '7', '7', '8', '3', '6', '8',
@@ -387,6 +1346,15 @@ func writeArEntry(w io.Writer, name string, size int64, r io.Reader) error {
 		hdr[i] = ch
 	}
 
+	for i := 0; i < 6; i++ {
+		hdr[i+28] = uidString[i]
+		hdr[i+34] = gidString[i]
+	}
+
+	for i := 0; i < 8; i++ {
+		hdr[i+40] = modeString[i]
+	}
+
 	for i := 0; i < 10; i++ {
 		hdr[i+48] = sizeString[i]
 	}
@@ -396,14 +1364,13 @@ func writeArEntry(w io.Writer, name string, size int64, r io.Reader) error {
 		return fmt.Errorf("Write: %w", err)
 	}
 
-	_, err = io.Copy(w, r)
-	if err != nil {
-		return fmt.Errorf("Copy: %w", err)
-	}
+	return nil
+}
 
+func writeArPadding(w io.Writer, size int64) error {
 	if (size & 1) != 0 {
 		pad := []byte{'\n'}
-		_, err = w.Write(pad)
+		_, err := w.Write(pad)
 		if err != nil {
 			return fmt.Errorf("Write: %w", err)
 		}
@@ -0,0 +1,1882 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type testArMember struct {
+	name string
+	data []byte
+}
+
+func testManifest(t *testing.T, js string) *Manifest {
+	t.Helper()
+	manifest, err := ManifestFromJSON([]byte(js))
+	if err != nil {
+		t.Fatalf("ManifestFromJSON: %v", err)
+	}
+	return manifest
+}
+
+const testFooFields = `"package": "foo", "version": "1.0", "arch": "all", "maintainer": "x <x@example.com>", "shortDescription": "foo bar"`
+
+func testFooJSON(fields string) string {
+	if fields == "" {
+		return "{" + testFooFields + "}"
+	}
+	return "{" + testFooFields + ", " + fields + "}"
+}
+
+func testFooManifest(t *testing.T, fields string) *Manifest {
+	t.Helper()
+	return testManifest(t, testFooJSON(fields))
+}
+
+func testBuild(t *testing.T, builder Builder, manifest *Manifest) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := builder.Build(&buf, manifest)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func testReadAr(t *testing.T, data []byte) []testArMember {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(arMagic)) {
+		t.Fatalf("ar: missing magic header")
+	}
+	data = data[len(arMagic):]
+
+	var out []testArMember
+	for len(data) != 0 {
+		if len(data) < 60 {
+			t.Fatalf("ar: truncated header")
+		}
+		name := strings.TrimRight(string(data[:16]), " ")
+		size, err := strconv.Atoi(strings.TrimRight(string(data[48:58]), " "))
+		if err != nil {
+			t.Fatalf("ar: %s: bad size: %v", name, err)
+		}
+		data = data[60:]
+		if len(data) < size {
+			t.Fatalf("ar: %s: truncated data", name)
+		}
+		out = append(out, testArMember{name: name, data: data[:size]})
+		data = data[size+(size&1):]
+	}
+	return out
+}
+
+func testArMemberData(t *testing.T, members []testArMember, prefix string) (string, []byte) {
+	t.Helper()
+	for _, member := range members {
+		if strings.HasPrefix(member.name, prefix) {
+			return member.name, member.data
+		}
+	}
+	t.Fatalf("ar: no member named %s*", prefix)
+	return "", nil
+}
+
+func testDecompress(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: gzip: %v", name, err)
+		}
+		r = zr
+	case strings.HasSuffix(name, ".xz"):
+		zr, err := xz.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: xz: %v", name, err)
+		}
+		r = zr
+	case strings.HasSuffix(name, ".zst"):
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: zstd: %v", name, err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return data
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: decompress: %v", name, err)
+	}
+	return out
+}
+
+func testReadTar(t *testing.T, data []byte) ([]*tar.Header, map[string][]byte) {
+	t.Helper()
+	tr := tar.NewReader(bytes.NewReader(data))
+	var headers []*tar.Header
+	contents := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("tar: %s: %v", hdr.Name, err)
+		}
+		headers = append(headers, hdr)
+		contents[hdr.Name] = body
+	}
+	return headers, contents
+}
+
+func testMemberTar(t *testing.T, pkg []byte, prefix string) ([]*tar.Header, map[string][]byte) {
+	t.Helper()
+	name, data := testArMemberData(t, testReadAr(t, pkg), prefix)
+	return testReadTar(t, testDecompress(t, name, data))
+}
+
+func TestDedupHardlinksIdenticalFiles(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "same\n"},
+		{"name": "etc/b", "text": "same\n"},
+		{"name": "etc/c", "text": "different\n"}
+	]`)
+	pkg := testBuild(t, Builder{Dedup: true, Compression: CompressNone}, manifest)
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	types := make(map[string]byte, len(headers))
+	links := make(map[string]string, len(headers))
+	for _, hdr := range headers {
+		types[hdr.Name] = hdr.Typeflag
+		links[hdr.Name] = hdr.Linkname
+	}
+	if types["etc/b"] != tar.TypeLink || links["etc/b"] != "etc/a" {
+		t.Errorf("etc/b: expected hardlink to etc/a, got type %q link %q", types["etc/b"], links["etc/b"])
+	}
+	if types["etc/c"] != tar.TypeReg {
+		t.Errorf("etc/c: expected regular file, got type %q", types["etc/c"])
+	}
+}
+
+func TestDedupKeyHonorsContext(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [{"name": "a", "text": "hello\n"}]`)
+	if err := manifest.Resolve(nil); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	file := &manifest.Files[0]
+	hdr := file.AsTarHeader()
+	_, err := Builder{}.dedupKeyFor(ctx, file, &hdr)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDataTarballTermination(t *testing.T) {
+	for _, algo := range []CompressAlgorithm{CompressNone, CompressGZIP, CompressXZ, CompressZSTD} {
+		t.Run(algo.String(), func(t *testing.T) {
+			manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+			pkg := testBuild(t, Builder{Compression: algo}, manifest)
+
+			name, data := testArMemberData(t, testReadAr(t, pkg), "data.tar")
+			data = testDecompress(t, name, data)
+			if len(data)%tarBlockSize != 0 {
+				t.Errorf("%s: length %d is not a multiple of %d", name, len(data), tarBlockSize)
+			}
+			if len(data) < 3*tarBlockSize {
+				t.Fatalf("%s: length %d is too short", name, len(data))
+			}
+			trailer := data[len(data)-2*tarBlockSize:]
+			if !bytes.Equal(trailer, make([]byte, 2*tarBlockSize)) {
+				t.Errorf("%s: does not end with two zero blocks", name)
+			}
+		})
+	}
+}
+
+func TestNormalizePermsKeepsExplicitPerm(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/", "perm": "0700"},
+		{"name": "etc/secret", "text": "s\n", "perm": "0600"},
+		{"name": "etc/plain", "text": "p\n"},
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/tool", "text": "#!/bin/sh\n", "perm": "0750"},
+		{"name": "usr/bin/other", "text": "#!/bin/sh\n"}
+	]`)
+	pkg := testBuild(t, Builder{NormalizePerms: true, Compression: CompressNone}, manifest)
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	modes := make(map[string]int64, len(headers))
+	for _, hdr := range headers {
+		modes[hdr.Name] = hdr.Mode & 0o7777
+	}
+
+	expect := map[string]int64{
+		"etc/":          0o700,
+		"etc/secret":    0o600,
+		"etc/plain":     0o644,
+		"usr/bin/tool":  0o750,
+		"usr/bin/other": 0o755,
+	}
+	for name, mode := range expect {
+		if modes[name] != mode {
+			t.Errorf("%s: expected mode %04o, got %04o", name, mode, modes[name])
+		}
+	}
+}
+
+func TestAssembleArMixedCompression(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+	pkg := testBuild(t, Builder{Compression: CompressXZ}, manifest)
+	members := testReadAr(t, pkg)
+	_, control := testArMemberData(t, members, "control.tar")
+	_, data := testArMemberData(t, members, "data.tar")
+
+	for _, explicit := range []bool{false, true} {
+		controlAlgo, dataAlgo := CompressAuto, CompressAuto
+		if explicit {
+			controlAlgo, dataAlgo = CompressGZIP, CompressXZ
+		}
+
+		var buf bytes.Buffer
+		err := AssembleAr(&buf, nil, control, data, controlAlgo, dataAlgo)
+		if err != nil {
+			t.Fatalf("AssembleAr(%v, %v): %v", controlAlgo, dataAlgo, err)
+		}
+
+		var names []string
+		for _, member := range testReadAr(t, buf.Bytes()) {
+			names = append(names, member.name)
+		}
+		expect := []string{"debian-binary", "control.tar.gz", "data.tar.xz"}
+		if strings.Join(names, " ") != strings.Join(expect, " ") {
+			t.Errorf("AssembleAr(%v, %v): expected members %q, got %q", controlAlgo, dataAlgo, expect, names)
+		}
+
+		_, contents := testMemberTar(t, buf.Bytes(), "data.tar")
+		if string(contents["etc/a"]) != "hello\n" {
+			t.Errorf("AssembleAr(%v, %v): etc/a: got %q", controlAlgo, dataAlgo, contents["etc/a"])
+		}
+	}
+}
+
+func TestAssembleArRoundTrip(t *testing.T) {
+	type testRow struct {
+		data    CompressAlgorithm
+		control CompressAlgorithm
+	}
+
+	testData := [...]testRow{
+		{CompressGZIP, CompressAuto},
+		{CompressXZ, CompressAuto},
+		{CompressZSTD, CompressAuto},
+		{CompressNone, CompressAuto},
+		{CompressZSTD, CompressXZ},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n", "isConf": true}]`)
+		pkg := testBuild(t, Builder{Compression: row.data, ControlCompression: row.control}, manifest)
+
+		members := testReadAr(t, pkg)
+		_, debianBinary := testArMemberData(t, members, "debian-binary")
+		_, control := testArMemberData(t, members, "control.tar")
+		_, data := testArMemberData(t, members, "data.tar")
+
+		var buf bytes.Buffer
+		err := AssembleAr(&buf, debianBinary, control, data, CompressAuto, CompressAuto)
+		if err != nil {
+			t.Fatalf("data %v, control %v: AssembleAr: %v", row.data, row.control, err)
+		}
+		if !bytes.Equal(buf.Bytes(), pkg) {
+			t.Errorf("data %v, control %v: reassembled package differs from the original", row.data, row.control)
+		}
+	}
+}
+
+func TestControlCompressionDefault(t *testing.T) {
+	type testRow struct {
+		data          CompressAlgorithm
+		control       CompressAlgorithm
+		expectData    string
+		expectControl string
+	}
+
+	testData := [...]testRow{
+		{CompressXZ, CompressAuto, "data.tar.xz", "control.tar.gz"},
+		{CompressZSTD, CompressAuto, "data.tar.zst", "control.tar.gz"},
+		{CompressGZIP, CompressAuto, "data.tar.gz", "control.tar.gz"},
+		{CompressNone, CompressAuto, "data.tar", "control.tar"},
+		{CompressXZ, CompressZSTD, "data.tar.xz", "control.tar.zst"},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+		pkg := testBuild(t, Builder{Compression: row.data, ControlCompression: row.control}, manifest)
+
+		var names []string
+		for _, member := range testReadAr(t, pkg) {
+			names = append(names, member.name)
+		}
+		expect := []string{"debian-binary", row.expectControl, row.expectData}
+		if strings.Join(names, " ") != strings.Join(expect, " ") {
+			t.Errorf("data %v, control %v: expected members %q, got %q", row.data, row.control, expect, names)
+		}
+	}
+}
+
+func TestRewriteHeaderConsistentNames(t *testing.T) {
+	js := testFooJSON(`"files": [
+		{"name": "etc/"},
+		{"name": "etc/foo.conf", "text": "a=b\n", "isConf": true},
+		{"name": "etc/plain", "text": "plain\n"}
+	]`)
+
+	for _, useBuildAt := range []bool{false, true} {
+		calls := make(map[string]int)
+		builder := Builder{
+			Compression: CompressNone,
+			RewriteHeader: func(hdr *tar.Header, file *File) {
+				calls[file.Name]++
+				if hdr.ModTime.IsZero() || hdr.Format == tar.FormatUnknown {
+					t.Errorf("%s: hook saw an unnormalized header", file.Name)
+				}
+				if hdr.Name == "etc/foo.conf" {
+					hdr.Name = "etc/bar.conf"
+				}
+			},
+		}
+
+		manifest := testManifest(t, js)
+		var pkg []byte
+		if useBuildAt {
+			var buf memWriterAt
+			if err := builder.BuildAt(&buf, manifest); err != nil {
+				t.Fatalf("BuildAt: %v", err)
+			}
+			pkg = buf.data
+		} else {
+			pkg = testBuild(t, builder, manifest)
+		}
+
+		for name, count := range calls {
+			if count != 1 {
+				t.Errorf("BuildAt=%v: %s: expected the hook to run once, ran %d times", useBuildAt, name, count)
+			}
+		}
+
+		_, control := testMemberTar(t, pkg, "control.tar")
+		if got := string(control["conffiles"]); got != "etc/bar.conf\n" {
+			t.Errorf("BuildAt=%v: conffiles: expected %q, got %q", useBuildAt, "etc/bar.conf\n", got)
+		}
+		md5sums := string(control["md5sums"])
+		if !strings.Contains(md5sums, "  etc/bar.conf\n") || strings.Contains(md5sums, "foo.conf") {
+			t.Errorf("BuildAt=%v: md5sums: expected the rewritten name, got %q", useBuildAt, md5sums)
+		}
+
+		_, data := testMemberTar(t, pkg, "data.tar")
+		if string(data["etc/bar.conf"]) != "a=b\n" {
+			t.Errorf("BuildAt=%v: data.tar: expected etc/bar.conf, got %q", useBuildAt, data["etc/bar.conf"])
+		}
+	}
+}
+
+type memWriterAt struct {
+	data []byte
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	copy(m.data[off:], p)
+	return len(p), nil
+}
+
+func TestNoHashes(t *testing.T) {
+	js := testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}, {"name": "var/", "keepEmpty": true}]`)
+
+	for _, builder := range []Builder{
+		{Hashes: []HashAlgorithm{}},
+		{Hashes: []HashAlgorithm{}, AlwaysWriteHashMembers: true},
+	} {
+		manifest := testManifest(t, js)
+		pkg := testBuild(t, builder, manifest)
+
+		headers, _ := testMemberTar(t, pkg, "control.tar")
+		for _, hdr := range headers {
+			for _, algo := range standardHashes {
+				if hdr.Name == algo.FileName() {
+					t.Errorf("AlwaysWriteHashMembers=%v: unexpected member %q", builder.AlwaysWriteHashMembers, hdr.Name)
+				}
+			}
+		}
+		for _, file := range manifest.Files {
+			if file.hashes != nil {
+				t.Errorf("AlwaysWriteHashMembers=%v: %s: expected no hashes to be computed, got %v", builder.AlwaysWriteHashMembers, file.Name, file.hashes)
+			}
+		}
+	}
+
+	manifest := testManifest(t, js)
+	var buf memWriterAt
+	if err := (Builder{Hashes: []HashAlgorithm{}}).BuildAt(&buf, manifest); err != nil {
+		t.Fatalf("BuildAt: %v", err)
+	}
+	headers, _ := testMemberTar(t, buf.data, "control.tar")
+	for _, hdr := range headers {
+		if hdr.Name == "md5sums" {
+			t.Errorf("BuildAt: unexpected md5sums member")
+		}
+	}
+}
+
+func TestSelectedHashes(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+	pkg := testBuild(t, Builder{Hashes: []HashAlgorithm{HashSHA256}}, manifest)
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if _, found := control["md5sums"]; found {
+		t.Errorf("unexpected md5sums member")
+	}
+	expect := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  etc/a\n"
+	if got := string(control[HashSHA256.FileName()]); got != expect {
+		t.Errorf("%s: expected %q, got %q", HashSHA256.FileName(), expect, got)
+	}
+}
+
+func TestSortConffiles(t *testing.T) {
+	js := testFooJSON(`"files": [
+		{"name": "etc/"},
+		{"name": "etc/z.conf", "isConf": true, "text": "z\n"},
+		{"name": "etc/m.conf", "text": "m\n"},
+		{"name": "etc/a.conf", "isConf": true, "text": "a\n"},
+		{"name": "etc/b.conf", "isConf": true, "text": "b\n"}
+	]`)
+
+	type testRow struct {
+		sort   bool
+		expect string
+	}
+
+	testData := [...]testRow{
+		{false, "etc/z.conf\netc/a.conf\netc/b.conf\n"},
+		{true, "etc/a.conf\netc/b.conf\netc/z.conf\n"},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{SortConffiles: row.sort}, testManifest(t, js))
+		_, control := testMemberTar(t, pkg, "control.tar")
+		if got := string(control["conffiles"]); got != row.expect {
+			t.Errorf("SortConffiles=%v: expected %q, got %q", row.sort, row.expect, got)
+		}
+	}
+}
+
+func TestBuildContextCanceled(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "aaaa\n"},
+		{"name": "etc/b", "text": "bbbb\n"},
+		{"name": "etc/c", "text": "cccc\n"}
+	]`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempDir := t.TempDir()
+	var seen []string
+	builder := Builder{
+		TempDir:           tempDir,
+		InMemoryThreshold: 1,
+		Progress: func(done, total int64, currentFile string) {
+			seen = append(seen, currentFile)
+			cancel()
+		},
+	}
+
+	var buf bytes.Buffer
+	err := builder.BuildContext(ctx, &buf, manifest)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(seen) == 0 || seen[len(seen)-1] == "etc/c" {
+		t.Errorf("expected the build to stop partway, saw progress for %q", seen)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no output, got %d bytes", buf.Len())
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("temporary entry %q was not removed", entry.Name())
+	}
+}
+
+func TestSpecialBits(t *testing.T) {
+	const files = `"files": [
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/su", "text": "x\n", "perm": "04755"},
+		{"name": "usr/bin/wall", "text": "x\n", "perm": "02755"},
+		{"name": "tmp/", "perm": "01777", "keepEmpty": true}
+	]`
+
+	type testRow struct {
+		strip  bool
+		expect map[string]int64
+	}
+
+	testData := [...]testRow{
+		{false, map[string]int64{"usr/bin/su": 0o4755, "usr/bin/wall": 0o2755, "tmp/": 0o1777}},
+		{true, map[string]int64{"usr/bin/su": 0o0755, "usr/bin/wall": 0o0755, "tmp/": 0o1777}},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{StripSpecialBits: row.strip}, testFooManifest(t, files))
+		headers, _ := testMemberTar(t, pkg, "data.tar")
+		for _, hdr := range headers {
+			want, found := row.expect[hdr.Name]
+			if found && hdr.Mode&0o7777 != want {
+				t.Errorf("StripSpecialBits=%v: %s: expected mode %04o, got %04o", row.strip, hdr.Name, want, hdr.Mode&0o7777)
+			}
+			delete(row.expect, hdr.Name)
+		}
+		for name := range row.expect {
+			t.Errorf("StripSpecialBits=%v: %s: missing from data.tar", row.strip, name)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "hello\n"},
+		{"name": "etc/b", "text": "same\n"},
+		{"name": "etc/c", "text": "same\n"},
+		{"name": "etc/empty", "text": ""},
+		{"name": "etc/big", "bytesHex": "`+strings.Repeat("00", 5000)+`"}
+	]`)
+
+	type progressCall struct {
+		done, total int64
+		name        string
+	}
+	var calls []progressCall
+	builder := Builder{
+		Dedup: true,
+		Progress: func(done, total int64, currentFile string) {
+			calls = append(calls, progressCall{done, total, currentFile})
+		},
+	}
+	testBuild(t, builder, manifest)
+
+	if len(calls) == 0 {
+		t.Fatalf("expected progress callbacks")
+	}
+	var sum int64
+	for _, file := range manifest.Files {
+		sum += file.size
+	}
+	last := calls[len(calls)-1]
+	if last.done != sum {
+		t.Errorf("expected final done %d, got %d", sum, last.done)
+	}
+	for index, call := range calls {
+		if call.total != manifest.installedSize {
+			t.Errorf("call %d: expected total %d, got %d", index, manifest.installedSize, call.total)
+		}
+		if index > 0 && call.done < calls[index-1].done {
+			t.Errorf("call %d: done went backwards from %d to %d", index, calls[index-1].done, call.done)
+		}
+	}
+	if last.name != "etc/big" {
+		t.Errorf("expected the last file to be etc/big, got %q", last.name)
+	}
+}
+
+func TestBuildTempDir(t *testing.T) {
+	type testRow struct {
+		name       string
+		threshold  int64
+		expectTemp bool
+	}
+
+	testData := [...]testRow{
+		{"small package, default threshold", 0, false},
+		{"small package, 1MiB threshold", 1 << 20, false},
+		{"large package, 1-byte threshold", 1, true},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+
+		tempDir := t.TempDir()
+		var during []string
+		calls := 0
+		builder := Builder{
+			TempDir:           tempDir,
+			InMemoryThreshold: row.threshold,
+			Progress: func(done, total int64, currentFile string) {
+				calls++
+				entries, err := os.ReadDir(tempDir)
+				if err != nil {
+					t.Errorf("ReadDir: %v", err)
+					return
+				}
+				during = during[:0]
+				for _, entry := range entries {
+					during = append(during, entry.Name())
+				}
+			},
+		}
+		testBuild(t, builder, manifest)
+
+		if calls == 0 {
+			t.Fatalf("%s: expected progress callbacks", row.name)
+		}
+		if row.expectTemp {
+			if len(during) != 1 || !strings.HasPrefix(during[0], "mkdeb-") || !strings.HasSuffix(during[0], ".d") {
+				t.Errorf("%s: expected one mkdeb-*.d directory in %q during the build, got %q", row.name, tempDir, during)
+			}
+		} else if len(during) != 0 {
+			t.Errorf("%s: expected no temp files during the build, got %q", row.name, during)
+		}
+		entries, err := os.ReadDir(tempDir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%s: expected %q to be empty after the build, found %d entries", row.name, tempDir, len(entries))
+		}
+	}
+}
+
+func TestZstdDeterministic(t *testing.T) {
+	const files = `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}, {"name": "etc/b", "text": "world\n"}]`
+
+	for _, windowSize := range []int{0, 1 << 10, 1 << 20} {
+		var outputs [2][]byte
+		for index := range outputs {
+			outputs[index] = testBuild(t, Builder{Compression: CompressZSTD, ZstdWindowSize: windowSize}, testFooManifest(t, files))
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("window size %d: two builds produced different bytes", windowSize)
+		}
+		testMemberTar(t, outputs[0], "data.tar.zst")
+	}
+}
+
+func TestStampBuiltBy(t *testing.T) {
+	savedKeys, savedMap := versionDataKeys, versionDataMap
+	t.Cleanup(func() { versionDataKeys, versionDataMap = savedKeys, savedMap })
+	SetVersion("version", "1.2.3", "commit", "abcdef")
+
+	for _, stamp := range []bool{false, true} {
+		pkg := testBuild(t, Builder{StampBuiltBy: stamp}, testFooManifest(t, ""))
+		_, control := testMemberTar(t, pkg, "control.tar")
+		found := strings.Contains(string(control["control"]), "\nX-Built-By: mkdeb/1.2.3\nDescription: ")
+		if found != stamp {
+			t.Errorf("StampBuiltBy=%v: expected X-Built-By %v, got %q", stamp, stamp, control["control"])
+		}
+		if !stamp && strings.Contains(string(control["control"]), "X-Built-By") {
+			t.Errorf("StampBuiltBy=%v: unexpected X-Built-By field", stamp)
+		}
+	}
+}
+
+func TestFileModTime(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "a\n"},
+		{"name": "etc/b", "text": "b\n"},
+		{"name": "etc/c", "text": "c\n", "mtime": "2001-01-01T00:00:00Z"},
+		{"name": "etc/d", "text": "d\n"}
+	]`)
+
+	zero := time.Unix(1000000000, 0).UTC()
+	hook := map[string]time.Time{
+		"etc/a": time.Unix(1500000000, 0).UTC(),
+		"etc/b": time.Unix(1600000000, 0).UTC(),
+		"etc/c": time.Unix(1700000000, 0).UTC(),
+	}
+	builder := Builder{
+		ZeroTime: zero,
+		FileModTime: func(name string) (time.Time, bool) {
+			t, found := hook[name]
+			return t, found
+		},
+	}
+	pkg := testBuild(t, builder, manifest)
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+
+	expect := map[string]time.Time{
+		"etc/":  zero,
+		"etc/a": hook["etc/a"],
+		"etc/b": hook["etc/b"],
+		"etc/c": time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		"etc/d": zero,
+	}
+	for _, hdr := range headers {
+		if want, found := expect[hdr.Name]; found && !hdr.ModTime.Equal(want) {
+			t.Errorf("%s: expected mtime %v, got %v", hdr.Name, want, hdr.ModTime)
+		}
+		delete(expect, hdr.Name)
+	}
+	for name := range expect {
+		t.Errorf("%s: missing from data.tar", name)
+	}
+}
+
+func TestNormalizePermsFromSource(t *testing.T) {
+	root := fstest.MapFS{
+		"etc/wide":    {Data: []byte("w\n"), Mode: 0o666},
+		"etc/script":  {Data: []byte("#!/bin/sh\n"), Mode: 0o777},
+		"usr/bin/run": {Data: []byte("#!/bin/sh\n"), Mode: 0o644},
+	}
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/wide"},
+		{"name": "etc/script"},
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/run"}
+	]`)
+	pkg := testBuild(t, Builder{Root: root, NormalizePerms: true}, manifest)
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	modes := make(map[string]int64, len(headers))
+	for _, hdr := range headers {
+		modes[hdr.Name] = hdr.Mode & 0o7777
+	}
+
+	expect := map[string]int64{
+		"etc/":        0o755,
+		"etc/wide":    0o644,
+		"etc/script":  0o755,
+		"usr/bin/run": 0o755,
+	}
+	for name, mode := range expect {
+		if modes[name] != mode {
+			t.Errorf("%s: expected mode %04o, got %04o", name, mode, modes[name])
+		}
+	}
+}
+
+func TestMd5sums(t *testing.T) {
+	js := testFooJSON(`"files": [
+		{"name": "usr/"},
+		{"name": "usr/share/"},
+		{"name": "usr/share/z", "text": "z\n"},
+		{"name": "etc/"},
+		{"name": "etc/b", "text": "b\n"},
+		{"name": "etc/a", "text": "a\n"},
+		{"name": "usr/share/m", "text": "m\n"}
+	]`)
+
+	pkg := testBuild(t, Builder{}, testManifest(t, js))
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	var dataOrder []string
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			dataOrder = append(dataOrder, hdr.Name)
+		}
+	}
+	_, control := testMemberTar(t, pkg, "control.tar")
+	var md5Order []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(control["md5sums"]), "\n"), "\n") {
+		md5Order = append(md5Order, line[strings.Index(line, "  ")+2:])
+	}
+	if strings.Join(md5Order, " ") != strings.Join(dataOrder, " ") {
+		t.Errorf("md5sums order %q does not match data.tar order %q", md5Order, dataOrder)
+	}
+
+	pkg = testBuild(t, Builder{SkipMd5sums: true}, testManifest(t, js))
+	_, control = testMemberTar(t, pkg, "control.tar")
+	if _, found := control["md5sums"]; found {
+		t.Errorf("SkipMd5sums: unexpected md5sums member")
+	}
+	if sums := string(control[HashSHA256.FileName()]); strings.Count(sums, "\n") != len(dataOrder) {
+		t.Errorf("SkipMd5sums: expected %s with %d lines, got %q", HashSHA256.FileName(), len(dataOrder), sums)
+	}
+}
+
+func TestAutoCompression(t *testing.T) {
+	small := testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+	large := testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/a", "bytesHex": "` + strings.Repeat("00", 5000) + `"}]`)
+
+	type testRow struct {
+		name      string
+		js        string
+		threshold int64
+		expect    string
+	}
+
+	testData := [...]testRow{
+		{"small, default threshold", small, 0, "data.tar.gz"},
+		{"large, default threshold", large, 0, "data.tar.gz"},
+		{"small, 8KiB threshold", small, 8192, "data.tar.gz"},
+		{"large, 8KiB threshold", large, 8192, "data.tar.zst"},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{Compression: CompressAuto, AutoZstdThreshold: row.threshold}, testManifest(t, row.js))
+		name, _ := testArMemberData(t, testReadAr(t, pkg), "data.tar")
+		if name != row.expect {
+			t.Errorf("%s: expected %s, got %s", row.name, row.expect, name)
+		}
+	}
+}
+
+func TestCompressionLevels(t *testing.T) {
+	const gzipXFLBest, gzipXFLFastest = 2, 4
+
+	type testRow struct {
+		name          string
+		builder       Builder
+		expectData    byte
+		expectControl byte
+	}
+
+	testData := [...]testRow{
+		{"defaults", Builder{Compression: CompressGZIP}, gzipXFLBest, gzipXFLBest},
+		{"data level only", Builder{Compression: CompressGZIP, CompressionLevel: 1}, gzipXFLFastest, gzipXFLBest},
+		{"control level only", Builder{Compression: CompressGZIP, ControlCompressionLevel: 1}, gzipXFLBest, gzipXFLFastest},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, row.builder, testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`))
+		members := testReadAr(t, pkg)
+		_, data := testArMemberData(t, members, "data.tar.gz")
+		_, control := testArMemberData(t, members, "control.tar.gz")
+		if data[8] != row.expectData {
+			t.Errorf("%s: data.tar.gz: expected XFL %d, got %d", row.name, row.expectData, data[8])
+		}
+		if control[8] != row.expectControl {
+			t.Errorf("%s: control.tar.gz: expected XFL %d, got %d", row.name, row.expectControl, control[8])
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Builder{ControlCompressionLevel: 42}.Build(&buf, testFooManifest(t, ""))
+	if err == nil || !strings.HasPrefix(err.Error(), "controlCompression: level 42 is out of range for gzip") {
+		t.Errorf("expected an out-of-range control level error, got %v", err)
+	}
+}
+
+func TestAutoCompressionMembers(t *testing.T) {
+	type testRow struct {
+		name          string
+		size          int
+		expectControl string
+		expectData    string
+	}
+
+	testData := [...]testRow{
+		{"small payload", 100, "control.tar.gz", "data.tar.gz"},
+		{"large payload", 20000, "control.tar.gz", "data.tar.zst"},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "bytesHex": "`+strings.Repeat("00", row.size)+`"}]`)
+		var outputs [2][]byte
+		for index := range outputs {
+			outputs[index] = testBuild(t, Builder{Compression: CompressAuto, AutoZstdThreshold: 16384}, manifest)
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("%s: two builds produced different bytes", row.name)
+		}
+		members := testReadAr(t, outputs[0])
+		if members[1].name != row.expectControl || members[2].name != row.expectData {
+			t.Errorf("%s: expected %s and %s, got %s and %s", row.name, row.expectControl, row.expectData, members[1].name, members[2].name)
+		}
+	}
+}
+
+func TestPruneEmptyDirs(t *testing.T) {
+	const files = `"files": [
+		{"name": "etc/"},
+		{"name": "etc/foo/"},
+		{"name": "etc/foo/a", "text": "a\n"},
+		{"name": "var/"},
+		{"name": "var/cache/"},
+		{"name": "var/cache/foo/"},
+		{"name": "srv/", "keepEmpty": true}
+	]`
+
+	type testRow struct {
+		prune  bool
+		expect string
+	}
+
+	testData := [...]testRow{
+		{false, "etc/ etc/foo/ etc/foo/a var/ var/cache/ var/cache/foo/ srv/"},
+		{true, "etc/ etc/foo/ etc/foo/a srv/"},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{PruneEmptyDirs: row.prune}, testFooManifest(t, files))
+		headers, _ := testMemberTar(t, pkg, "data.tar")
+		var names []string
+		for _, hdr := range headers {
+			names = append(names, hdr.Name)
+		}
+		if got := strings.Join(names, " "); got != row.expect {
+			t.Errorf("PruneEmptyDirs=%v: expected %q, got %q", row.prune, row.expect, got)
+		}
+	}
+}
+
+func TestArAttrs(t *testing.T) {
+	type testRow struct {
+		builder   Builder
+		expectUID string
+		expectGID string
+		expectMod string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{Builder{}, "0", "0", "100644", ""},
+		{Builder{ArUID: 1000, ArGID: 100, ArMode: 0o100600}, "1000", "100", "100600", ""},
+		{Builder{ArUID: 1000000}, "", "", "", "arUID: value 1000000 does not fit in 6 decimal digits"},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		err := row.builder.Build(&buf, testFooManifest(t, ""))
+		if row.expectErr != "" {
+			if err == nil || err.Error() != row.expectErr {
+				t.Errorf("%+v: expected error %q, got %v", row.builder, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: Build: %v", row.builder, err)
+			continue
+		}
+
+		data := buf.Bytes()[len(arMagic):]
+		for len(data) != 0 {
+			hdr := data[:arHeaderSize]
+			name := strings.TrimRight(string(hdr[0:16]), " ")
+			uid := strings.TrimRight(string(hdr[28:34]), " ")
+			gid := strings.TrimRight(string(hdr[34:40]), " ")
+			mode := strings.TrimRight(string(hdr[40:48]), " ")
+			if uid != row.expectUID || gid != row.expectGID || mode != row.expectMod {
+				t.Errorf("%s: expected uid/gid/mode %s/%s/%s, got %s/%s/%s", name, row.expectUID, row.expectGID, row.expectMod, uid, gid, mode)
+			}
+			size, _ := strconv.Atoi(strings.TrimRight(string(hdr[48:58]), " "))
+			data = data[arHeaderSize+size+(size&1):]
+		}
+	}
+}
+
+func TestExtraConffiles(t *testing.T) {
+	type testRow struct {
+		extra     string
+		expect    string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`["/etc/foo.conf"]`, "etc/foo.conf\n", ""},
+		{`["etc/foo.conf", "etc/bar.conf"]`, "etc/bar.conf\netc/foo.conf\n", ""},
+		{`["/etc/missing.conf"]`, "", `extraConffiles[0]: conffile "etc/missing.conf" is not shipped in the package`},
+		{`["/etc/"]`, "", `extraConffiles[0]: conffile "etc/" is not a regular file`},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"extraConffiles": `+row.extra+`, "files": [
+			{"name": "etc/"},
+			{"name": "etc/bar.conf", "text": "bar\n"},
+			{"name": "etc/foo.conf", "text": "foo\n"}
+		]`)
+		var buf bytes.Buffer
+		err := Builder{}.Build(&buf, manifest)
+		if row.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), row.expectErr) {
+				t.Errorf("%s: expected error containing %q, got %v", row.extra, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Build: %v", row.extra, err)
+			continue
+		}
+		_, control := testMemberTar(t, buf.Bytes(), "control.tar")
+		if got := string(control["conffiles"]); got != row.expect {
+			t.Errorf("%s: expected conffiles %q, got %q", row.extra, row.expect, got)
+		}
+	}
+}
+
+func TestMetapackage(t *testing.T) {
+	manifest := testFooManifest(t, `"depends": "bar (>= 1.0), baz"`)
+	pkg := testBuild(t, Builder{}, manifest)
+
+	members := testReadAr(t, pkg)
+	var names []string
+	for _, member := range members {
+		names = append(names, member.name)
+	}
+	if got := strings.Join(names, " "); got != "debian-binary control.tar.gz data.tar.gz" {
+		t.Errorf("expected three members, got %q", got)
+	}
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	if len(headers) != 0 {
+		t.Errorf("data.tar: expected no entries, got %d", len(headers))
+	}
+	_, data := testArMemberData(t, members, "data.tar")
+	if raw := testDecompress(t, "data.tar.gz", data); len(raw) == 0 || len(raw)%tarBlockSize != 0 || !isZeroBlock(raw) {
+		t.Errorf("data.tar: expected an end-of-archive marker only, got %d bytes", len(raw))
+	}
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	for _, algo := range standardHashes {
+		if _, found := control[algo.FileName()]; found {
+			t.Errorf("unexpected %s member", algo.FileName())
+		}
+	}
+	for _, line := range []string{"Depends: bar (>= 1.0), baz\n", "Installed-Size: 0\n"} {
+		if !strings.Contains(string(control["control"]), line) {
+			t.Errorf("control: missing %q in %q", line, control["control"])
+		}
+	}
+}
+
+func TestDeviceNumberLimits(t *testing.T) {
+	const files = `"files": [{"name": "dev/"}, {"name": "dev/big", "type": "CHR", "major": 1, "minor": 16777216}]`
+
+	type testRow struct {
+		format    tar.Format
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{tar.FormatUSTAR, "minor: value 16777216 does not fit in a USTAR tar header (max 2097151)"},
+		{tar.FormatPAX, ""},
+		{tar.FormatGNU, ""},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		err := Builder{TarFormat: row.format}.Build(&buf, testFooManifest(t, files))
+		if row.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), row.expectErr) {
+				t.Errorf("%v: expected error containing %q, got %v", row.format, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: Build: %v", row.format, err)
+			continue
+		}
+
+		headers, _ := testMemberTar(t, buf.Bytes(), "data.tar")
+		for _, hdr := range headers {
+			if hdr.Name != "dev/big" {
+				continue
+			}
+			minor := hdr.Devminor
+			if value, found := hdr.PAXRecords["SCHILY.devminor"]; found {
+				minor, _ = strconv.ParseInt(value, 10, 64)
+			} else if row.format == tar.FormatPAX {
+				t.Errorf("%v: missing SCHILY.devminor record", row.format)
+			}
+			if hdr.Devmajor != 1 || minor != 16777216 {
+				t.Errorf("%v: expected device 1,16777216, got %d,%d", row.format, hdr.Devmajor, minor)
+			}
+		}
+	}
+}
+
+func TestCompatRelations(t *testing.T) {
+	for _, compat := range []bool{false, true} {
+		var warnings []Warning
+		builder := Builder{
+			CompatRelations: compat,
+			OnWarning:       func(w Warning) { warnings = append(warnings, w) },
+		}
+		pkg := testBuild(t, builder, testFooManifest(t, `"section": "misc", "priority": "optional", "longDescription": ["More about foo."], "depends": "bar", "enhances": "baz"`))
+		_, control := testMemberTar(t, pkg, "control.tar")
+
+		if found := strings.Contains(string(control["control"]), "\nEnhances: baz\n"); found == compat {
+			t.Errorf("CompatRelations=%v: expected Enhances %v, got %q", compat, !compat, control["control"])
+		}
+		if !strings.Contains(string(control["control"]), "\nDepends: bar\n") {
+			t.Errorf("CompatRelations=%v: expected Depends to be kept, got %q", compat, control["control"])
+		}
+
+		expectWarnings := 0
+		if compat {
+			expectWarnings = 1
+		}
+		if len(warnings) != expectWarnings {
+			t.Errorf("CompatRelations=%v: expected %d warnings, got %v", compat, expectWarnings, warnings)
+		} else if compat && warnings[0].Field != "enhances" {
+			t.Errorf("CompatRelations=%v: expected a warning for enhances, got %v", compat, warnings[0])
+		}
+	}
+}
+
+func TestConffilesMember(t *testing.T) {
+	const withConf = `"files": [{"name": "etc/"}, {"name": "etc/foo.conf", "isConf": true, "text": "foo\n"}]`
+	const withoutConf = `"files": [{"name": "etc/"}, {"name": "etc/foo", "text": "foo\n"}]`
+
+	type testRow struct {
+		name        string
+		fields      string
+		always      bool
+		expectFound bool
+		expect      string
+	}
+
+	testData := [...]testRow{
+		{"present with content", withConf, false, true, "etc/foo.conf\n"},
+		{"present with content, always write", withConf, true, true, "etc/foo.conf\n"},
+		{"absent when empty", withoutConf, false, false, ""},
+		{"empty when always write", withoutConf, true, true, ""},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{AlwaysWriteConffiles: row.always}, testFooManifest(t, row.fields))
+		_, control := testMemberTar(t, pkg, "control.tar")
+		data, found := control["conffiles"]
+		if found != row.expectFound {
+			t.Errorf("%s: expected conffiles present %v, got %v", row.name, row.expectFound, found)
+			continue
+		}
+		if string(data) != row.expect {
+			t.Errorf("%s: expected conffiles %q, got %q", row.name, row.expect, data)
+		}
+	}
+}
+
+func TestZstdMemberName(t *testing.T) {
+	pkg := testBuild(t, Builder{Compression: CompressZSTD}, testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`))
+	members := testReadAr(t, pkg)
+	if name := members[2].name; name != "data.tar.zst" {
+		t.Errorf("expected data.tar.zst, got %s", name)
+	}
+
+	dpkgDeb, err := exec.LookPath("dpkg-deb")
+	if err != nil {
+		t.Skip("dpkg-deb is not available")
+	}
+	debPath := filepath.Join(t.TempDir(), "foo.deb")
+	if err := os.WriteFile(debPath, pkg, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	out, err := exec.Command(dpkgDeb, "--contents", debPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("dpkg-deb --contents: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "etc/a") {
+		t.Errorf("dpkg-deb --contents: expected etc/a, got %q", out)
+	}
+}
+
+func TestSymlinkMode(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "a\n"},
+		{"name": "etc/plain", "type": "symlink", "link": "a"},
+		{"name": "etc/perm", "type": "symlink", "link": "a", "perm": "0644"},
+		{"name": "etc/setuid", "type": "symlink", "link": "a", "perm": "04755"}
+	]`)
+	for name, builder := range map[string]Builder{
+		"default":             {},
+		"NormalizePerms":      {NormalizePerms: true},
+		"DefaultNumericOwner": {DefaultNumericOwner: true},
+	} {
+		pkg := testBuild(t, builder, manifest)
+		headers, _ := testMemberTar(t, pkg, "data.tar")
+		for _, hdr := range headers {
+			if hdr.Typeflag == tar.TypeSymlink && hdr.Mode&0o7777 != 0o777 {
+				t.Errorf("%s: %s: expected mode 0777, got %04o", name, hdr.Name, hdr.Mode&0o7777)
+			}
+		}
+	}
+}
+
+func TestDpkgControlMembers(t *testing.T) {
+	manifest := testFooManifest(t, `
+		"preInstall": ["echo preinst"], "postInstall": ["echo postinst"],
+		"preRemove": ["echo prerm"], "postRemove": ["echo postrm"],
+		"files": [{"name": "etc/"}, {"name": "etc/foo.conf", "isConf": true, "text": "foo\n"}]`)
+	zero := time.Unix(1577836800, 0)
+	pkg := testBuild(t, Builder{Compression: CompressNone, DpkgControlMembers: true, ZeroTime: zero}, manifest)
+	_, actual := testArMemberData(t, testReadAr(t, pkg), "control.tar")
+	_, contents := testReadTar(t, actual)
+
+	md5sum := md5.Sum([]byte("foo\n"))
+	reference := []struct {
+		name string
+		mode int64
+		data []byte
+	}{
+		{"./control", 0o644, contents["./control"]},
+		{"./md5sums", 0o644, []byte(hex.EncodeToString(md5sum[:]) + "  etc/foo.conf\n")},
+		{"./conffiles", 0o644, []byte("etc/foo.conf\n")},
+		{"./preinst", 0o755, manifest.PreInstallScript()},
+		{"./postinst", 0o755, manifest.PostInstallScript()},
+		{"./prerm", 0o755, manifest.PreRemoveScript()},
+		{"./postrm", 0o755, manifest.PostRemoveScript()},
+	}
+
+	var expect bytes.Buffer
+	tw := tar.NewWriter(&expect)
+	err := tw.WriteHeader(&tar.Header{Format: tar.FormatPAX, Typeflag: tar.TypeDir, Name: "./", Mode: unixModeDIR | 0o755, ModTime: zero})
+	if err != nil {
+		t.Fatalf("tar.Writer.WriteHeader: %v", err)
+	}
+	for _, member := range reference {
+		err := tw.WriteHeader(&tar.Header{Format: tar.FormatPAX, Typeflag: tar.TypeReg, Name: member.name, Mode: unixModeREG | member.mode, Size: int64(len(member.data)), ModTime: zero})
+		if err != nil {
+			t.Fatalf("tar.Writer.WriteHeader: %v", err)
+		}
+		if _, err := tw.Write(member.data); err != nil {
+			t.Fatalf("tar.Writer.Write: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar.Writer.Close: %v", err)
+	}
+
+	if !bytes.Equal(actual, expect.Bytes()) {
+		headers, _ := testReadTar(t, actual)
+		var names []string
+		for _, hdr := range headers {
+			names = append(names, hdr.Name)
+		}
+		t.Errorf("control.tar does not match the dpkg reference layout; members are %q", names)
+	}
+}
+
+type testCountingFS struct {
+	fstest.MapFS
+	opens map[string]int
+}
+
+func (fsys testCountingFS) Open(name string) (fs.File, error) {
+	fsys.opens[name]++
+	return fsys.MapFS.Open(name)
+}
+
+func (fsys testCountingFS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.MapFS.Stat(name)
+}
+
+func TestBuildAtReadsOnce(t *testing.T) {
+	js := testFooJSON(`"files": [
+		{"name": "etc/"},
+		{"name": "etc/a.conf"},
+		{"name": "etc/b.conf"}
+	]`)
+	root := fstest.MapFS{
+		"etc/a.conf": {Data: []byte("aaaa\n"), Mode: 0o644},
+		"etc/b.conf": {Data: []byte(strings.Repeat("b", 10000)), Mode: 0o644},
+	}
+
+	type testRow struct {
+		compression CompressAlgorithm
+		expectName  string
+	}
+
+	testData := [...]testRow{
+		{CompressAuto, "control.tar"},
+		{CompressNone, "control.tar"},
+		{CompressGZIP, "control.tar.gz"},
+	}
+
+	for _, row := range testData {
+		fsys := testCountingFS{MapFS: root, opens: make(map[string]int)}
+		builder := Builder{Root: fsys, ControlCompression: row.compression}
+		var buf memWriterAt
+		if err := builder.BuildAt(&buf, testManifest(t, js)); err != nil {
+			t.Fatalf("%v: BuildAt: %v", row.compression, err)
+		}
+
+		expectOpens := 1
+		if row.expectName != "control.tar" {
+			expectOpens = 2
+		}
+		for name := range root {
+			if fsys.opens[name] != expectOpens {
+				t.Errorf("%v: %s: expected %d opens, got %d", row.compression, name, expectOpens, fsys.opens[name])
+			}
+		}
+
+		members := testReadAr(t, buf.data)
+		if len(members) < 2 || members[1].name != row.expectName {
+			t.Errorf("%v: expected the second member to be %q", row.compression, row.expectName)
+		}
+
+		pkg, err := ReadPackage(bytes.NewReader(buf.data))
+		if err != nil {
+			t.Fatalf("%v: ReadPackage: %v", row.compression, err)
+		}
+		var expect strings.Builder
+		for _, name := range []string{"etc/a.conf", "etc/b.conf"} {
+			data, _ := pkg.DataFile(name)
+			if !bytes.Equal(data, root[name].Data) {
+				t.Errorf("%v: %s: data content does not match the source", row.compression, name)
+			}
+			sum := md5.Sum(root[name].Data)
+			expect.WriteString(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+		}
+		if got, _ := pkg.ControlFile("md5sums"); string(got) != expect.String() {
+			t.Errorf("%v: md5sums: expected %q, got %q", row.compression, expect.String(), got)
+		}
+	}
+
+	var buf memWriterAt
+	if err := (Builder{Root: root}).BuildAt(&buf, testManifest(t, js)); err != nil {
+		t.Fatalf("BuildAt: %v", err)
+	}
+	pkg := testBuild(t, Builder{Root: root, ControlCompression: CompressNone}, testManifest(t, js))
+	if !bytes.Equal(buf.data, pkg) {
+		t.Errorf("BuildAt output differs from Build with an uncompressed control.tar")
+	}
+}
+
+func TestAllowedURLSchemes(t *testing.T) {
+	type testRow struct {
+		homePage  string
+		schemes   []string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{"https://example.com/foo", nil, ""},
+		{"ftp://example.com/foo", nil, ""},
+		{"mailto:foo@example.com", nil, `homePage: URL scheme "mailto" is not allowed; expected one of ["http" "https" "ftp"]`},
+		{"mailto:foo@example.com", []string{"https", "mailto"}, ""},
+		{"http://example.com/foo", []string{"https", "mailto"}, `homePage: URL scheme "http" is not allowed; expected one of ["https" "mailto"]`},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"homePage": `+strconv.Quote(row.homePage))
+		var buf bytes.Buffer
+		err := Builder{AllowedURLSchemes: row.schemes}.Build(&buf, manifest)
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s %q: unexpected error: %v", row.homePage, row.schemes, err)
+		case row.expectErr != "" && (err == nil || !strings.Contains(err.Error(), row.expectErr)):
+			t.Errorf("%s %q: expected error %q, got %v", row.homePage, row.schemes, row.expectErr, err)
+		}
+	}
+}
+
+func TestMaxScriptSize(t *testing.T) {
+	lines := make([]string, 0, 4096)
+	for i := 0; i < cap(lines); i++ {
+		lines = append(lines, strconv.Quote("echo "+strings.Repeat("x", 300)))
+	}
+	js := testFooJSON(`"postInstall": [` + strings.Join(lines, ", ") + `]`)
+
+	type testRow struct {
+		limit     int64
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{0, "which exceeds the limit of 1048576 bytes"},
+		{1000, "which exceeds the limit of 1000 bytes"},
+		{2 << 20, ""},
+		{-1, ""},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		err := Builder{MaxScriptSize: row.limit}.Build(&buf, testManifest(t, js))
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("MaxScriptSize=%d: unexpected error: %v", row.limit, err)
+		case row.expectErr != "" && (err == nil || !strings.Contains(err.Error(), row.expectErr)):
+			t.Errorf("MaxScriptSize=%d: expected error %q, got %v", row.limit, row.expectErr, err)
+		}
+	}
+}
+
+func TestDefaultMTime(t *testing.T) {
+	zero := time.Unix(1000000000, 0).UTC()
+	fileTime := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	manifestTime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	files := `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "a\n", "mtime": "2001-01-01T00:00:00Z"},
+		{"name": "etc/b", "text": "b\n"}
+	]`
+
+	type testRow struct {
+		fields string
+		expect map[string]time.Time
+	}
+
+	testData := [...]testRow{
+		{
+			`"defaultMTime": "2021-06-01T00:00:00Z", ` + files,
+			map[string]time.Time{"etc/": manifestTime, "etc/a": fileTime, "etc/b": manifestTime},
+		},
+		{
+			files,
+			map[string]time.Time{"etc/": zero, "etc/a": fileTime, "etc/b": zero},
+		},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{ZeroTime: zero}, testFooManifest(t, row.fields))
+		headers, _ := testMemberTar(t, pkg, "data.tar")
+		for _, hdr := range headers {
+			if want, found := row.expect[hdr.Name]; found && !hdr.ModTime.Equal(want) {
+				t.Errorf("%s: expected mtime %v, got %v", hdr.Name, want, hdr.ModTime)
+			}
+		}
+	}
+}
+
+func TestNoAccessChangeTime(t *testing.T) {
+	root := fstest.MapFS{
+		"etc/b": {Data: []byte("b\n"), Mode: 0o644, ModTime: time.Unix(1600000000, 123456789)},
+	}
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "a\n", "mtime": "2001-01-01T00:00:00.5Z"},
+		{"name": "etc/b"}
+	]`)
+	pkg := testBuild(t, Builder{Root: root}, manifest)
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	if len(headers) == 0 {
+		t.Fatalf("data.tar: no entries")
+	}
+	for _, hdr := range headers {
+		if hdr.Name == "etc/a" && hdr.PAXRecords["mtime"] == "" {
+			t.Errorf("%s: expected a PAX mtime record for the sub-second mtime", hdr.Name)
+		}
+		for _, key := range []string{"atime", "ctime"} {
+			if value, found := hdr.PAXRecords[key]; found {
+				t.Errorf("%s: unexpected PAX %s record %q", hdr.Name, key, value)
+			}
+		}
+		if !hdr.AccessTime.IsZero() || !hdr.ChangeTime.IsZero() {
+			t.Errorf("%s: expected zero atime and ctime, got %v and %v", hdr.Name, hdr.AccessTime, hdr.ChangeTime)
+		}
+	}
+}
+
+func TestBuilderControlFile(t *testing.T) {
+	js := testFooJSON(`"enhances": "bar", "files": [{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}]`)
+	builder := Builder{StampBuiltBy: true, CompatRelations: true}
+
+	manifest := testManifest(t, js)
+	got, err := builder.ControlFile(context.Background(), manifest)
+	if err != nil {
+		t.Fatalf("ControlFile: %v", err)
+	}
+	if !bytes.Contains(manifest.ControlFile(), []byte("\nEnhances: bar\n")) {
+		t.Errorf("Manifest.ControlFile: expected an Enhances field")
+	}
+	if bytes.Contains(got, []byte("Enhances:")) || !bytes.Contains(got, []byte("\nX-Built-By: mkdeb/")) {
+		t.Errorf("Builder.ControlFile: expected X-Built-By and no Enhances, got %q", got)
+	}
+
+	pkg := testBuild(t, builder, testManifest(t, js))
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if !bytes.Equal(got, control["control"]) {
+		t.Errorf("Builder.ControlFile: expected the packaged control file %q, got %q", control["control"], got)
+	}
+}
+
+func TestPAXRecordsReproducible(t *testing.T) {
+	js := testFooJSON(`"files": [
+		{"name": "dev/"},
+		{"name": "dev/big", "type": "CHR", "major": 16777216, "minor": 16777217, "mtime": "2001-01-01T00:00:00.5Z", "user": "www-data#33", "group": "www-data#33"}
+	]`)
+
+	first := testBuild(t, Builder{}, testManifest(t, js))
+	for i := 0; i < 4; i++ {
+		if again := testBuild(t, Builder{}, testManifest(t, js)); !bytes.Equal(first, again) {
+			t.Fatalf("build %d differs from the first build", i+2)
+		}
+	}
+
+	headers, _ := testMemberTar(t, first, "data.tar")
+	found := false
+	for _, hdr := range headers {
+		if hdr.Name != "dev/big" {
+			continue
+		}
+		found = true
+		for _, key := range []string{"mtime", "SCHILY.devmajor", "SCHILY.devminor"} {
+			if _, found := hdr.PAXRecords[key]; !found {
+				t.Errorf("%s: missing PAX record %q", hdr.Name, key)
+			}
+		}
+		if hdr.Uid != 33 || hdr.Uname != "www-data" || hdr.Gid != 33 || hdr.Gname != "www-data" {
+			t.Errorf("%s: expected owner www-data#33:www-data#33, got %s#%d:%s#%d", hdr.Name, hdr.Uname, hdr.Uid, hdr.Gname, hdr.Gid)
+		}
+	}
+	if !found {
+		t.Errorf("dev/big: missing from data.tar")
+	}
+}
+
+func TestAlwaysWriteHashMembers(t *testing.T) {
+	js := testFooJSON(`"depends": "bar (>= 1.0), baz"`)
+
+	type testRow struct {
+		builder Builder
+		expect  []string
+	}
+
+	testData := [...]testRow{
+		{Builder{}, nil},
+		{Builder{AlwaysWriteHashMembers: true}, []string{"md5sums", "sha1sum", "sha256sum"}},
+		{Builder{AlwaysWriteHashMembers: true, Hashes: []HashAlgorithm{HashMD5}}, []string{"md5sums"}},
+	}
+
+	hashMembers := make(map[string]bool, len(standardHashes))
+	for _, algo := range standardHashes {
+		hashMembers[algo.FileName()] = true
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, row.builder, testManifest(t, js))
+		headers, control := testMemberTar(t, pkg, "control.tar")
+
+		var got []string
+		for _, hdr := range headers {
+			if hashMembers[hdr.Name] {
+				got = append(got, hdr.Name)
+				if hdr.Size != 0 || len(control[hdr.Name]) != 0 {
+					t.Errorf("AlwaysWriteHashMembers=%v: %s: expected an empty member, got %d bytes", row.builder.AlwaysWriteHashMembers, hdr.Name, hdr.Size)
+				}
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(row.expect, " ") {
+			t.Errorf("AlwaysWriteHashMembers=%v: expected members %q, got %q", row.builder.AlwaysWriteHashMembers, row.expect, got)
+		}
+	}
+}
+
+func TestWriteDataTarball(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "hello\n"},
+		{"name": "etc/b", "type": "symlink", "link": "a"}
+	]`)
+
+	type testRow struct {
+		compression CompressAlgorithm
+		name        string
+	}
+
+	testData := [...]testRow{
+		{CompressNone, "data.tar"},
+		{CompressGZIP, "data.tar.gz"},
+		{CompressZSTD, "data.tar.zst"},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		if err := (Builder{Compression: row.compression}).WriteDataTarball(&buf, manifest); err != nil {
+			t.Fatalf("%v: WriteDataTarball: %v", row.compression, err)
+		}
+		if bytes.HasPrefix(buf.Bytes(), []byte(arMagic)) {
+			t.Errorf("%v: expected a bare tarball, got an ar archive", row.compression)
+		}
+
+		headers, contents := testReadTar(t, testDecompress(t, row.name, buf.Bytes()))
+		var names []string
+		for _, hdr := range headers {
+			names = append(names, hdr.Name)
+			if hdr.Name == "etc/b" && (hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "a") {
+				t.Errorf("%v: etc/b: expected a symlink to \"a\", got type %q link %q", row.compression, hdr.Typeflag, hdr.Linkname)
+			}
+		}
+		if got := strings.Join(names, " "); got != "etc/ etc/a etc/b" {
+			t.Errorf("%v: expected entries %q, got %q", row.compression, "etc/ etc/a etc/b", got)
+		}
+		if got := string(contents["etc/a"]); got != "hello\n" {
+			t.Errorf("%v: etc/a: expected %q, got %q", row.compression, "hello\n", got)
+		}
+	}
+}
+
+func TestAllowedPrefixes(t *testing.T) {
+	type testRow struct {
+		prefixes  []string
+		files     string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{nil, `{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}`, ""},
+		{[]string{"/opt/foo/"}, `{"name": "opt/"}, {"name": "opt/foo/"}, {"name": "opt/foo/bin", "text": "a\n"}`, ""},
+		{[]string{"opt/foo", "etc/foo"}, `{"name": "etc/"}, {"name": "etc/foo/"}, {"name": "etc/foo/a.conf", "text": "a\n"}`, ""},
+		{[]string{"/opt/foo/"}, `{"name": "opt/"}, {"name": "opt/foobar", "text": "a\n"}`, `files[1]: "opt/foobar" is outside of the allowed prefixes ["/opt/foo/"]`},
+		{[]string{"/opt/foo/"}, `{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}`, `files[0]: "etc/" is outside of the allowed prefixes ["/opt/foo/"]`},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		err := Builder{AllowedPrefixes: row.prefixes}.Build(&buf, testFooManifest(t, `"files": [`+row.files+`]`))
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%q: unexpected error: %v", row.prefixes, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%q: expected error %q, got %v", row.prefixes, row.expectErr, err)
+		}
+	}
+}
+
+func TestDefaultSection(t *testing.T) {
+	type testRow struct {
+		fields        string
+		defaultSect   string
+		expectSection string
+		expectErr     string
+	}
+
+	testData := [...]testRow{
+		{``, "", "", ""},
+		{``, "admin", "admin", ""},
+		{`"section": "net"`, "admin", "net", ""},
+		{``, "Not A Section", "", `default section: invalid Debian package section "Not A Section"`},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		err := Builder{DefaultSection: row.defaultSect}.Build(&buf, testFooManifest(t, row.fields))
+		if row.expectErr != "" {
+			if err == nil || err.Error() != row.expectErr {
+				t.Errorf("%q: expected error %q, got %v", row.defaultSect, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: Build: %v", row.defaultSect, err)
+			continue
+		}
+
+		_, control := testMemberTar(t, buf.Bytes(), "control.tar")
+		got := string(control["control"])
+		if row.expectSection == "" {
+			if strings.Contains(got, "Section:") {
+				t.Errorf("%q: expected no Section field, got %q", row.defaultSect, got)
+			}
+		} else if !strings.Contains(got, "\nSection: "+row.expectSection+"\n") {
+			t.Errorf("%q: expected Section %q, got %q", row.defaultSect, row.expectSection, got)
+		}
+	}
+}
+
+func TestCheckAllFiles(t *testing.T) {
+	root := fstest.MapFS{"etc/present": {Data: []byte("x\n"), Mode: 0o644}}
+	js := testFooJSON(`"files": [
+		{"name": "etc/"},
+		{"name": "etc/missing-a"},
+		{"name": "etc/present"},
+		{"name": "etc/missing-b"},
+		{"name": "etc/c", "path": "src/missing-c"}
+	]`)
+	missing := []string{"etc/missing-a", "etc/missing-b", "src/missing-c"}
+
+	var buf bytes.Buffer
+	err := Builder{Root: root, CheckAllFiles: true}.Build(&buf, testManifest(t, js))
+	if err == nil {
+		t.Fatalf("CheckAllFiles=true: expected an error")
+	}
+	if !strings.HasPrefix(err.Error(), "3 source files are missing or unreadable:") {
+		t.Errorf("CheckAllFiles=true: expected a count of 3, got %v", err)
+	}
+	for _, name := range missing {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("CheckAllFiles=true: expected %q in %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "etc/present") {
+		t.Errorf("CheckAllFiles=true: unexpected etc/present in %v", err)
+	}
+
+	err = Builder{Root: root}.Build(&buf, testManifest(t, js))
+	if err == nil {
+		t.Fatalf("CheckAllFiles=false: expected an error")
+	}
+	reported := 0
+	for _, name := range missing {
+		if strings.Contains(err.Error(), name) {
+			reported++
+		}
+	}
+	if reported != 1 {
+		t.Errorf("CheckAllFiles=false: expected only the first missing file, got %v", err)
+	}
+}
+
+func TestBuildWithData(t *testing.T) {
+	contents := map[string]string{
+		"usr/share/foo/a": "hello",
+		"usr/share/foo/b": strings.Repeat("b", 10000),
+	}
+
+	var tarBuf bytes.Buffer
+	gw := gzip.NewWriter(&tarBuf)
+	tw := tar.NewWriter(gw)
+	var md5sums strings.Builder
+	for _, name := range []string{"usr/", "usr/share/", "usr/share/foo/", "usr/share/foo/a", "usr/share/foo/b"} {
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0o755}
+		if text, found := contents[name]; found {
+			hdr = &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(text))}
+			sum := md5.Sum([]byte(text))
+			md5sums.WriteString(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader: %v", err)
+		}
+		if _, err := tw.Write([]byte(contents[name])); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar.Close: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip.Close: %v", err)
+	}
+
+	manifest := testFooManifest(t, `"files": [
+		{"name": "usr/"},
+		{"name": "usr/share/"},
+		{"name": "usr/share/foo/"},
+		{"name": "usr/share/foo/a"},
+		{"name": "usr/share/foo/b"}
+	]`)
+	fsys := testCountingFS{MapFS: fstest.MapFS{}, opens: make(map[string]int)}
+	builder := Builder{Root: fsys, Compression: CompressGZIP}
+	checksums := map[HashAlgorithm][]byte{HashMD5: []byte(md5sums.String())}
+
+	var out bytes.Buffer
+	if err := builder.BuildWithData(&out, manifest, bytes.NewReader(tarBuf.Bytes()), checksums); err != nil {
+		t.Fatalf("BuildWithData: %v", err)
+	}
+	if len(fsys.opens) != 0 {
+		t.Errorf("expected Root to be left alone, got opens %v", fsys.opens)
+	}
+
+	members := testReadAr(t, out.Bytes())
+	if _, data := testArMemberData(t, members, "data.tar.gz"); !bytes.Equal(data, tarBuf.Bytes()) {
+		t.Errorf("data.tar.gz: expected the prebuilt tarball unchanged")
+	}
+	_, control := testMemberTar(t, out.Bytes(), "control.tar")
+	if got := string(control["md5sums"]); got != md5sums.String() {
+		t.Errorf("md5sums: expected %q, got %q", md5sums.String(), got)
+	}
+	if got := string(control["control"]); !strings.Contains(got, "\nInstalled-Size: 16384\n") {
+		t.Errorf("control: expected Installed-Size 16384 from the prebuilt tarball, got %q", got)
+	}
+
+	if _, err := exec.LookPath("dpkg-deb"); err == nil {
+		debPath := filepath.Join(t.TempDir(), "foo.deb")
+		if err := os.WriteFile(debPath, out.Bytes(), 0o666); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		listing, err := exec.Command("dpkg-deb", "--contents", debPath).CombinedOutput()
+		if err != nil {
+			t.Fatalf("dpkg-deb --contents: %v: %s", err, listing)
+		}
+		if !strings.Contains(string(listing), "usr/share/foo/b") {
+			t.Errorf("dpkg-deb --contents: expected usr/share/foo/b, got %s", listing)
+		}
+	}
+}
+
+func TestNilRoot(t *testing.T) {
+	type testRow struct {
+		files     string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}, {"name": "etc/b", "lines": ["b"]}, {"name": "etc/c", "type": "symlink", "link": "a"}`, ""},
+		{`{"name": "etc/"}, {"name": "etc/a", "bytesHex": "6162"}, {"name": "var/", "keepEmpty": true}`, ""},
+		{`{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}, {"name": "etc/b", "path": "src/b"}`, `files[2]: reads "src/b" from the filesystem, but Builder.Root is nil`},
+		{`{"name": "etc/"}, {"name": "etc/a"}`, `files[1]: reads "etc/a" from the filesystem, but Builder.Root is nil`},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		err := Builder{}.Build(&buf, testFooManifest(t, `"files": [`+row.files+`]`))
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", row.files, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%s: expected error %q, got %v", row.files, row.expectErr, err)
+		}
+	}
+}
+
+func TestRewriteHeaderUname(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "a\n", "user": "www-data#33", "group": "www-data#33"}
+	]`)
+	builder := Builder{
+		RewriteHeader: func(hdr *tar.Header, file *File) {
+			hdr.Uname = strings.ToUpper(hdr.Uname)
+		},
+	}
+	pkg := testBuild(t, builder, manifest)
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	found := false
+	for _, hdr := range headers {
+		if hdr.Name != "etc/a" {
+			continue
+		}
+		found = true
+		if hdr.Uname != "WWW-DATA" || hdr.Gname != "www-data" {
+			t.Errorf("%s: expected owner WWW-DATA:www-data, got %s:%s", hdr.Name, hdr.Uname, hdr.Gname)
+		}
+	}
+	if !found {
+		t.Errorf("etc/a: missing from data.tar")
+	}
+}
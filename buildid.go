@@ -0,0 +1,131 @@
+package mkdeb
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+const elfMagic = "\x7fELF"
+
+const ntGNUBuildID = 3
+
+func (builder Builder) extractBuildIds(ctx context.Context, manifest *Manifest) error {
+	if !builder.ExtractBuildIds {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(manifest.BuildIds))
+	for _, id := range manifest.BuildIds {
+		seen[id] = struct{}{}
+	}
+
+	for index := range manifest.Files {
+		file := &manifest.Files[index]
+		if file.Type != TypeREG {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		id, found, err := builder.fileBuildID(file)
+		if err != nil {
+			return fmt.Errorf("files[%d]: build-id: %w", index, err)
+		}
+		if !found {
+			continue
+		}
+		if _, dupe := seen[id]; dupe {
+			continue
+		}
+		seen[id] = struct{}{}
+		manifest.BuildIds = append(manifest.BuildIds, id)
+	}
+	return nil
+}
+
+func (builder Builder) fileBuildID(file *File) (string, bool, error) {
+	rc, err := file.sourceReader(builder.Root)
+	if err != nil {
+		return "", false, fmt.Errorf("Open: %w", err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	head := make([]byte, len(elfMagic))
+	_, err = io.ReadFull(rc, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("Read: %w", err)
+	}
+	if string(head) != elfMagic {
+		return "", false, nil
+	}
+
+	ra, ok := rc.(io.ReaderAt)
+	if !ok {
+		rest, err := io.ReadAll(rc)
+		if err != nil {
+			return "", false, fmt.Errorf("Read: %w", err)
+		}
+		ra = bytes.NewReader(append(head, rest...))
+	}
+	return elfBuildID(ra)
+}
+
+func elfBuildID(r io.ReaderAt) (string, bool, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", section.Name, err)
+		}
+		if id, found := parseGNUBuildID(data, f.ByteOrder); found {
+			return id, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func parseGNUBuildID(data []byte, order binary.ByteOrder) (string, bool) {
+	for len(data) >= 12 {
+		nameSize := uint64(order.Uint32(data[0:4]))
+		descSize := uint64(order.Uint32(data[4:8]))
+		noteType := order.Uint32(data[8:12])
+		data = data[12:]
+		if nameSize > uint64(len(data)) || descSize > uint64(len(data)) {
+			return "", false
+		}
+
+		nameEnd := (nameSize + 3) &^ 3
+		descEnd := (descSize + 3) &^ 3
+		if uint64(len(data)) < nameEnd+descEnd {
+			return "", false
+		}
+
+		name := data[:nameSize]
+		desc := data[nameEnd : nameEnd+descSize]
+		data = data[nameEnd+descEnd:]
+
+		if noteType == ntGNUBuildID && string(name) == "GNU\x00" && len(desc) != 0 {
+			return hex.EncodeToString(desc), true
+		}
+	}
+	return "", false
+}
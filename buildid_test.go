@@ -0,0 +1,153 @@
+package mkdeb
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testNote(order binary.ByteOrder, noteType uint32, name string, desc []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, order, [3]uint32{uint32(len(name)), uint32(len(desc)), noteType})
+	buf.WriteString(name)
+	buf.Write(make([]byte, (4-len(name)%4)%4))
+	buf.Write(desc)
+	buf.Write(make([]byte, (4-len(desc)%4)%4))
+	return buf.Bytes()
+}
+
+func testELF(t *testing.T, notes []byte) []byte {
+	t.Helper()
+
+	const ehdrSize = 64
+	const shdrSize = 64
+	shstrtab := []byte("\x00.note.gnu.build-id\x00.shstrtab\x00")
+	notesOffset := uint64(ehdrSize)
+	shstrtabOffset := notesOffset + uint64(len(notes))
+	shdrOffset := (shstrtabOffset + uint64(len(shstrtab)) + 7) &^ 7
+
+	order := binary.LittleEndian
+	var buf bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shdrOffset,
+		Ehsize:    ehdrSize,
+		Shentsize: shdrSize,
+		Shnum:     3,
+		Shstrndx:  2,
+	}
+	copy(hdr.Ident[:], elfMagic)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_NOTE), Flags: uint64(elf.SHF_ALLOC), Off: notesOffset, Size: uint64(len(notes)), Addralign: 4},
+		{Name: 20, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOffset, Size: uint64(len(shstrtab)), Addralign: 1},
+	}
+
+	if err := binary.Write(&buf, order, hdr); err != nil {
+		t.Fatalf("binary.Write: %v", err)
+	}
+	buf.Write(notes)
+	buf.Write(shstrtab)
+	buf.Write(make([]byte, int(shdrOffset)-buf.Len()))
+	if err := binary.Write(&buf, order, sections); err != nil {
+		t.Fatalf("binary.Write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractBuildIds(t *testing.T) {
+	order := binary.LittleEndian
+	idA := []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	idB := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	root := fstest.MapFS{
+		"usr/bin/a":      {Data: testELF(t, testNote(order, ntGNUBuildID, "GNU\x00", idA)), Mode: 0o755},
+		"usr/bin/b":      {Data: testELF(t, append(testNote(order, 1, "GNU\x00", []byte("abi!")), testNote(order, ntGNUBuildID, "GNU\x00", idB)...)), Mode: 0o755},
+		"usr/bin/a-copy": {Data: testELF(t, testNote(order, ntGNUBuildID, "GNU\x00", idA)), Mode: 0o755},
+		"usr/bin/none":   {Data: testELF(t, testNote(order, ntGNUBuildID, "XYZ\x00", idB)), Mode: 0o755},
+		"usr/bin/script": {Data: []byte("#!/bin/sh\n"), Mode: 0o755},
+	}
+	js := testFooJSON(`"buildIds": ["00ff"], "files": [
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/a", "perm": "0755"},
+		{"name": "usr/bin/b", "perm": "0755"},
+		{"name": "usr/bin/a-copy", "perm": "0755"},
+		{"name": "usr/bin/none", "perm": "0755"},
+		{"name": "usr/bin/script", "perm": "0755"}
+	]`)
+
+	type testRow struct {
+		extract bool
+		expect  string
+	}
+
+	testData := [...]testRow{
+		{false, "Build-Ids: 00ff\n"},
+		{true, "Build-Ids: 00ff " + hex.EncodeToString(idA) + " " + hex.EncodeToString(idB) + "\n"},
+	}
+
+	for _, row := range testData {
+		pkg := testBuild(t, Builder{Root: root, ExtractBuildIds: row.extract}, testManifest(t, js))
+		_, control := testMemberTar(t, pkg, "control.tar")
+		if got := string(control["control"]); !strings.Contains(got, "\n"+row.expect) {
+			t.Errorf("ExtractBuildIds=%v: expected %q, got %q", row.extract, row.expect, got)
+		}
+	}
+
+	for _, id := range []string{"ABCD", "xyz", ""} {
+		manifest := testFooManifest(t, `"buildIds": ["`+id+`"]`)
+		if err := manifest.Validate(); err == nil || !strings.Contains(err.Error(), "buildIds[0]: invalid build ID") {
+			t.Errorf("%q: expected an invalid build ID error, got %v", id, err)
+		}
+	}
+}
+
+func TestParseGNUBuildIDGarbage(t *testing.T) {
+	order := binary.LittleEndian
+	valid := testNote(order, ntGNUBuildID, "GNU\x00", []byte{1, 2, 3, 4})
+	if id, found := parseGNUBuildID(valid, order); !found || id != "01020304" {
+		t.Fatalf("valid note: expected 01020304, got %q, %v", id, found)
+	}
+
+	huge := append([]byte(nil), valid...)
+	order.PutUint32(huge[0:4], 0xfffffffd)
+	truncatedDesc := append([]byte(nil), valid...)
+	order.PutUint32(truncatedDesc[4:8], 0xffffffff)
+
+	for _, data := range [][]byte{
+		nil,
+		valid[:11],
+		valid[:len(valid)-1],
+		huge,
+		truncatedDesc,
+		bytes.Repeat([]byte{0xff}, 64),
+	} {
+		if id, found := parseGNUBuildID(data, order); found {
+			t.Errorf("%x: expected no build ID, got %q", data, id)
+		}
+	}
+}
+
+func FuzzParseGNUBuildID(f *testing.F) {
+	order := binary.LittleEndian
+	f.Add(testNote(order, ntGNUBuildID, "GNU\x00", []byte{1, 2, 3, 4}))
+	f.Add(testNote(order, 1, "GNU\x00", []byte("abi!")))
+	f.Add(bytes.Repeat([]byte{0xff}, 16))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		id, found := parseGNUBuildID(data, order)
+		if found && len(id) > 2*len(data) {
+			t.Errorf("build ID %q is longer than the note data", id)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+func (builder Builder) BuildWithData(w io.Writer, manifest *Manifest, data io.ReadSeeker, checksums map[HashAlgorithm][]byte) error {
+	return builder.BuildWithDataContext(context.Background(), w, manifest, data, checksums)
+}
+
+func (builder Builder) BuildWithDataContext(ctx context.Context, w io.Writer, manifest *Manifest, data io.ReadSeeker, checksums map[HashAlgorithm][]byte) error {
+	if builder.Compression == CompressAuto {
+		return fmt.Errorf("compression: must name the compression of the prebuilt data tarball")
+	}
+
+	err := builder.preparePrebuilt(ctx, manifest, data)
+	if err != nil {
+		return err
+	}
+
+	builder.prebuiltChecksums = make(map[HashAlgorithm][]byte, len(checksums))
+	for algo, sums := range checksums {
+		builder.prebuiltChecksums[algo] = sums
+	}
+	manifest.isHashed = true
+
+	var controlBuf bytes.Buffer
+	err = builder.BuildControlTarball(&controlBuf, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to build control tarball in memory: %w", err)
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	_, err = builder.writeArFile(w, defaultDebianBinary, bytes.NewReader(controlBuf.Bytes()), data)
+	return err
+}
+
+func (builder *Builder) preparePrebuilt(ctx context.Context, manifest *Manifest, data io.ReadSeeker) error {
+	builder.fillDefaults()
+
+	err := builder.arAttrs().Validate()
+	if err != nil {
+		return err
+	}
+
+	err = builder.applyExcludes(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.applyDefaultSection(manifest)
+	if err != nil {
+		return err
+	}
+
+	installedSize, err := prebuiltInstalledSize(ctx, builder.Compression, data)
+	if err != nil {
+		return err
+	}
+
+	err = manifest.resolvePrebuilt(installedSize)
+	if err != nil {
+		return err
+	}
+
+	err = builder.validateURLs(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.checkAllowedPrefixes(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = builder.reportWarnings(manifest)
+	if err != nil {
+		return err
+	}
+
+	return builder.prepareCompression(manifest)
+}
+
+func prebuiltInstalledSize(ctx context.Context, algo CompressAlgorithm, data io.ReadSeeker) (int64, error) {
+	_, err := data.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("data: Seek: start: %w", err)
+	}
+
+	cr, err := algo.NewReader(ctxReader{ctx, data})
+	if err != nil {
+		return 0, fmt.Errorf("data: %w", err)
+	}
+	defer func() {
+		_ = cr.Close()
+	}()
+
+	var installedSize int64
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("data: failed to read prebuilt data tarball: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			installedSize += padSigned(hdr.Size, 12)
+		}
+	}
+
+	_, err = data.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("data: Seek: start: %w", err)
+	}
+	return installedSize, nil
+}
@@ -1,11 +1,15 @@
 package mkdeb
 
 import (
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"encoding"
 	"fmt"
 	"io"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	getopt "github.com/pborman/getopt/v2"
@@ -47,7 +51,16 @@ var compressSuffixArray = [...]string{
 	".gz",
 	".bz2",
 	".xz",
-	".zstd",
+	".zst",
+}
+
+var compressMagicArray = [...]string{
+	"",
+	"",
+	"\x1f\x8b",
+	"BZh",
+	"\xfd7zXZ\x00",
+	"\x28\xb5\x2f\xfd",
 }
 
 var compressMap = map[string]CompressAlgorithm{
@@ -61,6 +74,7 @@ var compressMap = map[string]CompressAlgorithm{
 	"bz2":   CompressBZIP2,
 	"xz":    CompressXZ,
 	"zstd":  CompressZSTD,
+	"zst":   CompressZSTD,
 }
 
 func (algo CompressAlgorithm) GoString() string {
@@ -84,32 +98,161 @@ func (algo CompressAlgorithm) Suffix() string {
 	return ""
 }
 
+var compressSuffixAliases = map[string]CompressAlgorithm{
+	".zst":  CompressZSTD,
+	".zstd": CompressZSTD,
+}
+
+func ParseCompressionSuffix(suffix string) (CompressAlgorithm, bool) {
+	if algo, found := compressSuffixAliases[suffix]; found {
+		return algo, true
+	}
+	for algo := CompressNone; algo < CompressAlgorithm(len(compressSuffixArray)); algo++ {
+		if compressSuffixArray[algo] == suffix {
+			return algo, true
+		}
+	}
+	return CompressAuto, false
+}
+
+func isAlreadyCompressed(algo CompressAlgorithm, name string, head []byte) bool {
+	if algo >= CompressAlgorithm(len(compressMagicArray)) || compressMagicArray[algo] == "" {
+		return false
+	}
+	if suffixAlgo, found := ParseCompressionSuffix(path.Ext(name)); found && suffixAlgo == algo {
+		return true
+	}
+	return bytes.HasPrefix(head, []byte(compressMagicArray[algo]))
+}
+
+func DetectCompression(head []byte) CompressAlgorithm {
+	for algo := CompressGZIP; algo < CompressAlgorithm(len(compressMagicArray)); algo++ {
+		if bytes.HasPrefix(head, []byte(compressMagicArray[algo])) {
+			return algo
+		}
+	}
+	return CompressNone
+}
+
+func (algo CompressAlgorithm) Validate() error {
+	switch algo {
+	case CompressAuto, CompressNone, CompressGZIP, CompressXZ, CompressZSTD:
+		return nil
+	case CompressBZIP2:
+		return fmt.Errorf("compression algorithm %v is not implemented", algo)
+	default:
+		return fmt.Errorf("invalid compression algorithm %#v", algo)
+	}
+}
+
 func (algo CompressAlgorithm) MarshalText() ([]byte, error) {
 	str := algo.String()
 	return []byte(str), nil
 }
 
+type CompressOptions struct {
+	ZstdWindowSize int
+	ModTime        time.Time
+	Level          int
+}
+
+var xzDictCapArray = [...]int{
+	256 << 10,
+	1 << 20,
+	2 << 20,
+	4 << 20,
+	4 << 20,
+	8 << 20,
+	8 << 20,
+	16 << 20,
+	32 << 20,
+	64 << 20,
+}
+
+func (algo CompressAlgorithm) ValidateLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+
+	var min, max int
+	switch algo {
+	case CompressGZIP:
+		min, max = gzip.BestSpeed, gzip.BestCompression
+	case CompressXZ:
+		min, max = 1, len(xzDictCapArray)-1
+	case CompressZSTD:
+		min, max = 1, 22
+	default:
+		return fmt.Errorf("compression algorithm %v does not support levels", algo)
+	}
+
+	if level < min || level > max {
+		return fmt.Errorf("level %d is out of range for %v; expected %d..%d", level, algo, min, max)
+	}
+	return nil
+}
+
+const gzipOSUnknown = 255
+
+const defaultZstdWindowSize = 1 << 23
+
 func (algo CompressAlgorithm) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return algo.NewWriterOptions(w, CompressOptions{})
+}
+
+func (algo CompressAlgorithm) NewWriterOptions(w io.Writer, opts CompressOptions) (io.WriteCloser, error) {
+	err := algo.ValidateLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
 	switch algo {
 	case CompressNone:
 		return &nopCloseWriter{w}, nil
 
 	case CompressGZIP:
-		cw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		level := opts.Level
+		if level == 0 {
+			level = gzip.BestCompression
+		}
+		cw, err := gzip.NewWriterLevel(w, level)
 		if err != nil {
-			return nil, fmt.Errorf("gzip.NewWriterLevel: %d: %w", gzip.BestCompression, err)
+			return nil, fmt.Errorf("gzip.NewWriterLevel: %d: %w", level, err)
+		}
+		cw.Header = gzip.Header{
+			Name:    "",
+			ModTime: opts.ModTime,
+			OS:      gzipOSUnknown,
 		}
 		return cw, nil
 
 	case CompressXZ:
-		cw, err := xz.NewWriter(w)
+		var config xz.WriterConfig
+		if opts.Level != 0 {
+			config.DictCap = xzDictCapArray[opts.Level]
+		}
+		cw, err := config.NewWriter(w)
 		if err != nil {
 			return nil, fmt.Errorf("xz.NewWriter: %w", err)
 		}
 		return cw, nil
 
 	case CompressZSTD:
-		cw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		windowSize := opts.ZstdWindowSize
+		if windowSize == 0 {
+			windowSize = defaultZstdWindowSize
+		}
+		level := zstd.SpeedBestCompression
+		if opts.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(opts.Level)
+		}
+		cw, err := zstd.NewWriter(
+			w,
+			zstd.WithEncoderLevel(level),
+			zstd.WithWindowSize(windowSize),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithEncoderCRC(true),
+		)
 		if err != nil {
 			return nil, fmt.Errorf("zstd.NewWriter: %w", err)
 		}
@@ -120,6 +263,40 @@ func (algo CompressAlgorithm) NewWriter(w io.Writer) (io.WriteCloser, error) {
 	}
 }
 
+func (algo CompressAlgorithm) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch algo {
+	case CompressNone:
+		return io.NopCloser(r), nil
+
+	case CompressGZIP:
+		cr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip.NewReader: %w", err)
+		}
+		return cr, nil
+
+	case CompressBZIP2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+
+	case CompressXZ:
+		cr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("xz.NewReader: %w", err)
+		}
+		return io.NopCloser(cr), nil
+
+	case CompressZSTD:
+		cr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("zstd.NewReader: %w", err)
+		}
+		return cr.IOReadCloser(), nil
+
+	default:
+		return nil, fmt.Errorf("invalid compression algorithm %#v", algo)
+	}
+}
+
 func (algo *CompressAlgorithm) Parse(input string) error {
 	if value, found := compressMap[input]; found {
 		*algo = value
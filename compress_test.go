@@ -0,0 +1,207 @@
+package mkdeb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestDetectCompression(t *testing.T) {
+	for _, algo := range []CompressAlgorithm{CompressNone, CompressGZIP, CompressXZ, CompressZSTD} {
+		var buf bytes.Buffer
+		cw, err := algo.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("%v: NewWriter: %v", algo, err)
+		}
+		if _, err := cw.Write([]byte("hello, world\n")); err != nil {
+			t.Fatalf("%v: Write: %v", algo, err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatalf("%v: Close: %v", algo, err)
+		}
+		if actual := DetectCompression(buf.Bytes()); actual != algo {
+			t.Errorf("DetectCompression(%v output): got %v", algo, actual)
+		}
+	}
+
+	if actual := DetectCompression([]byte("BZh91AY&SY")); actual != CompressBZIP2 {
+		t.Errorf("DetectCompression(bzip2 header): got %v", actual)
+	}
+	if actual := DetectCompression(nil); actual != CompressNone {
+		t.Errorf("DetectCompression(nil): got %v", actual)
+	}
+}
+
+func TestCompressAlgorithmValidate(t *testing.T) {
+	type testRow struct {
+		algo      CompressAlgorithm
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{CompressAuto, ""},
+		{CompressNone, ""},
+		{CompressGZIP, ""},
+		{CompressBZIP2, "compression algorithm bzip2 is not implemented"},
+		{CompressXZ, ""},
+		{CompressZSTD, ""},
+		{CompressAlgorithm(0x42), "invalid compression algorithm mkdeb.CompressAlgorithm(0x42)"},
+	}
+
+	for _, row := range testData {
+		err := row.algo.Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%v: unexpected error: %v", row.algo, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%v: expected error %q, got %v", row.algo, row.expectErr, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "--compression", "bzip2", "-m", "does-not-exist.json"})
+	if rc != 1 {
+		t.Errorf("Main: expected exit status 1, got %d", rc)
+	}
+	if expect := "error: -c / --compression: compression algorithm bzip2 is not implemented\n"; stderr.String() != expect {
+		t.Errorf("Main: expected %q on stderr, got %q", expect, stderr.String())
+	}
+}
+
+func TestParseCompressionSuffix(t *testing.T) {
+	type testRow struct {
+		suffix      string
+		expect      CompressAlgorithm
+		expectFound bool
+	}
+
+	testData := [...]testRow{
+		{"", CompressNone, true},
+		{".gz", CompressGZIP, true},
+		{".bz2", CompressBZIP2, true},
+		{".xz", CompressXZ, true},
+		{".zst", CompressZSTD, true},
+		{".zstd", CompressZSTD, true},
+		{".lzma", CompressAuto, false},
+	}
+
+	for _, row := range testData {
+		algo, found := ParseCompressionSuffix(row.suffix)
+		if algo != row.expect || found != row.expectFound {
+			t.Errorf("%q: expected %v, %v; got %v, %v", row.suffix, row.expect, row.expectFound, algo, found)
+		}
+	}
+
+	for _, algo := range []CompressAlgorithm{CompressNone, CompressGZIP, CompressBZIP2, CompressXZ, CompressZSTD} {
+		if parsed, found := ParseCompressionSuffix(algo.Suffix()); !found || parsed != algo {
+			t.Errorf("%v: suffix %q does not round-trip, got %v, %v", algo, algo.Suffix(), parsed, found)
+		}
+	}
+	if suffix := CompressZSTD.Suffix(); suffix != ".zst" {
+		t.Errorf("expected the dpkg-compatible zstd suffix %q, got %q", ".zst", suffix)
+	}
+}
+
+func TestGzipHeaderReproducible(t *testing.T) {
+	zero := time.Unix(1577836800, 0)
+	build := func() []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		cw, err := CompressGZIP.NewWriterOptions(&buf, CompressOptions{ModTime: zero})
+		if err != nil {
+			t.Fatalf("NewWriterOptions: %v", err)
+		}
+		if _, err := cw.Write([]byte("hello, world\n")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	savedLocal := time.Local
+	t.Cleanup(func() { time.Local = savedLocal })
+
+	var outputs [][]byte
+	for _, zone := range []*time.Location{time.UTC, time.FixedZone("UTC+9", 9*60*60)} {
+		time.Local = zone
+		outputs = append(outputs, build())
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("expected identical gzip output on both platforms")
+	}
+
+	header := outputs[0]
+	const flagFHCRC, flagFNAME, flagFCOMMENT = 0x02, 0x08, 0x10
+	if flags := header[3]; flags&(flagFHCRC|flagFNAME|flagFCOMMENT) != 0 {
+		t.Errorf("expected no header CRC, name, or comment, got flags 0x%02x", flags)
+	}
+	if mtime := binary.LittleEndian.Uint32(header[4:8]); int64(mtime) != zero.Unix() {
+		t.Errorf("expected mtime %d, got %d", zero.Unix(), mtime)
+	}
+	if os := header[9]; os != gzipOSUnknown {
+		t.Errorf("expected OS byte %d, got %d", gzipOSUnknown, os)
+	}
+
+	pkg := testBuild(t, Builder{Compression: CompressGZIP, ZeroTime: zero}, testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`))
+	for _, member := range testReadAr(t, pkg)[1:] {
+		if member.data[9] != gzipOSUnknown || member.data[3] != 0 {
+			t.Errorf("%s: expected a bare gzip header, got flags 0x%02x and OS %d", member.name, member.data[3], member.data[9])
+		}
+	}
+}
+
+func TestNewWriterOptionsLevel(t *testing.T) {
+	type testRow struct {
+		algo      CompressAlgorithm
+		level     int
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{CompressXZ, 0, ""},
+		{CompressXZ, 9, ""},
+		{CompressXZ, 10, "level 10 is out of range for xz; expected 1..9"},
+		{CompressXZ, -1, "level -1 is out of range for xz; expected 1..9"},
+		{CompressGZIP, 10, "level 10 is out of range for gzip; expected 1..9"},
+		{CompressZSTD, 23, "level 23 is out of range for zstd; expected 1..22"},
+		{CompressNone, 3, "compression algorithm none does not support levels"},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		cw, err := row.algo.NewWriterOptions(&buf, CompressOptions{Level: row.level})
+		if row.expectErr != "" {
+			if err == nil || err.Error() != row.expectErr {
+				t.Errorf("%v level %d: expected error %q, got %v", row.algo, row.level, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v level %d: unexpected error: %v", row.algo, row.level, err)
+			continue
+		}
+		if err := cw.Close(); err != nil {
+			t.Errorf("%v level %d: Close: %v", row.algo, row.level, err)
+		}
+	}
+
+	manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}]`)
+	if err := manifest.Resolve(nil); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	var buf bytes.Buffer
+	err := Builder{Compression: CompressXZ, CompressionLevel: 42}.BuildDataTarball(&buf, manifest)
+	if expect := "compression: level 42 is out of range for xz; expected 1..9"; err == nil || err.Error() != expect {
+		t.Errorf("BuildDataTarball: expected error %q, got %v", expect, err)
+	}
+	if err := (Builder{}).BuildDataTarball(&buf, manifest); err != nil {
+		t.Fatalf("BuildDataTarball: %v", err)
+	}
+	err = Builder{ControlCompression: CompressXZ, ControlCompressionLevel: 42}.BuildControlTarball(&buf, manifest)
+	if expect := "controlCompression: level 42 is out of range for xz; expected 1..9"; err == nil || err.Error() != expect {
+		t.Errorf("BuildControlTarball: expected error %q, got %v", expect, err)
+	}
+}
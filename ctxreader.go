@@ -0,0 +1,18 @@
+package mkdeb
+
+import (
+	"context"
+	"io"
+)
+
+type ctxReader struct {
+	ctx  context.Context
+	file io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.file.Read(p)
+}
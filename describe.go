@@ -0,0 +1,79 @@
+package mkdeb
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+type PackageDescription struct {
+	Filename string                   `json:"filename"`
+	Size     int64                    `json:"size"`
+	Hashes   map[HashAlgorithm]string `json:"hashes"`
+	Members  []ArMember               `json:"members"`
+	Control  []ControlField           `json:"control"`
+	Files    []FileDescription        `json:"files"`
+}
+
+type FileDescription struct {
+	Name   string                   `json:"name"`
+	Type   Type                     `json:"type"`
+	Size   int64                    `json:"size"`
+	Mode   string                   `json:"mode"`
+	User   Owner                    `json:"user"`
+	Group  Owner                    `json:"group"`
+	Link   string                   `json:"link,omitempty"`
+	Hashes map[HashAlgorithm]string `json:"hashes,omitempty"`
+}
+
+func (builder Builder) Describe(manifest *Manifest, filename string, artifact *Artifact) *PackageDescription {
+	if !manifest.isHashed {
+		panic(fmt.Errorf("must call BuildDataTarball first"))
+	}
+
+	desc := &PackageDescription{
+		Filename: filename,
+		Size:     artifact.Size,
+		Hashes:   hexHashes(artifact.Hashes),
+		Members:  artifact.Members,
+		Control:  builder.controlFields(manifest),
+		Files:    make([]FileDescription, 0, len(manifest.Files)),
+	}
+
+	for _, file := range manifest.Files {
+		hdr := file.tarHeader
+		if hdr == nil {
+			fallback := file.AsTarHeader()
+			hdr = &fallback
+		}
+
+		fd := FileDescription{
+			Name:   file.packagedName(),
+			Type:   file.Type,
+			Size:   file.size,
+			Mode:   fmt.Sprintf("%04o", hdr.Mode&0o7777),
+			User:   IDAndName(hdr.Uid, hdr.Uname),
+			Group:  IDAndName(hdr.Gid, hdr.Gname),
+			Link:   hdr.Linkname,
+			Hashes: hexHashes(file.hashes),
+		}
+		if hdr.Uname == "" {
+			fd.User = ID(hdr.Uid)
+		}
+		if hdr.Gname == "" {
+			fd.Group = ID(hdr.Gid)
+		}
+		desc.Files = append(desc.Files, fd)
+	}
+	return desc
+}
+
+func hexHashes(hashes map[HashAlgorithm][]byte) map[HashAlgorithm]string {
+	if len(hashes) == 0 {
+		return nil
+	}
+	out := make(map[HashAlgorithm]string, len(hashes))
+	for algo, sum := range hashes {
+		out[algo] = hex.EncodeToString(sum)
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package mkdeb
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type excludeRule struct {
+	rx      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func compileExcludes(patterns []string) ([]excludeRule, error) {
+	rules := make([]excludeRule, 0, len(patterns))
+	for index, pattern := range patterns {
+		rule, err := compileExclude(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("exclude[%d]: %w", index, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func compileExclude(pattern string) (excludeRule, error) {
+	var rule excludeRule
+
+	p := pattern
+	if strings.HasPrefix(p, "!") {
+		rule.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		rule.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return excludeRule{}, fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if !anchored {
+		p = "**/" + p
+	}
+
+	var buf strings.Builder
+	buf.WriteString("^")
+	segments := strings.Split(p, "/")
+	for index, segment := range segments {
+		isLast := (index == len(segments)-1)
+		if segment == "**" {
+			if isLast {
+				buf.WriteString(".*")
+			} else {
+				buf.WriteString("(?:[^/]*/)*")
+			}
+			continue
+		}
+		for _, ch := range segment {
+			switch ch {
+			case '*':
+				buf.WriteString("[^/]*")
+			case '?':
+				buf.WriteString("[^/]")
+			default:
+				buf.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		if !isLast {
+			buf.WriteString("/")
+		}
+	}
+	buf.WriteString("$")
+
+	rx, err := regexp.Compile(buf.String())
+	if err != nil {
+		return excludeRule{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	rule.rx = rx
+	return rule, nil
+}
+
+// isExcluded reports whether rules drop name.  As with .gitignore, a file
+// whose parent directory is excluded cannot be re-included by a later "!"
+// rule, because the directory it would be installed into is not packaged.
+func isExcluded(rules []excludeRule, name string, isDir bool) bool {
+	name = strings.TrimRight(name, "/")
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if matchExcludes(rules, dir, true) {
+			return true
+		}
+	}
+	return matchExcludes(rules, name, isDir)
+}
+
+func matchExcludes(rules []excludeRule, name string, isDir bool) bool {
+	excluded := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.rx.MatchString(name) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
@@ -0,0 +1,90 @@
+package mkdeb
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIsExcluded(t *testing.T) {
+	type testRow struct {
+		patterns []string
+		name     string
+		isDir    bool
+		expect   bool
+	}
+
+	testData := [...]testRow{
+		{[]string{"*.pyc"}, "foo.pyc", false, true},
+		{[]string{"*.pyc"}, "usr/lib/foo/bar.pyc", false, true},
+		{[]string{"*.pyc"}, "usr/lib/foo/bar.py", false, false},
+		{[]string{"**/__pycache__/**"}, "usr/lib/foo/__pycache__/bar.cpython-311.pyc", false, true},
+		{[]string{"**/__pycache__/**"}, "__pycache__/x", false, true},
+		{[]string{"**/__pycache__/**"}, "usr/lib/foo/__pycache__/", true, false},
+		{[]string{"__pycache__/"}, "usr/lib/foo/__pycache__/", true, true},
+		{[]string{"__pycache__/"}, "usr/lib/foo/__pycache__", false, false},
+		{[]string{"/usr/share/doc/"}, "usr/share/doc/foo/README", false, true},
+		{[]string{"/doc/"}, "usr/share/doc/foo/README", false, false},
+		{[]string{"*.pyc", "!keep.pyc"}, "usr/lib/keep.pyc", false, false},
+		{[]string{"!keep.pyc", "*.pyc"}, "usr/lib/keep.pyc", false, true},
+		{[]string{"usr/lib/", "!usr/lib/keep"}, "usr/lib/keep", false, true},
+		{[]string{"usr/lib/*", "!usr/lib/keep"}, "usr/lib/keep", false, false},
+		{[]string{"file?.txt"}, "file1.txt", false, true},
+		{[]string{"file?.txt"}, "file10.txt", false, false},
+	}
+
+	for _, row := range testData {
+		rules, err := compileExcludes(row.patterns)
+		if err != nil {
+			t.Errorf("%q: compileExcludes: %v", row.patterns, err)
+			continue
+		}
+		if actual := isExcluded(rules, row.name, row.isDir); actual != row.expect {
+			t.Errorf("%q: %s: expected %v, got %v", row.patterns, row.name, row.expect, actual)
+		}
+	}
+
+	for _, pattern := range []string{"", "!", "/", "!/"} {
+		if _, err := compileExclude(pattern); err == nil {
+			t.Errorf("compileExclude(%q): expected error", pattern)
+		}
+	}
+}
+
+func TestBuildExclude(t *testing.T) {
+	const files = `"files": [
+		{"name": "usr/"},
+		{"name": "usr/lib/"},
+		{"name": "usr/lib/foo/"},
+		{"name": "usr/lib/foo/util.py", "text": "x = 1\n"},
+		{"name": "usr/lib/foo/util.pyc", "bytesHex": "00112233"},
+		{"name": "usr/lib/foo/build/"},
+		{"name": "usr/lib/foo/build/util.o", "bytesHex": "44556677"},
+		{"name": "usr/lib/foo/build/stamp", "text": "ok\n"}
+	]`
+
+	excluded := testFooManifest(t, files)
+	pkg := testBuild(t, Builder{Exclude: []string{"*.pyc", "**/build/**"}}, excluded)
+
+	_, contents := testMemberTar(t, pkg, "data.tar")
+	var names []string
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expect := "usr/ usr/lib/ usr/lib/foo/ usr/lib/foo/build/ usr/lib/foo/util.py"
+	if got := strings.Join(names, " "); got != expect {
+		t.Errorf("data.tar: expected %q, got %q", expect, got)
+	}
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if md5sums := string(control["md5sums"]); strings.Contains(md5sums, "util.pyc") || strings.Contains(md5sums, "build/") {
+		t.Errorf("md5sums: expected no excluded files, got %q", md5sums)
+	}
+
+	full := testFooManifest(t, files)
+	testBuild(t, Builder{}, full)
+	if excluded.installedSize >= full.installedSize {
+		t.Errorf("expected excluded files to be left out of the installed size, got %d >= %d", excluded.installedSize, full.installedSize)
+	}
+}
@@ -0,0 +1,130 @@
+package mkdeb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isValidFetchURL(str string) bool {
+	u, err := url.Parse(str)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const defaultMaxFetchSize = 1 << 30
+
+func (builder Builder) Resolve(ctx context.Context, manifest *Manifest) error {
+	return manifest.resolve(ctx, builder.Root, builder.fetchFile)
+}
+
+func (builder Builder) fetchFile(ctx context.Context, file *File) error {
+	if !builder.AllowNetwork {
+		return fmt.Errorf("network access is disabled; set Builder.AllowNetwork to fetch %q", *file.URL)
+	}
+
+	if err := file.validateImpl(); err != nil {
+		return err
+	}
+
+	data, err := builder.fetchURL(ctx, *file.URL, strings.ToLower(file.SHA256))
+	if err != nil {
+		return err
+	}
+	file.fetched = data
+	return nil
+}
+
+func (builder Builder) maxFetchSize() int64 {
+	if builder.MaxFetchSize == 0 {
+		return defaultMaxFetchSize
+	}
+	return builder.MaxFetchSize
+}
+
+func (builder Builder) fetchURL(ctx context.Context, rawURL string, expectSHA256 string) ([]byte, error) {
+	var cachePath string
+	if builder.FetchCacheDir != "" {
+		cachePath = filepath.Join(builder.FetchCacheDir, expectSHA256)
+		data, err := os.ReadFile(cachePath)
+		if err == nil && checkSHA256(data, expectSHA256) == nil {
+			return data, nil
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read cache file: %q: %w", cachePath, err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	client := builder.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET %q: %w", rawURL, err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %q: unexpected status %q", rawURL, resp.Status)
+	}
+
+	limit := builder.maxFetchSize()
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("GET %q: response is %d bytes, which exceeds the limit of %d bytes", rawURL, resp.ContentLength, limit)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %q: %w", rawURL, err)
+	}
+	if n > limit {
+		return nil, fmt.Errorf("GET %q: response exceeds the limit of %d bytes", rawURL, limit)
+	}
+
+	data := buf.Bytes()
+	err = checkSHA256(data, expectSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("GET %q: %w", rawURL, err)
+	}
+
+	if cachePath != "" {
+		err = os.WriteFile(cachePath, data, 0o666)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write cache file: %q: %w", cachePath, err)
+		}
+	}
+
+	return data, nil
+}
+
+func checkSHA256(data []byte, expect string) error {
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if actual != expect {
+		return fmt.Errorf("SHA-256 mismatch: expected %s, got %s", expect, actual)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package mkdeb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testFetchContent = "fetched content\n"
+
+func testFetchServer(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		switch r.URL.Path {
+		case "/file":
+			_, _ = w.Write([]byte(testFetchContent))
+		case "/chunked":
+			for i := 0; i < 4; i++ {
+				_, _ = w.Write([]byte(testFetchContent))
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testFetchManifest(t *testing.T, url string, content string) *Manifest {
+	t.Helper()
+	sum := sha256.Sum256([]byte(content))
+	return testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/fetched", "url": "`+url+`", "sha256": "`+hex.EncodeToString(sum[:])+`"}]`)
+}
+
+func TestBuilderResolveFetchesURL(t *testing.T) {
+	var hits int32
+	srv := testFetchServer(t, &hits)
+	builder := Builder{AllowNetwork: true, HTTPClient: srv.Client(), FetchCacheDir: t.TempDir()}
+
+	manifest := testFetchManifest(t, srv.URL+"/file", testFetchContent)
+	if err := builder.Resolve(context.Background(), manifest); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got := string(manifest.Files[1].fetched); got != testFetchContent {
+		t.Errorf("expected fetched content %q, got %q", testFetchContent, got)
+	}
+
+	pkg := testBuild(t, builder, testFetchManifest(t, srv.URL+"/file", testFetchContent))
+	_, contents := testMemberTar(t, pkg, "data.tar")
+	if got := string(contents["etc/fetched"]); got != testFetchContent {
+		t.Errorf("expected packaged content %q, got %q", testFetchContent, got)
+	}
+	if hits != 1 {
+		t.Errorf("expected 1 request thanks to the cache, got %d", hits)
+	}
+}
+
+func TestManifestResolveRequiresFetch(t *testing.T) {
+	manifest := testFetchManifest(t, "http://example.com/file", testFetchContent)
+	err := manifest.Resolve(nil)
+	if err == nil || !strings.Contains(err.Error(), "Builder.Resolve") {
+		t.Errorf("expected an error pointing at Builder.Resolve, got %v", err)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	var hits int32
+	srv := testFetchServer(t, &hits)
+
+	type testRow struct {
+		name    string
+		builder Builder
+		path    string
+		content string
+		expect  string
+	}
+
+	testData := [...]testRow{
+		{"network disabled", Builder{}, "/file", testFetchContent, "network access is disabled"},
+		{"checksum mismatch", Builder{AllowNetwork: true}, "/file", "other content\n", "SHA-256 mismatch"},
+		{"not found", Builder{AllowNetwork: true}, "/missing", testFetchContent, "unexpected status"},
+		{"content length over limit", Builder{AllowNetwork: true, MaxFetchSize: 4}, "/file", testFetchContent, "exceeds the limit"},
+		{"chunked body over limit", Builder{AllowNetwork: true, MaxFetchSize: 20}, "/chunked", strings.Repeat(testFetchContent, 4), "exceeds the limit"},
+	}
+
+	for _, row := range testData {
+		builder := row.builder
+		builder.HTTPClient = srv.Client()
+		err := builder.Resolve(context.Background(), testFetchManifest(t, srv.URL+row.path, row.content))
+		if err == nil || !strings.Contains(err.Error(), row.expect) {
+			t.Errorf("%s: expected error containing %q, got %v", row.name, row.expect, err)
+		}
+	}
+}
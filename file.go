@@ -3,6 +3,8 @@ package mkdeb
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -12,25 +14,39 @@ import (
 )
 
 type File struct {
-	Name   string    `json:"name"`
-	Type   Type      `json:"type"`
-	IsConf bool      `json:"isConf"`
-	Perm   Perm      `json:"perm"`
-	User   Owner     `json:"user"`
-	Group  Owner     `json:"group"`
-	MTime  time.Time `json:"mtime"`
-	Major  *int64    `json:"major"`
-	Minor  *int64    `json:"minor"`
-	Path   *string   `json:"path"`
-	Text   *string   `json:"text"`
-	Bytes  *[]byte   `json:"bytes"`
-	Link   *string   `json:"link"`
-
-	isResolved bool  `json:"-"`
-	size       int64 `json:"-"`
-
-	isHashed bool                     `json:"-"`
-	hashes   map[HashAlgorithm][]byte `json:"-"`
+	Name     string    `json:"name"`
+	Type     Type      `json:"type"`
+	IsConf   bool      `json:"isConf"`
+	Perm     Perm      `json:"perm"`
+	User     Owner     `json:"user"`
+	Group    Owner     `json:"group"`
+	MTime    time.Time `json:"mtime"`
+	Major    *int64    `json:"major"`
+	Minor    *int64    `json:"minor"`
+	Path     *string   `json:"path"`
+	Text     *string   `json:"text"`
+	Lines    []string  `json:"lines"`
+	URL      *string   `json:"url"`
+	SHA256   string    `json:"sha256"`
+	Bytes    *[]byte   `json:"bytes"`
+	BytesHex *string   `json:"bytesHex"`
+	Link     *string   `json:"link"`
+	Arches   []string  `json:"arches"`
+
+	Encode           CompressAlgorithm `json:"encode"`
+	Sparse           bool              `json:"sparse"`
+	KeepEmpty        bool              `json:"keepEmpty"`
+	OmitFinalNewline bool              `json:"omitFinalNewline"`
+
+	isResolved bool        `json:"-"`
+	size       int64       `json:"-"`
+	srcMode    fs.FileMode `json:"-"`
+	encoded    []byte      `json:"-"`
+	fetched    []byte      `json:"-"`
+
+	isHashed  bool                     `json:"-"`
+	hashes    map[HashAlgorithm][]byte `json:"-"`
+	tarHeader *tar.Header              `json:"-"`
 }
 
 func (file File) Validate() error {
@@ -50,8 +66,17 @@ func (file *File) Resolve(fileSystem fs.FS) error {
 		switch {
 		case file.Bytes != nil:
 			size = int64(len(*file.Bytes))
+		case file.BytesHex != nil:
+			size = int64(hex.DecodedLen(len(*file.BytesHex)))
 		case file.Text != nil:
 			size = int64(len(*file.Text))
+		case file.Lines != nil:
+			size = int64(len(file.linesText()))
+		case file.URL != nil:
+			if file.fetched == nil {
+				return fmt.Errorf("url: content of %q has not been fetched; use Builder.Resolve to fetch it", *file.URL)
+			}
+			size = int64(len(file.fetched))
 		case file.Path != nil:
 			statPath = *file.Path
 			statNeeded = true
@@ -60,13 +85,28 @@ func (file *File) Resolve(fileSystem fs.FS) error {
 			statNeeded = true
 		}
 
+		file.srcMode = 0
+		if statNeeded && fileSystem == nil {
+			return fmt.Errorf("no filesystem to read %q from", statPath)
+		}
 		if statNeeded {
 			fi, err := fs.Stat(fileSystem, statPath)
 			if err != nil {
 				return fmt.Errorf("failed to stat %q: %w", statPath, err)
 			}
 			size = fi.Size()
+			file.srcMode = fi.Mode()
+		}
+	}
+
+	file.encoded = nil
+	if file.isEncoded() {
+		data, err := file.encodeContent(fileSystem)
+		if err != nil {
+			return err
 		}
+		file.encoded = data
+		size = int64(len(data))
 	}
 
 	file.size = size
@@ -74,17 +114,94 @@ func (file *File) Resolve(fileSystem fs.FS) error {
 	return nil
 }
 
+func (file File) isForArch(arch string) bool {
+	return len(file.Arches) == 0 || containsString(file.Arches, arch)
+}
+
+func (file File) linesText() string {
+	text := strings.Join(file.Lines, "\n")
+	if len(file.Lines) != 0 && !file.OmitFinalNewline {
+		text += "\n"
+	}
+	return text
+}
+
+func (file File) isEncoded() bool {
+	return file.Encode != CompressAuto && file.Encode != CompressNone
+}
+
+func (file File) archiveName() string {
+	if file.isEncoded() {
+		return file.Name + file.Encode.Suffix()
+	}
+	return file.Name
+}
+
+func (file File) packagedName() string {
+	if file.tarHeader == nil {
+		return file.archiveName()
+	}
+	return strings.TrimLeft(strings.TrimPrefix(file.tarHeader.Name, "./"), "/")
+}
+
+func (file File) encodeContent(fileSystem fs.FS) ([]byte, error) {
+	rc, err := file.sourceReader(fileSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("encode: Read: %w", err)
+	}
+
+	if isAlreadyCompressed(file.Encode, "", data) {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	cw, err := file.Encode.NewWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	_, err = cw.Write(data)
+	if err != nil {
+		return nil, fmt.Errorf("encode: Write: %w", err)
+	}
+
+	err = cw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("encode: Close: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
 func (file *File) validateImpl() error {
 	if file.Name == "" {
-		return fmt.Errorf("name: missing required field")
+		return validationErrorf("name", ValidationMissing, "missing required field")
 	}
 
+	if clean, ok := canonicalUnixPath(file.Name); !ok {
+		return validationErrorf("name", ValidationInvalid, "non-canonical Unix path %q; did you mean %q?", file.Name, clean)
+	}
 	if !isValidUnixPath(file.Name) {
-		return fmt.Errorf("name: invalid Unix path %q", file.Name)
+		return validationErrorf("name", ValidationInvalid, "invalid Unix path %q", file.Name)
+	}
+
+	for index, arch := range file.Arches {
+		if !isValidArch(arch) {
+			return validationErrorf(fmt.Sprintf("arches[%d]", index), ValidationInvalid, "invalid Debian package architecture %q", arch)
+		}
 	}
 
 	if !file.Type.IsValid() {
-		return fmt.Errorf("type: invalid value %#v", file.Type)
+		return validationErrorf("type", ValidationInvalid, "invalid value %#v", file.Type)
 	}
 
 	if file.Type == TypeAUTO {
@@ -98,72 +215,175 @@ func (file *File) validateImpl() error {
 		file.Name = strings.TrimRight(file.Name, "/") + "/"
 	} else {
 		if file.Name == "." || strings.HasSuffix(file.Name, "/") {
-			return fmt.Errorf("name: value is only appropriate for a directory: %q", file.Name)
+			return validationErrorf("name", ValidationInvalid, "value is only appropriate for a directory: %q", file.Name)
 		}
 	}
 
 	if file.Type == TypeREG {
 		if file.Path != nil {
 			name := *file.Path
+			if clean, ok := canonicalUnixPath(name); !ok {
+				return validationErrorf("path", ValidationInvalid, "non-canonical Unix path %q; did you mean %q?", name, clean)
+			}
 			if !isValidUnixPath(name) {
-				return fmt.Errorf("path: invalid Unix path %q", name)
+				return validationErrorf("path", ValidationInvalid, "invalid Unix path %q", name)
 			}
 			if strings.HasSuffix(name, "/") {
-				return fmt.Errorf("path: unexpected trailing '/': %q", name)
+				return validationErrorf("path", ValidationInvalid, "unexpected trailing '/': %q", name)
 			}
 		}
 		if file.Path != nil && file.Text != nil {
-			return fmt.Errorf("text: conflict with field \"path\"")
+			return validationErrorf("text", ValidationConflict, "conflict with field \"path\"")
 		}
 		if file.Path != nil && file.Bytes != nil {
-			return fmt.Errorf("bytes: conflict with field \"path\"")
+			return validationErrorf("bytes", ValidationConflict, "conflict with field \"path\"")
 		}
 		if file.Text != nil && file.Bytes != nil {
-			return fmt.Errorf("bytes: conflict with field \"text\"")
+			return validationErrorf("bytes", ValidationConflict, "conflict with field \"text\"")
+		}
+		if file.URL != nil {
+			if file.Path != nil {
+				return validationErrorf("url", ValidationConflict, "conflict with field \"path\"")
+			}
+			if file.Text != nil || file.Bytes != nil || file.BytesHex != nil || file.Lines != nil {
+				return validationErrorf("url", ValidationConflict, "conflict with inline content")
+			}
+			if !isValidFetchURL(*file.URL) {
+				return validationErrorf("url", ValidationInvalid, "expected an http or https URL, got %q", *file.URL)
+			}
+			if file.SHA256 == "" {
+				return validationErrorf("sha256", ValidationMissing, "missing required field for \"url\"")
+			}
+			if sum, err := hex.DecodeString(file.SHA256); err != nil || len(sum) != sha256.Size {
+				return validationErrorf("sha256", ValidationInvalid, "expected %d hex digits, got %q", 2*sha256.Size, file.SHA256)
+			}
+		} else if file.SHA256 != "" {
+			return validationErrorf("sha256", ValidationConflict, "requires field \"url\"")
+		}
+		if file.Lines != nil {
+			if file.Path != nil {
+				return validationErrorf("lines", ValidationConflict, "conflict with field \"path\"")
+			}
+			if file.Text != nil {
+				return validationErrorf("lines", ValidationConflict, "conflict with field \"text\"")
+			}
+			if file.Bytes != nil {
+				return validationErrorf("lines", ValidationConflict, "conflict with field \"bytes\"")
+			}
+			if file.BytesHex != nil {
+				return validationErrorf("lines", ValidationConflict, "conflict with field \"bytesHex\"")
+			}
+			for index, line := range file.Lines {
+				if strings.Contains(line, "\n") {
+					return validationErrorf(fmt.Sprintf("lines[%d]", index), ValidationInvalid, "unexpected newline in line")
+				}
+			}
+		} else if file.OmitFinalNewline {
+			return validationErrorf("omitFinalNewline", ValidationConflict, "requires field \"lines\"")
+		}
+		if file.BytesHex != nil {
+			if file.Path != nil {
+				return validationErrorf("bytesHex", ValidationConflict, "conflict with field \"path\"")
+			}
+			if file.Text != nil {
+				return validationErrorf("bytesHex", ValidationConflict, "conflict with field \"text\"")
+			}
+			if file.Bytes != nil {
+				return validationErrorf("bytesHex", ValidationConflict, "conflict with field \"bytes\"")
+			}
+			if _, err := hex.DecodeString(*file.BytesHex); err != nil {
+				return validationErrorf("bytesHex", ValidationInvalid, "expected hex-encoded string: %w", err)
+			}
+		}
+		if err := file.Encode.Validate(); err != nil {
+			return validationErrorf("encode", ValidationInvalid, "%w", err)
+		}
+		if file.isEncoded() && isAlreadyCompressed(file.Encode, file.Name, nil) {
+			return validationErrorf("encode", ValidationConflict, "name %q already ends with a %v suffix; omit it from \"name\"", file.Name, file.Encode)
+		}
+		if file.Sparse && file.isEncoded() {
+			return validationErrorf("sparse", ValidationConflict, "conflict with field \"encode\"")
 		}
 	} else {
 		if file.IsConf {
-			return fmt.Errorf("isConf: conflict with field \"type\"")
+			return validationErrorf("isConf", ValidationConflict, "conflict with field \"type\"")
+		}
+		if file.isEncoded() {
+			return validationErrorf("encode", ValidationConflict, "conflict with field \"type\"")
+		}
+		if file.Sparse {
+			return validationErrorf("sparse", ValidationConflict, "conflict with field \"type\"")
 		}
 		if file.Path != nil {
-			return fmt.Errorf("path: unexpected value for field: %q", *file.Path)
+			return validationErrorf("path", ValidationUnexpected, "unexpected value for field: %q", *file.Path)
 		}
 		if file.Text != nil {
-			return fmt.Errorf("text: unexpected value for field (%d bytes)", len(*file.Text))
+			return validationErrorf("text", ValidationUnexpected, "unexpected value for field (%d bytes)", len(*file.Text))
 		}
 		if file.Bytes != nil {
-			return fmt.Errorf("bytes: unexpected value for field (%d bytes)", len(*file.Bytes))
+			return validationErrorf("bytes", ValidationUnexpected, "unexpected value for field (%d bytes)", len(*file.Bytes))
+		}
+		if file.BytesHex != nil {
+			return validationErrorf("bytesHex", ValidationUnexpected, "unexpected value for field (%d hex digits)", len(*file.BytesHex))
+		}
+		if file.Lines != nil {
+			return validationErrorf("lines", ValidationUnexpected, "unexpected value for field (%d lines)", len(file.Lines))
+		}
+		if file.URL != nil {
+			return validationErrorf("url", ValidationUnexpected, "unexpected value for field: %q", *file.URL)
+		}
+		if file.SHA256 != "" {
+			return validationErrorf("sha256", ValidationUnexpected, "unexpected value for field: %q", file.SHA256)
+		}
+		if file.OmitFinalNewline {
+			return validationErrorf("omitFinalNewline", ValidationConflict, "conflict with field \"type\"")
 		}
 	}
 
+	if file.KeepEmpty && file.Type != TypeDIR {
+		return validationErrorf("keepEmpty", ValidationConflict, "conflict with field \"type\"")
+	}
+
 	if file.Type == TypeLNK {
 		if file.Link == nil {
-			return fmt.Errorf("link: missing required field")
+			return validationErrorf("link", ValidationMissing, "missing required field")
 		}
 		link := *file.Link
+		if link == "" {
+			return validationErrorf("link", ValidationInvalid, "target must not be empty")
+		}
+		if link == "." {
+			return validationErrorf("link", ValidationInvalid, "target must not be \".\" (a symlink to its own directory)")
+		}
 		clean := path.Clean(link)
 		if link != clean {
-			return fmt.Errorf("link: value is not canonical: expected %q, got %q", clean, link)
+			return validationErrorf("link", ValidationInvalid, "value is not canonical: expected %q, got %q", clean, link)
 		}
 	} else {
 		if file.Link != nil {
-			return fmt.Errorf("link: unexpected value for field: %q", *file.Link)
+			return validationErrorf("link", ValidationUnexpected, "unexpected value for field: %q", *file.Link)
 		}
 	}
 
 	if file.Type == TypeCHR || file.Type == TypeBLK {
 		if file.Major == nil {
-			return fmt.Errorf("major: missing required field")
+			return validationErrorf("major", ValidationMissing, "missing required field")
 		}
 		if file.Minor == nil {
-			return fmt.Errorf("major: missing required field")
+			return validationErrorf("minor", ValidationMissing, "missing required field")
+		}
+		if *file.Major < 0 {
+			return validationErrorf("major", ValidationInvalid, "negative value %d", *file.Major)
+		}
+		if *file.Minor < 0 {
+			return validationErrorf("minor", ValidationInvalid, "negative value %d", *file.Minor)
 		}
 	} else {
 		if file.Major != nil {
-			return fmt.Errorf("major: unexpected value for field: %d", *file.Major)
+			return validationErrorf("major", ValidationUnexpected, "unexpected value for field: %d", *file.Major)
 		}
 		if file.Minor != nil {
-			return fmt.Errorf("minor: unexpected value for field: %d", *file.Minor)
+			return validationErrorf("minor", ValidationUnexpected, "unexpected value for field: %d", *file.Minor)
 		}
 	}
 
@@ -178,22 +398,22 @@ func (file File) AsTarHeader() tar.Header {
 	var hdr tar.Header
 	hdr.Format = tar.FormatPAX
 	hdr.Typeflag = 0
-	hdr.Name = file.Name
+	hdr.Name = file.archiveName()
 	hdr.Mode = unixModeFMT
 	hdr.ModTime = file.MTime
 	hdr.Size = file.size
 
-	switch file.User.Type {
-	case OwnerByID:
+	if file.User.HasID() {
 		hdr.Uid = file.User.ID
-	case OwnerByName:
+	}
+	if file.User.HasName() {
 		hdr.Uname = file.User.Name
 	}
 
-	switch file.Group.Type {
-	case OwnerByID:
+	if file.Group.HasID() {
 		hdr.Gid = file.Group.ID
-	case OwnerByName:
+	}
+	if file.Group.HasName() {
 		hdr.Gname = file.Group.Name
 	}
 
@@ -233,7 +453,7 @@ func (file File) AsTarHeader() tar.Header {
 	}
 
 	perm := file.Perm
-	if perm == 0 {
+	if perm == 0 || file.Type == TypeLNK {
 		perm = defaultPerm
 	}
 	hdr.Mode |= int64(uint64(perm))
@@ -250,14 +470,52 @@ func (file File) Reader(fileSystem fs.FS) (io.ReadCloser, error) {
 		return emptyReadCloser{}, nil
 	}
 
+	if file.encoded != nil {
+		return io.NopCloser(bytes.NewReader(file.encoded)), nil
+	}
+
+	return file.sourceReader(fileSystem)
+}
+
+func (file File) sourcePath() (string, bool) {
+	isReg := file.Type == TypeREG || (file.Type == TypeAUTO && !strings.HasSuffix(file.Name, "/"))
+	switch {
+	case !isReg:
+		return "", false
+	case file.Bytes != nil, file.BytesHex != nil, file.Text != nil, file.Lines != nil, file.URL != nil:
+		return "", false
+	case file.Path != nil:
+		return *file.Path, true
+	default:
+		return file.Name, true
+	}
+}
+
+func (file File) sourceReader(fileSystem fs.FS) (io.ReadCloser, error) {
 	var name string
 	switch {
 	case file.Bytes != nil:
 		return io.NopCloser(bytes.NewReader(*file.Bytes)), nil
 
+	case file.BytesHex != nil:
+		data, err := hex.DecodeString(*file.BytesHex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hex: %w", err)
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+
 	case file.Text != nil:
 		return io.NopCloser(strings.NewReader(*file.Text)), nil
 
+	case file.Lines != nil:
+		return io.NopCloser(strings.NewReader(file.linesText())), nil
+
+	case file.URL != nil:
+		if file.fetched == nil {
+			return nil, fmt.Errorf("content of %q has not been fetched", *file.URL)
+		}
+		return io.NopCloser(bytes.NewReader(file.fetched)), nil
+
 	case file.Path != nil:
 		name = *file.Path
 
@@ -0,0 +1,236 @@
+package mkdeb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEncodedFile(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 100)
+	manifest := testFooManifest(t, `"files": [
+		{"name": "usr/"},
+		{"name": "usr/share/"},
+		{"name": "usr/share/doc/"},
+		{"name": "usr/share/doc/changelog", "text": `+strconv.Quote(text)+`, "encode": "gzip"},
+		{"name": "usr/share/doc/NEWS", "text": `+strconv.Quote(text)+`, "encode": "zstd"}
+	]`)
+	pkg := testBuild(t, Builder{}, manifest)
+
+	_, contents := testMemberTar(t, pkg, "data.tar")
+	for _, name := range []string{"usr/share/doc/changelog.gz", "usr/share/doc/NEWS.zst"} {
+		data, found := contents[name]
+		if !found {
+			t.Errorf("%s: missing from data.tar", name)
+			continue
+		}
+		if len(data) >= len(text) {
+			t.Errorf("%s: expected compressed content, got %d bytes", name, len(data))
+		}
+		if got := string(testDecompress(t, name, data)); got != text {
+			t.Errorf("%s: decompressed content does not match the original", name)
+		}
+	}
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	md5sums := string(control["md5sums"])
+	if !strings.Contains(md5sums, "  usr/share/doc/changelog.gz\n") || !strings.Contains(md5sums, "  usr/share/doc/NEWS.zst\n") {
+		t.Errorf("md5sums: expected the encoded names, got %q", md5sums)
+	}
+}
+
+func TestFileValidateLink(t *testing.T) {
+	type testRow struct {
+		file      string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`{"name": "a", "type": "symlink", "link": "b"}`, ""},
+		{`{"name": "a", "type": "symlink", "link": "../b"}`, ""},
+		{`{"name": "a", "type": "symlink", "link": "/usr/bin/b"}`, ""},
+		{`{"name": "a", "type": "symlink"}`, "link: missing required field"},
+		{`{"name": "a", "type": "symlink", "link": ""}`, "link: target must not be empty"},
+		{`{"name": "a", "type": "symlink", "link": "."}`, `link: target must not be "." (a symlink to its own directory)`},
+		{`{"name": "a", "type": "symlink", "link": "b/../c"}`, `link: value is not canonical: expected "c", got "b/../c"`},
+		{`{"name": "a", "text": "x", "link": "b"}`, `link: unexpected value for field: "b"`},
+	}
+
+	for _, row := range testData {
+		var file File
+		if err := json.Unmarshal([]byte(row.file), &file); err != nil {
+			t.Fatalf("%s: Unmarshal: %v", row.file, err)
+		}
+		err := file.Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", row.file, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%s: expected error %q, got %v", row.file, row.expectErr, err)
+		}
+	}
+}
+
+func TestFileLines(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/foo.conf", "lines": ["[foo]", "bar = 1", ""]},
+		{"name": "etc/bare", "lines": ["a", "b"], "omitFinalNewline": true},
+		{"name": "etc/empty", "lines": []}
+	]`)
+	pkg := testBuild(t, Builder{Hashes: []HashAlgorithm{HashSHA256}}, manifest)
+
+	expect := map[string]string{
+		"etc/foo.conf": "[foo]\nbar = 1\n\n",
+		"etc/bare":     "a\nb",
+		"etc/empty":    "",
+	}
+	headers, contents := testMemberTar(t, pkg, "data.tar")
+	for _, hdr := range headers {
+		if want, found := expect[hdr.Name]; found && hdr.Size != int64(len(want)) {
+			t.Errorf("%s: expected size %d, got %d", hdr.Name, len(want), hdr.Size)
+		}
+	}
+	var sums strings.Builder
+	for _, name := range []string{"etc/foo.conf", "etc/bare", "etc/empty"} {
+		if got := string(contents[name]); got != expect[name] {
+			t.Errorf("%s: expected %q, got %q", name, expect[name], got)
+		}
+		sum := sha256.Sum256([]byte(expect[name]))
+		sums.WriteString(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+	}
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if got := string(control[HashSHA256.FileName()]); got != sums.String() {
+		t.Errorf("%s: expected %q, got %q", HashSHA256.FileName(), sums.String(), got)
+	}
+
+	for _, fields := range []string{
+		`"lines": ["a"], "text": "a\n"`,
+		`"lines": ["a\nb"]`,
+		`"text": "a", "omitFinalNewline": true`,
+	} {
+		manifest := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", `+fields+`}]`)
+		if err := manifest.Validate(); err == nil {
+			t.Errorf("%s: expected a validation error", fields)
+		}
+	}
+}
+
+func TestEncodeAlreadyCompressed(t *testing.T) {
+	text := strings.Repeat(".TH FOO 1\nfoo \\- do foo things\n", 50)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(text))
+	gw.Close()
+
+	root := fstest.MapFS{
+		"src/foo.1.gz": {Data: gz.Bytes(), Mode: 0o644},
+		"src/bar.1":    {Data: []byte(text), Mode: 0o644},
+	}
+	manifest := testFooManifest(t, `"files": [
+		{"name": "usr/"},
+		{"name": "usr/share/"},
+		{"name": "usr/share/man/"},
+		{"name": "usr/share/man/man1/"},
+		{"name": "usr/share/man/man1/foo.1", "path": "src/foo.1.gz", "encode": "gzip"},
+		{"name": "usr/share/man/man1/bar.1", "path": "src/bar.1", "encode": "gzip"}
+	]`)
+	pkg := testBuild(t, Builder{Root: root}, manifest)
+
+	_, contents := testMemberTar(t, pkg, "data.tar")
+	if got := contents["usr/share/man/man1/foo.1.gz"]; !bytes.Equal(got, gz.Bytes()) {
+		t.Errorf("foo.1.gz: expected the source bytes unchanged, got %d bytes", len(got))
+	}
+	for _, name := range []string{"usr/share/man/man1/foo.1.gz", "usr/share/man/man1/bar.1.gz"} {
+		if got := string(testDecompress(t, name, contents[name])); got != text {
+			t.Errorf("%s: expected a single layer of gzip around the original text", name)
+		}
+	}
+
+	if !isAlreadyCompressed(CompressGZIP, "foo.1.gz", nil) {
+		t.Errorf("isAlreadyCompressed: expected the .gz suffix to be recognized")
+	}
+	if isAlreadyCompressed(CompressGZIP, "foo.1", []byte(text)) {
+		t.Errorf("isAlreadyCompressed: expected plain text not to be recognized")
+	}
+}
+
+func TestFileValidateCanonicalName(t *testing.T) {
+	type testRow struct {
+		name      string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{"etc/a", ""},
+		{"etc/", ""},
+		{"etc//a", `name: non-canonical Unix path "etc//a"; did you mean "etc/a"?`},
+		{"etc/./a", `name: non-canonical Unix path "etc/./a"; did you mean "etc/a"?`},
+		{"etc/a/.", `name: non-canonical Unix path "etc/a/."; did you mean "etc/a"?`},
+		{"etc/b/../a", `name: non-canonical Unix path "etc/b/../a"; did you mean "etc/a"?`},
+		{"etc//", `name: non-canonical Unix path "etc//"; did you mean "etc/"?`},
+		{"etc/./foo/", `name: non-canonical Unix path "etc/./foo/"; did you mean "etc/foo/"?`},
+		{"/etc/a", `name: invalid Unix path "/etc/a"`},
+	}
+
+	for _, row := range testData {
+		file := File{Name: row.name}
+		if !strings.HasSuffix(row.name, "/") {
+			text := "x\n"
+			file.Text = &text
+		}
+		err := file.Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%q: unexpected error: %v", row.name, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%q: expected error %q, got %v", row.name, row.expectErr, err)
+		}
+	}
+
+	source := "src/./a.conf"
+	file := File{Name: "etc//a.conf", Path: &source}
+	if err := file.Validate(); err == nil || err.Error() != `name: non-canonical Unix path "etc//a.conf"; did you mean "etc/a.conf"?` {
+		t.Errorf("expected the name to be checked first, got %v", err)
+	}
+	file.Name = "etc/a.conf"
+	if err := file.Validate(); err == nil || err.Error() != `path: non-canonical Unix path "src/./a.conf"; did you mean "src/a.conf"?` {
+		t.Errorf("expected a non-canonical path error, got %v", err)
+	}
+}
+
+func TestEncodeSuffixConflict(t *testing.T) {
+	type testRow struct {
+		file      string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`{"name": "usr/share/doc/changelog", "text": "x\n", "encode": "gzip"}`, ""},
+		{`{"name": "usr/share/doc/changelog.gz", "text": "x\n"}`, ""},
+		{`{"name": "usr/share/doc/changelog.gz", "text": "x\n", "encode": "zstd"}`, ""},
+		{`{"name": "usr/share/doc/changelog.gz", "text": "x\n", "encode": "gzip"}`, `encode: name "usr/share/doc/changelog.gz" already ends with a gzip suffix; omit it from "name"`},
+		{`{"name": "usr/share/doc/NEWS.zst", "text": "x\n", "encode": "zstd"}`, `encode: name "usr/share/doc/NEWS.zst" already ends with a zstd suffix; omit it from "name"`},
+	}
+
+	for _, row := range testData {
+		var file File
+		if err := json.Unmarshal([]byte(row.file), &file); err != nil {
+			t.Fatalf("%s: Unmarshal: %v", row.file, err)
+		}
+		err := file.Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", row.file, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%s: expected error %q, got %v", row.file, row.expectErr, err)
+		}
+	}
+}
@@ -40,11 +40,17 @@ var hashNameArray = [...]string{
 }
 
 var hashFileNameArray = [...]string{
-	"md5sum",
+	"md5sums",
 	"sha1sum",
 	"sha256sum",
 }
 
+var hashPackagesFieldArray = [...]string{
+	"MD5sum",
+	"SHA1",
+	"SHA256",
+}
+
 var hashMap = map[string]HashAlgorithm{
 	"md5":     HashMD5,
 	"md-5":    HashMD5,
@@ -77,6 +83,13 @@ func (algo HashAlgorithm) FileName() string {
 	return fmt.Sprintf("cksum.%02x", byte(algo))
 }
 
+func (algo HashAlgorithm) PackagesField() string {
+	if algo < HashAlgorithm(len(hashPackagesFieldArray)) {
+		return hashPackagesFieldArray[algo]
+	}
+	return fmt.Sprintf("Checksum-%02x", byte(algo))
+}
+
 func (algo HashAlgorithm) MarshalText() ([]byte, error) {
 	str := algo.String()
 	return []byte(str), nil
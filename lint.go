@@ -0,0 +1,185 @@
+package mkdeb
+
+import (
+	"encoding"
+	"fmt"
+	"strings"
+)
+
+type Severity byte
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+var severityGoNameArray = [...]string{
+	"mkdeb.SeverityInfo",
+	"mkdeb.SeverityWarning",
+	"mkdeb.SeverityError",
+}
+
+var severityNameArray = [...]string{
+	"info",
+	"warning",
+	"error",
+}
+
+func (sev Severity) GoString() string {
+	if sev < Severity(len(severityGoNameArray)) {
+		return severityGoNameArray[sev]
+	}
+	return fmt.Sprintf("mkdeb.Severity(0x%02x)", byte(sev))
+}
+
+func (sev Severity) String() string {
+	if sev < Severity(len(severityNameArray)) {
+		return severityNameArray[sev]
+	}
+	return fmt.Sprintf("severity#%02x", byte(sev))
+}
+
+func (sev Severity) MarshalText() ([]byte, error) {
+	str := sev.String()
+	return []byte(str), nil
+}
+
+var (
+	_ fmt.GoStringer         = Severity(0)
+	_ fmt.Stringer           = Severity(0)
+	_ encoding.TextMarshaler = Severity(0)
+)
+
+type Warning struct {
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field"`
+	Message  string   `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%v: %s: %s", w.Severity, w.Field, w.Message)
+}
+
+var _ fmt.Stringer = Warning{}
+
+func (manifest Manifest) Warnings() []Warning {
+	if !manifest.isResolved {
+		panic(fmt.Errorf("must call Resolve first"))
+	}
+	return manifest.warnings
+}
+
+func (manifest Manifest) Lint() []Warning {
+	var out []Warning
+	add := func(sev Severity, field string, format string, args ...any) {
+		out = append(out, Warning{
+			Severity: sev,
+			Field:    field,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if manifest.Section == "" {
+		add(SeverityWarning, "section", "missing recommended field")
+	}
+
+	if manifest.Priority == "" {
+		add(SeverityWarning, "priority", "missing recommended field")
+	} else if manifest.Priority == "extra" {
+		add(SeverityInfo, "priority", "priority %q is deprecated; use \"optional\" instead", manifest.Priority)
+	}
+
+	if isWildcardArch(manifest.Arch) {
+		add(SeverityWarning, "arch", "wildcard architecture %q is only meaningful in source control files", manifest.Arch)
+	}
+	for index, arch := range manifest.Arches {
+		if isWildcardArch(arch) {
+			add(SeverityWarning, fmt.Sprintf("arches[%d]", index), "wildcard architecture %q is only meaningful in source control files", arch)
+		}
+	}
+
+	synopsis := manifest.ShortDescription
+	if synopsis != "" {
+		lc := strings.ToLower(synopsis)
+		for _, article := range [...]string{"a ", "an ", "the "} {
+			if strings.HasPrefix(lc, article) {
+				add(SeverityWarning, "shortDescription", "synopsis should not start with an article: %q", synopsis)
+				break
+			}
+		}
+		if strings.HasSuffix(synopsis, ".") && !strings.HasSuffix(synopsis, "...") {
+			add(SeverityWarning, "shortDescription", "synopsis should not end with a period: %q", synopsis)
+		}
+		if len(synopsis) > 80 {
+			add(SeverityWarning, "shortDescription", "synopsis is too long: %d bytes exceeds 80", len(synopsis))
+		}
+		if strings.EqualFold(synopsis, manifest.Package) {
+			add(SeverityWarning, "shortDescription", "synopsis is just the package name")
+		}
+	}
+
+	if long := manifest.LongDescription; len(long) == 0 {
+		add(SeverityInfo, "longDescription", "missing extended description")
+	} else {
+		last := len(long) - 1
+		if long[0] == "" {
+			add(SeverityWarning, "longDescription[0]", "extended description starts with a blank line")
+		}
+		if last > 0 && long[last] == "" {
+			add(SeverityWarning, fmt.Sprintf("longDescription[%d]", last), "extended description ends with a blank line")
+		}
+		for index := 1; index <= last; index++ {
+			if long[index] == "" && long[index-1] == "" {
+				add(SeverityWarning, fmt.Sprintf("longDescription[%d]", index), "consecutive blank lines in extended description")
+			}
+		}
+	}
+
+	if manifest.HomePage != "" && !strings.Contains(manifest.HomePage, "://") {
+		add(SeverityWarning, "homePage", "URL has no scheme: %q", manifest.HomePage)
+	}
+
+	if manifest.Maintainer != "" && !strings.Contains(manifest.Maintainer, "<") {
+		add(SeverityWarning, "maintainer", "no email address: %q", manifest.Maintainer)
+	}
+
+	for index, file := range manifest.Files {
+		name := strings.TrimRight(file.Name, "/")
+		if _, found := controlMemberNames[name]; found {
+			add(SeverityWarning, fmt.Sprintf("files[%d].name", index), "%q is the name of a control archive member; this file will be installed as /%s", name, name)
+		}
+
+		isReg := file.Type == TypeREG || (file.Type == TypeAUTO && !strings.HasSuffix(file.Name, "/"))
+		if isReg && isInBinDir(name) {
+			perm := file.Perm
+			if perm == 0 {
+				perm = 0o644
+			}
+			if perm&0o111 == 0 {
+				add(SeverityWarning, fmt.Sprintf("files[%d].perm", index), "%q is in a bin directory but is not executable (mode %04o)", name, uint64(perm))
+			}
+		}
+	}
+
+	return out
+}
+
+func isWildcardArch(arch string) bool {
+	return arch == "any" || strings.HasPrefix(arch, "any-") || strings.HasSuffix(arch, "-any")
+}
+
+var controlMemberNames = map[string]struct{}{
+	"control":   {},
+	"md5sums":   {},
+	"conffiles": {},
+	"preinst":   {},
+	"postinst":  {},
+	"prerm":     {},
+	"postrm":    {},
+	"config":    {},
+	"templates": {},
+	"triggers":  {},
+	"shlibs":    {},
+	"symbols":   {},
+}
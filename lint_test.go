@@ -0,0 +1,203 @@
+package mkdeb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	type testRow struct {
+		name   string
+		edit   func(manifest *Manifest)
+		expect []Warning
+	}
+
+	testData := [...]testRow{
+		{"clean", func(manifest *Manifest) {}, nil},
+		{"missing section", func(manifest *Manifest) { manifest.Section = "" }, []Warning{
+			{SeverityWarning, "section", "missing recommended field"},
+		}},
+		{"deprecated priority", func(manifest *Manifest) { manifest.Priority = "extra" }, []Warning{
+			{SeverityInfo, "priority", `priority "extra" is deprecated; use "optional" instead`},
+		}},
+		{"wildcard arch", func(manifest *Manifest) { manifest.Arch = "any" }, []Warning{
+			{SeverityWarning, "arch", `wildcard architecture "any" is only meaningful in source control files`},
+		}},
+		{"synopsis article and period", func(manifest *Manifest) { manifest.ShortDescription = "The foo tool." }, []Warning{
+			{SeverityWarning, "shortDescription", `synopsis should not start with an article: "The foo tool."`},
+			{SeverityWarning, "shortDescription", `synopsis should not end with a period: "The foo tool."`},
+		}},
+		{"synopsis ellipsis", func(manifest *Manifest) { manifest.ShortDescription = "foo and more..." }, nil},
+		{"synopsis is package name", func(manifest *Manifest) { manifest.ShortDescription = "FOO" }, []Warning{
+			{SeverityWarning, "shortDescription", "synopsis is just the package name"},
+		}},
+		{"missing long description", func(manifest *Manifest) { manifest.LongDescription = nil }, []Warning{
+			{SeverityInfo, "longDescription", "missing extended description"},
+		}},
+		{"blank lines", func(manifest *Manifest) { manifest.LongDescription = []string{"", "a", "", "", "b", ""} }, []Warning{
+			{SeverityWarning, "longDescription[0]", "extended description starts with a blank line"},
+			{SeverityWarning, "longDescription[5]", "extended description ends with a blank line"},
+			{SeverityWarning, "longDescription[3]", "consecutive blank lines in extended description"},
+		}},
+		{"homepage without scheme", func(manifest *Manifest) { manifest.HomePage = "example.com" }, []Warning{
+			{SeverityWarning, "homePage", `URL has no scheme: "example.com"`},
+		}},
+		{"homepage with scheme", func(manifest *Manifest) { manifest.HomePage = "https://example.com/" }, nil},
+		{"maintainer without email", func(manifest *Manifest) { manifest.Maintainer = "Jane Doe" }, []Warning{
+			{SeverityWarning, "maintainer", `no email address: "Jane Doe"`},
+		}},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, `"section": "misc", "priority": "optional", "longDescription": ["More about foo."]`)
+		row.edit(manifest)
+		actual := manifest.Lint()
+		if len(actual) != len(row.expect) {
+			t.Errorf("%s: expected %d warnings %v, got %d warnings %v", row.name, len(row.expect), row.expect, len(actual), actual)
+			continue
+		}
+		for index := range actual {
+			if actual[index] != row.expect[index] {
+				t.Errorf("%s: warning %d: expected %v, got %v", row.name, index, row.expect[index], actual[index])
+			}
+		}
+	}
+}
+
+func TestLintControlMemberName(t *testing.T) {
+	manifest := testFooManifest(t, `"section": "misc", "priority": "optional", "longDescription": ["More about foo."], "files": [
+		{"name": "postinst", "text": "x\n"},
+		{"name": "etc/"},
+		{"name": "etc/postinst", "text": "x\n"}
+	]`)
+	expect := Warning{SeverityWarning, "files[0].name", `"postinst" is the name of a control archive member; this file will be installed as /postinst`}
+
+	actual := manifest.Lint()
+	if len(actual) != 1 || actual[0] != expect {
+		t.Errorf("expected [%v], got %v", expect, actual)
+	}
+
+	var reported []Warning
+	testBuild(t, Builder{OnWarning: func(w Warning) { reported = append(reported, w) }}, manifest)
+	if len(reported) != 1 || reported[0] != expect {
+		t.Errorf("OnWarning: expected [%v], got %v", expect, reported)
+	}
+
+	var buf bytes.Buffer
+	err := Builder{Strict: true}.Build(&buf, testFooManifest(t, `"section": "misc", "priority": "optional", "files": [{"name": "postinst", "text": "x\n"}]`))
+	if err == nil || !strings.Contains(err.Error(), "control archive member") {
+		t.Errorf("Strict: expected the warning to fail the build, got %v", err)
+	}
+}
+
+func TestLongDescriptionBlankLines(t *testing.T) {
+	type testRow struct {
+		long   string
+		expect string
+	}
+
+	testData := [...]testRow{
+		{`["a", "", "b"]`, ""},
+		{`["", "a"]`, "longDescription[0]: extended description starts with a blank line"},
+		{`["a", ""]`, "longDescription[1]: extended description ends with a blank line"},
+		{`["a", "", "", "b"]`, "longDescription[2]: consecutive blank lines in extended description"},
+	}
+
+	for _, row := range testData {
+		var warnings []string
+		builder := Builder{OnWarning: func(w Warning) {
+			if w.Severity >= SeverityWarning {
+				warnings = append(warnings, w.Field+": "+w.Message)
+			}
+		}}
+		fields := `"section": "misc", "priority": "optional", "longDescription": ` + row.long
+		testBuild(t, builder, testFooManifest(t, fields))
+		if got := strings.Join(warnings, "; "); got != row.expect {
+			t.Errorf("%s: expected warnings %q, got %q", row.long, row.expect, got)
+		}
+
+		var buf bytes.Buffer
+		err := Builder{Strict: true}.Build(&buf, testFooManifest(t, fields))
+		if row.expect == "" && err != nil {
+			t.Errorf("%s: Strict: unexpected error: %v", row.long, err)
+		}
+		if row.expect != "" && (err == nil || !strings.Contains(err.Error(), row.expect)) {
+			t.Errorf("%s: Strict: expected error containing %q, got %v", row.long, row.expect, err)
+		}
+	}
+}
+
+func TestWarningsCollected(t *testing.T) {
+	const fields = `"priority": "optional"`
+	expect := []Warning{
+		{SeverityWarning, "section", "missing recommended field"},
+		{SeverityInfo, "longDescription", "missing extended description"},
+	}
+
+	var reported []Warning
+	testBuild(t, Builder{OnWarning: func(w Warning) { reported = append(reported, w) }}, testFooManifest(t, fields))
+	if len(reported) != len(expect) {
+		t.Fatalf("OnWarning: expected %v, got %v", expect, reported)
+	}
+	for index := range reported {
+		if reported[index] != expect[index] {
+			t.Errorf("OnWarning: warning %d: expected %v, got %v", index, expect[index], reported[index])
+		}
+	}
+
+	manifest := testFooManifest(t, fields)
+	if err := manifest.Resolve(nil); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if warnings := manifest.Warnings(); len(warnings) != len(expect) {
+		t.Errorf("Warnings: expected %v, got %v", expect, warnings)
+	}
+
+	var buf bytes.Buffer
+	err := Builder{Strict: true}.Build(&buf, testFooManifest(t, fields))
+	if err == nil || err.Error() != "strict: warning: section: missing recommended field" {
+		t.Errorf("Strict: expected the warning to fail the build, got %v", err)
+	}
+
+	buf.Reset()
+	err = Builder{Strict: true}.Build(&buf, testFooManifest(t, `"section": "misc", "priority": "optional"`))
+	if err != nil {
+		t.Errorf("Strict: expected an info-level finding not to fail the build, got %v", err)
+	}
+}
+
+func TestLintBinPerms(t *testing.T) {
+	manifest := testFooManifest(t, `"section": "misc", "priority": "optional", "longDescription": ["More about foo."], "files": [
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/plain", "text": "x\n"},
+		{"name": "usr/bin/tool", "text": "x\n", "perm": "0755"},
+		{"name": "usr/sbin/"},
+		{"name": "usr/sbin/daemon", "text": "x\n", "perm": "0600"},
+		{"name": "usr/sbin/admin", "text": "x\n", "perm": "0700"},
+		{"name": "usr/bin/link", "type": "symlink", "link": "tool"},
+		{"name": "usr/share/"},
+		{"name": "usr/share/doc", "text": "x\n"}
+	]`)
+	expect := []Warning{
+		{SeverityWarning, "files[2].perm", `"usr/bin/plain" is in a bin directory but is not executable (mode 0644)`},
+		{SeverityWarning, "files[5].perm", `"usr/sbin/daemon" is in a bin directory but is not executable (mode 0600)`},
+	}
+
+	actual := manifest.Lint()
+	if len(actual) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, actual)
+	}
+	for index := range actual {
+		if actual[index] != expect[index] {
+			t.Errorf("warning %d: expected %v, got %v", index, expect[index], actual[index])
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Builder{Strict: true}.Build(&buf, manifest)
+	if err == nil || !strings.Contains(err.Error(), "is in a bin directory but is not executable") {
+		t.Errorf("Strict: expected the warning to fail the build, got %v", err)
+	}
+}
@@ -2,11 +2,15 @@ package mkdeb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	getopt "github.com/pborman/getopt/v2"
@@ -51,24 +55,69 @@ func SetVersion(pairs ...string) {
 	versionDataMap = values
 }
 
+func toolVersion() string {
+	if version, found := versionDataMap["version"]; found && version != "" {
+		return version
+	}
+	return "unknown"
+}
+
+var runSelfTest = SelfTest
+
 func Main(stdout io.Writer, stderr io.Writer, argv []string) int {
 	var (
 		isHelp       bool
 		isVersion    bool
+		isLint       bool
+		isIfChanged  bool
+		isListDirs   bool
+		isStrict     bool
+		isNetwork    bool
+		isSelfTest   bool
+		isPrintCtrl  bool
+		isSortConf   bool
+		isBuildIds   bool
 		rootPath     string
 		manifestPath string
-		filePath     string
+		filePaths    []string
+		stanzaPath   string
+		releasePath  string
+		sumsPath     string
+		describePath string
+		outputMode   string
 		compress     CompressAlgorithm
+		ctrlCompress CompressAlgorithm
+		compressLvl  int
+		ctrlLvl      int
+		hashNames    []string
 	)
 
 	flagSet := getopt.New()
 	flagSet.SetParameters("")
 	flagSet.FlagLong(&isHelp, "help", 'h', "show usage")
 	flagSet.FlagLong(&isVersion, "version", 'V', "show version")
-	flagSet.FlagLong(&rootPath, "root", 'R', "path to root directory for input files")
+	flagSet.FlagLong(&isSelfTest, "self-test", 0, "build a built-in sample package in memory, verify it, and exit")
+	flagSet.FlagLong(&rootPath, "root", 'R', "path to root directory (or .tar, .tar.gz, .zip archive) for input files")
 	flagSet.FlagLong(&manifestPath, "manifest", 'm', "path to input manifest file (JSON)")
-	flagSet.FlagLong(&filePath, "output", 'o', "path to output .deb package file")
+	flagSet.FlagLong(&filePaths, "output", 'o', "path to output .deb package file (or directory, if the manifest lists multiple arches); may be repeated to write several copies")
+	flagSet.FlagLong(&outputMode, "output-mode", 0, "octal permissions for newly created output .deb files (default 0666, subject to umask)")
 	flagSet.FlagLong(&compress, "compression", 'c', "compression algorithm: {none|gzip|bzip2|xz|zstd}")
+	flagSet.FlagLong(&compressLvl, "compression-level", 0, "compression level for data.tar (0 for the algorithm's best)")
+	flagSet.FlagLong(&ctrlCompress, "control-compression", 0, "compression algorithm for control.tar: {auto|none|gzip|xz|zstd}")
+	flagSet.FlagLong(&ctrlLvl, "control-compression-level", 0, "compression level for control.tar (0 for the algorithm's best)")
+	flagSet.FlagLong(&hashNames, "hash", 0, "hash algorithms for the control checksum members: {md5|sha1|sha256}, or \"none\" to write none; may be repeated")
+	flagSet.FlagLong(&stanzaPath, "packages-stanza", 0, "path to output Packages index stanza for the built package(s)")
+	flagSet.FlagLong(&releasePath, "release-out", 0, "path to output Release-style SHA256 listing for the built package(s)")
+	flagSet.FlagLong(&sumsPath, "files-sha256", 0, "path to output SHA-256 listing of packaged files (or directory, if the manifest lists multiple arches)")
+	flagSet.FlagLong(&describePath, "describe-out", 0, "path to output JSON description of the built package (or directory, if the manifest lists multiple arches)")
+	flagSet.FlagLong(&isLint, "lint", 0, "check the manifest against packaging policy and exit")
+	flagSet.FlagLong(&isListDirs, "list-missing-dirs", 0, "list parent directories the manifest must declare and exit")
+	flagSet.FlagLong(&isPrintCtrl, "print-control", 0, "print the generated control file(s) and exit")
+	flagSet.FlagLong(&isNetwork, "allow-network", 0, "allow fetching file content from \"url\" sources")
+	flagSet.FlagLong(&isSortConf, "sort-conffiles", 0, "list conffiles in lexicographic order instead of manifest order")
+	flagSet.FlagLong(&isBuildIds, "extract-build-ids", 0, "record GNU build IDs of packaged ELF files in the Build-Ids field")
+	flagSet.FlagLong(&isStrict, "strict", 0, "treat packaging policy warnings as errors")
+	flagSet.FlagLong(&isIfChanged, "if-changed", 0, "leave the output file untouched if the new package is byte-identical to it")
 	err := flagSet.Getopt(argv, nil)
 	if err != nil {
 		fmt.Fprintf(stderr, "error: %v\n", err)
@@ -89,12 +138,55 @@ func Main(stdout io.Writer, stderr io.Writer, argv []string) int {
 		return 0
 	}
 
+	if isSelfTest {
+		err = runSelfTest()
+		if err != nil {
+			fmt.Fprintf(stderr, "error: self-test failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "self-test passed\n")
+		return 0
+	}
+
+	var outputPerm fs.FileMode = 0o666
+	if outputMode != "" {
+		num, err := strconv.ParseUint(outputMode, 8, 32)
+		if err != nil || num > 0o777 {
+			fmt.Fprintf(stderr, "error: --output-mode: expected an octal mode between 0 and 0777, got %q\n", outputMode)
+			return 1
+		}
+		outputPerm = fs.FileMode(num)
+	}
+
+	err = compress.Validate()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: -c / --compression: %v\n", err)
+		return 1
+	}
+
+	err = ctrlCompress.Validate()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: --control-compression: %v\n", err)
+		return 1
+	}
+
+	hashes, err := parseHashNames(hashNames)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: --hash: %v\n", err)
+		return 1
+	}
+
+	if sumsPath != "" && hashes != nil && !hasHash(hashes, HashSHA256) {
+		fmt.Fprintf(stderr, "error: --files-sha256 requires SHA256 in --hash\n")
+		return 1
+	}
+
 	if manifestPath == "" {
 		fmt.Fprintf(stderr, "error: missing required flag: -m / --manifest\n")
 		return 1
 	}
 
-	if filePath == "" {
+	if len(filePaths) == 0 && !isLint && !isListDirs && !isPrintCtrl {
 		fmt.Fprintf(stderr, "error: missing required flag: -o / --output\n")
 		return 1
 	}
@@ -109,12 +201,35 @@ func Main(stdout io.Writer, stderr io.Writer, argv []string) int {
 		return 1
 	}
 
+	baseDirAbs := rootPathAbs
+	if isArchivePath(rootPathAbs) {
+		baseDirAbs = filepath.Dir(rootPathAbs)
+	}
+
 	if !filepath.IsAbs(manifestPath) {
-		manifestPath = filepath.Join(rootPathAbs, manifestPath)
+		manifestPath = filepath.Join(baseDirAbs, manifestPath)
+	}
+
+	for index, filePath := range filePaths {
+		if !filepath.IsAbs(filePath) {
+			filePaths[index] = filepath.Join(baseDirAbs, filePath)
+		}
+	}
+
+	if stanzaPath != "" && !filepath.IsAbs(stanzaPath) {
+		stanzaPath = filepath.Join(baseDirAbs, stanzaPath)
+	}
+
+	if releasePath != "" && !filepath.IsAbs(releasePath) {
+		releasePath = filepath.Join(baseDirAbs, releasePath)
+	}
+
+	if sumsPath != "" && !filepath.IsAbs(sumsPath) {
+		sumsPath = filepath.Join(baseDirAbs, sumsPath)
 	}
 
-	if !filepath.IsAbs(filePath) {
-		filePath = filepath.Join(rootPathAbs, filePath)
+	if describePath != "" && !filepath.IsAbs(describePath) {
+		describePath = filepath.Join(baseDirAbs, describePath)
 	}
 
 	manifestData, err := os.ReadFile(manifestPath)
@@ -123,56 +238,496 @@ func Main(stdout io.Writer, stderr io.Writer, argv []string) int {
 		return 1
 	}
 
-	var manifest Manifest
-	d := json.NewDecoder(bytes.NewReader(manifestData))
-	d.DisallowUnknownFields()
-	err = d.Decode(&manifest)
+	manifest, err := ManifestFromJSON(manifestData)
 	if err != nil {
 		fmt.Fprintf(stderr, "error: failed to parse manifest file as JSON: %q: %v\n", manifestPath, err)
 		return 1
 	}
 
-	var builder Builder
-	builder.Root = os.DirFS(rootPathAbs)
-	builder.Compression = compress
+	if isListDirs {
+		dirs, err := manifest.MissingDirs()
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
 
-	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+		for _, dir := range dirs {
+			fmt.Fprintf(stdout, "%s\n", dir)
+		}
+		return 0
+	}
+
+	if isLint {
+		err = manifest.Validate()
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+
+		exitCode := 0
+		for _, w := range manifest.Lint() {
+			fmt.Fprintf(stdout, "%v\n", w)
+			if w.Severity >= SeverityError {
+				exitCode = 1
+			}
+		}
+		return exitCode
+	}
+
+	rootFS, rootCloser, err := openRootFS(rootPathAbs)
 	if err != nil {
-		fmt.Fprintf(stderr, "error: failed to create output file: %q: %v\n", filePath, err)
+		fmt.Fprintf(stderr, "error: %v\n", err)
 		return 1
 	}
 
-	needCloseFile := true
+	if rootCloser != nil {
+		defer func() {
+			_ = rootCloser.Close()
+		}()
+	}
+
+	var builder Builder
+	builder.Root = rootFS
+	builder.ControlCompression = ctrlCompress
+	builder.ControlCompressionLevel = ctrlLvl
+	builder.Hashes = hashes
+
+	var envAlgo CompressAlgorithm
+	var envLevel int
+	if str := os.Getenv("MKDEB_COMPRESSION"); str != "" {
+		err = envAlgo.Parse(str)
+		if err == nil {
+			err = envAlgo.Validate()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "error: MKDEB_COMPRESSION: %v\n", err)
+			return 1
+		}
+	}
+	if str := os.Getenv("MKDEB_COMPRESSION_LEVEL"); str != "" {
+		envLevel, err = strconv.Atoi(str)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: MKDEB_COMPRESSION_LEVEL: failed to parse %q as an integer\n", str)
+			return 1
+		}
+	}
+
+	builder.Compression, builder.CompressionLevel = resolveCompressionChoice(stderr, []compressionChoice{
+		{algoSource: "--compression", levelSource: "--compression-level", algo: compress, level: compressLvl, isFlag: true},
+		{algoSource: "compression", levelSource: "compressionLevel", algo: manifest.Compression, level: manifest.CompressionLevel},
+		{algoSource: "MKDEB_COMPRESSION", levelSource: "MKDEB_COMPRESSION_LEVEL", algo: envAlgo, level: envLevel},
+	})
+	builder.Strict = isStrict
+	builder.SortConffiles = isSortConf
+	builder.ExtractBuildIds = isBuildIds
+	builder.AllowNetwork = isNetwork
+	builder.OnWarning = func(w Warning) {
+		if w.Severity >= SeverityWarning {
+			fmt.Fprintf(stderr, "%v\n", w)
+		}
+	}
+
+	type output struct {
+		manifest  *Manifest
+		filePath  string
+		filePaths []string
+	}
+
+	var outputs []output
+	if len(manifest.Arches) == 0 {
+		for _, filePath := range filePaths {
+			if pkg, ok := packageFromFilename(filepath.Base(filePath)); ok && pkg != manifest.Package {
+				err = builder.warn(SeverityWarning, "package", "package %q does not match output filename %q", manifest.Package, filepath.Base(filePath))
+				if err != nil {
+					fmt.Fprintf(stderr, "error: %v\n", err)
+					return 1
+				}
+			}
+		}
+		var filePath string
+		if len(filePaths) != 0 {
+			filePath = filePaths[0]
+		}
+		outputs = append(outputs, output{manifest, filePath, filePaths})
+	} else {
+		for _, arch := range manifest.Arches {
+			archManifest := manifest.ForArch(arch)
+			archPaths := make([]string, len(filePaths))
+			for index, filePath := range filePaths {
+				archPaths[index] = filepath.Join(filePath, archManifest.DefaultFilename())
+			}
+			var archPath string
+			if len(archPaths) != 0 {
+				archPath = archPaths[0]
+			}
+			outputs = append(outputs, output{archManifest, archPath, archPaths})
+		}
+	}
+
+	if isPrintCtrl {
+		for index, out := range outputs {
+			control, err := builder.ControlFile(context.Background(), out.manifest)
+			if err != nil {
+				fmt.Fprintf(stderr, "error: %v\n", err)
+				return 1
+			}
+
+			if index > 0 {
+				fmt.Fprintf(stdout, "\n")
+			}
+			_, _ = stdout.Write(control)
+		}
+		return 0
+	}
+
+	var stanzas bytes.Buffer
+	var releaseEntries []ReleaseEntry
+	for _, out := range outputs {
+		var artifact *Artifact
+		var unchanged []string
+		if isIfChanged {
+			artifact, unchanged, err = writePackageIfChanged(builder, out.manifest, out.filePaths, outputPerm)
+		} else {
+			artifact, err = writePackage(builder, out.manifest, out.filePaths, outputPerm)
+		}
+		if err != nil {
+			if len(manifest.Arches) != 0 {
+				fmt.Fprintf(stderr, "error: %s: %v\n", out.manifest.Arch, err)
+			} else {
+				fmt.Fprintf(stderr, "error: %v\n", err)
+			}
+			return 1
+		}
+
+		for _, filePath := range unchanged {
+			fmt.Fprintf(stdout, "unchanged: %s\n", filePath)
+		}
+
+		if stanzaPath != "" {
+			if stanzas.Len() != 0 {
+				stanzas.WriteString("\n")
+			}
+			stanzas.Write(builder.PackagesStanza(out.manifest, filepath.Base(out.filePath), artifact))
+		}
+
+		if sumsPath != "" {
+			outPath := sumsPath
+			if len(manifest.Arches) != 0 {
+				outPath = filepath.Join(sumsPath, out.manifest.DefaultFilename()+".sha256")
+			}
+			err = os.WriteFile(outPath, out.manifest.Checksums(HashSHA256), 0o666)
+			if err != nil {
+				fmt.Fprintf(stderr, "error: failed to write file checksums: %q: %v\n", outPath, err)
+				return 1
+			}
+		}
+
+		if describePath != "" {
+			outPath := describePath
+			if len(manifest.Arches) != 0 {
+				outPath = filepath.Join(describePath, out.manifest.DefaultFilename()+".json")
+			}
+			desc := builder.Describe(out.manifest, filepath.Base(out.filePath), artifact)
+			data, err := json.MarshalIndent(desc, "", "  ")
+			if err != nil {
+				fmt.Fprintf(stderr, "error: failed to encode package description: %v\n", err)
+				return 1
+			}
+			data = append(data, '\n')
+			err = os.WriteFile(outPath, data, 0o666)
+			if err != nil {
+				fmt.Fprintf(stderr, "error: failed to write package description: %q: %v\n", outPath, err)
+				return 1
+			}
+		}
+
+		releaseEntries = append(releaseEntries, ReleaseEntry{Filename: filepath.Base(out.filePath), Artifact: artifact})
+	}
+
+	if stanzaPath != "" {
+		err = os.WriteFile(stanzaPath, stanzas.Bytes(), 0o666)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: failed to write Packages stanza: %q: %v\n", stanzaPath, err)
+			return 1
+		}
+	}
+
+	if releasePath != "" {
+		err = os.WriteFile(releasePath, ReleaseChecksums(releaseEntries), 0o666)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: failed to write Release checksums: %q: %v\n", releasePath, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func writePackage(builder Builder, manifest *Manifest, filePaths []string, perm fs.FileMode) (*Artifact, error) {
+	temps := make([]*os.File, 0, len(filePaths))
 	defer func() {
-		if needCloseFile {
-			_ = file.Close()
+		for _, temp := range temps {
+			if temp != nil {
+				_ = temp.Close()
+				_ = os.Remove(temp.Name())
+			}
 		}
 	}()
 
-	err = builder.Build(file, &manifest)
+	writers := make([]io.Writer, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		temp, err := createTempBeside(filePath, perm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary output file: %q: %w", filePath, err)
+		}
+		temps = append(temps, temp)
+		writers = append(writers, temp)
+	}
+
+	artifact, err := builder.BuildArtifact(context.Background(), io.MultiWriter(writers...), manifest)
 	if err != nil {
-		fmt.Fprintf(stderr, "error: %v\n", err)
-		return 1
+		return nil, err
+	}
+
+	for index, filePath := range filePaths {
+		err = replaceFile(temps[index], filePath)
+		if err != nil {
+			return nil, err
+		}
+		temps[index] = nil
 	}
 
-	err = file.Sync()
+	return artifact, nil
+}
+
+func writePackageIfChanged(builder Builder, manifest *Manifest, filePaths []string, perm fs.FileMode) (*Artifact, []string, error) {
+	temps := make([]*os.File, 0, len(filePaths))
+	defer func() {
+		for _, temp := range temps {
+			if temp != nil {
+				_ = temp.Close()
+				_ = os.Remove(temp.Name())
+			}
+		}
+	}()
+
+	writers := make([]io.Writer, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		temp, err := createTempBeside(filePath, perm)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create temporary output file: %q: %w", filePath, err)
+		}
+		temps = append(temps, temp)
+		writers = append(writers, temp)
+	}
+
+	artifact, err := builder.BuildArtifact(context.Background(), io.MultiWriter(writers...), manifest)
 	if err != nil {
-		fmt.Fprintf(stderr, "error: failed to sync output file to disk: %q: %v\n", filePath, err)
-		return 1
+		return nil, nil, err
+	}
+
+	var unchanged []string
+	for index, filePath := range filePaths {
+		changed, err := replaceIfChanged(temps[index], filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		temps[index] = nil
+		if !changed {
+			unchanged = append(unchanged, filePath)
+		}
 	}
 
-	needCloseFile = false
-	err = file.Close()
+	return artifact, unchanged, nil
+}
+
+func createTempBeside(filePath string, perm fs.FileMode) (*os.File, error) {
+	dir, base := filepath.Split(filePath)
+	for attempt := 0; ; attempt++ {
+		tempPath := filepath.Join(dir, fmt.Sprintf(".%s.tmp%d.%d", base, os.Getpid(), attempt))
+		file, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) && attempt < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+func replaceIfChanged(temp *os.File, filePath string) (bool, error) {
+	same, err := sameContents(temp, filePath)
 	if err != nil {
-		fmt.Fprintf(stderr, "error: failed to close output file: %q: %v\n", filePath, err)
-		return 1
+		return false, err
+	}
+	if same {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return false, nil
+	}
+
+	err = replaceFile(temp, filePath)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func replaceFile(temp *os.File, filePath string) error {
+	info, err := os.Stat(filePath)
+	if err == nil {
+		err = temp.Chmod(info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("failed to set mode of temporary output file: %q: %w", temp.Name(), err)
+		}
+	}
+
+	err = temp.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync output file to disk: %q: %w", temp.Name(), err)
+	}
+
+	err = temp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close output file: %q: %w", temp.Name(), err)
+	}
+
+	err = os.Rename(temp.Name(), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to replace output file: %q: %w", filePath, err)
+	}
+
+	return syncDir(filepath.Dir(filePath))
+}
+
+func sameContents(temp *os.File, filePath string) (bool, error) {
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read existing output file: %q: %w", filePath, err)
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat existing output file: %q: %w", filePath, err)
+	}
+	tempInfo, err := temp.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat temporary output file: %q: %w", temp.Name(), err)
+	}
+	if !info.Mode().IsRegular() || info.Size() != tempInfo.Size() {
+		return false, nil
 	}
 
-	dirPath := filepath.Dir(filePath)
+	_, err = temp.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, fmt.Errorf("failed to seek temporary output file: %q: %w", temp.Name(), err)
+	}
+
+	var oldBuf, newBuf [32 << 10]byte
+	for {
+		n, err := io.ReadFull(temp, newBuf[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, fmt.Errorf("failed to read temporary output file: %q: %w", temp.Name(), err)
+		}
+		if n == 0 {
+			return true, nil
+		}
+		_, err = io.ReadFull(file, oldBuf[:n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read existing output file: %q: %w", filePath, err)
+		}
+		if !bytes.Equal(oldBuf[:n], newBuf[:n]) {
+			return false, nil
+		}
+	}
+}
+
+type compressionChoice struct {
+	algoSource  string
+	levelSource string
+	algo        CompressAlgorithm
+	level       int
+	isFlag      bool
+}
+
+func resolveCompressionChoice(stderr io.Writer, choices []compressionChoice) (CompressAlgorithm, int) {
+	algo := CompressAuto
+	var algoSource string
+	for _, choice := range choices {
+		if choice.algo != CompressAuto {
+			algo, algoSource = choice.algo, choice.algoSource
+			break
+		}
+	}
+
+	for _, choice := range choices {
+		if choice.level == 0 || (choice.algo != CompressAuto && choice.algo != algo) {
+			continue
+		}
+		if choice.algo == algo || choice.isFlag || algo.ValidateLevel(choice.level) == nil {
+			return algo, choice.level
+		}
+		fmt.Fprintf(stderr, "warning: %s: level %d does not apply to %v compression (set by %s); ignoring it\n", choice.levelSource, choice.level, algo, algoSource)
+	}
+	return algo, 0
+}
+
+func packageFromFilename(name string) (string, bool) {
+	if !strings.HasSuffix(name, ".deb") {
+		return "", false
+	}
+	name = strings.TrimSuffix(name, ".deb")
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		name = name[:i]
+	}
+	return name, name != ""
+}
+
+func parseHashNames(names []string) ([]HashAlgorithm, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	hashes := make([]HashAlgorithm, 0, len(names))
+	for _, name := range names {
+		if strings.EqualFold(name, "none") {
+			if len(names) != 1 {
+				return nil, fmt.Errorf("\"none\" cannot be combined with other hash algorithms")
+			}
+			return hashes, nil
+		}
+		var algo HashAlgorithm
+		err := algo.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		if !hasHash(hashes, algo) {
+			hashes = append(hashes, algo)
+		}
+	}
+	return hashes, nil
+}
+
+func hasHash(hashes []HashAlgorithm, algo HashAlgorithm) bool {
+	for _, item := range hashes {
+		if item == algo {
+			return true
+		}
+	}
+	return false
+}
+
+func syncDir(dirPath string) error {
 	dir, err := os.OpenFile(dirPath, os.O_RDONLY, 0)
 	if err != nil {
-		fmt.Fprintf(stderr, "error: failed to open directory: %q: %v\n", dirPath, err)
-		return 1
+		return fmt.Errorf("failed to open directory: %q: %w", dirPath, err)
 	}
 
 	needCloseDir := true
@@ -184,16 +739,14 @@ func Main(stdout io.Writer, stderr io.Writer, argv []string) int {
 
 	err = dir.Sync()
 	if err != nil {
-		fmt.Fprintf(stderr, "error: failed to sync directory to disk: %q: %v\n", dirPath, err)
-		return 1
+		return fmt.Errorf("failed to sync directory to disk: %q: %w", dirPath, err)
 	}
 
 	needCloseDir = false
 	err = dir.Close()
 	if err != nil {
-		fmt.Fprintf(stderr, "error: failed to close directory: %q: %v\n", dirPath, err)
-		return 1
+		return fmt.Errorf("failed to close directory: %q: %w", dirPath, err)
 	}
 
-	return 0
+	return nil
 }
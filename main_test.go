@@ -0,0 +1,758 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testMainManifest = testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+
+func TestWritePackageIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo_1.0_all.deb")
+
+	write := func() []string {
+		t.Helper()
+		_, unchanged, err := writePackageIfChanged(Builder{}, testManifest(t, testMainManifest), []string{filePath}, 0o600)
+		if err != nil {
+			t.Fatalf("writePackageIfChanged: %v", err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected only the output file in %q, found %d entries", dir, len(entries))
+		}
+		return unchanged
+	}
+
+	if unchanged := write(); len(unchanged) != 0 {
+		t.Errorf("first write: expected a new file, got unchanged %q", unchanged)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("first write: expected mode 0600, got %04o", info.Mode().Perm())
+	}
+
+	past := time.Unix(1000000000, 0)
+	if err := os.Chtimes(filePath, past, past); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if unchanged := write(); len(unchanged) != 1 || unchanged[0] != filePath {
+		t.Errorf("second write: expected %q to be unchanged, got %q", filePath, unchanged)
+	}
+	if info, err := os.Stat(filePath); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("second write: expected the file to be left untouched, got %v, %v", info.ModTime(), err)
+	}
+
+	if err := os.WriteFile(filePath, []byte("stale"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Chmod(filePath, 0o640); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if unchanged := write(); len(unchanged) != 0 {
+		t.Errorf("third write: expected the stale file to be replaced, got unchanged %q", unchanged)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data[:len(arMagic)]) != arMagic {
+		t.Errorf("third write: output is not an ar archive")
+	}
+	if info, err := os.Stat(filePath); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("third write: expected the existing mode 0640 to be kept, got %v, %v", info.Mode().Perm(), err)
+	}
+}
+
+func TestMainSelfTest(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "--self-test"})
+	if rc != 0 {
+		t.Errorf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+	if stdout.String() != "self-test passed\n" {
+		t.Errorf("expected %q on stdout, got %q", "self-test passed\n", stdout.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected nothing on stderr, got %q", stderr.String())
+	}
+}
+
+func TestMainSelfTestFailure(t *testing.T) {
+	saved := runSelfTest
+	t.Cleanup(func() { runSelfTest = saved })
+	runSelfTest = func() error {
+		return selfTest(Builder{
+			Compression: CompressNone,
+			RewriteHeader: func(hdr *tar.Header, file *File) {
+				if path.Base(hdr.Name) == "README" {
+					hdr.Name += ".orig"
+				}
+			},
+		})
+	}
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "--self-test"})
+	if rc != 1 {
+		t.Errorf("expected exit status 1, got %d", rc)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected nothing on stdout, got %q", stdout.String())
+	}
+	if !strings.HasPrefix(stderr.String(), "error: self-test failed: ") {
+		t.Errorf("expected a self-test failure on stderr, got %q", stderr.String())
+	}
+}
+
+func TestResolveCompressionChoice(t *testing.T) {
+	type testRow struct {
+		name        string
+		flag        compressionChoice
+		manifest    compressionChoice
+		env         compressionChoice
+		expectAlgo  CompressAlgorithm
+		expectLevel int
+		expectWarn  string
+	}
+
+	testData := [...]testRow{
+		{"defaults", compressionChoice{}, compressionChoice{}, compressionChoice{}, CompressAuto, 0, ""},
+		{"flag algorithm takes env level", compressionChoice{algo: CompressXZ}, compressionChoice{}, compressionChoice{level: 3}, CompressXZ, 3, ""},
+		{"flag pair wins", compressionChoice{algo: CompressXZ, level: 2}, compressionChoice{algo: CompressZSTD, level: 19}, compressionChoice{level: 3}, CompressXZ, 2, ""},
+		{"flag level applies to manifest algorithm", compressionChoice{level: 9}, compressionChoice{algo: CompressXZ, level: 3}, compressionChoice{}, CompressXZ, 9, ""},
+		{"manifest pair", compressionChoice{}, compressionChoice{algo: CompressXZ, level: 5}, compressionChoice{algo: CompressZSTD, level: 19}, CompressXZ, 5, ""},
+		{"manifest level of another algorithm", compressionChoice{algo: CompressGZIP}, compressionChoice{algo: CompressZSTD, level: 19}, compressionChoice{}, CompressGZIP, 0, ""},
+		{"env level out of range for manifest algorithm", compressionChoice{}, compressionChoice{algo: CompressXZ}, compressionChoice{level: 19}, CompressXZ, 0, "warning: MKDEB_COMPRESSION_LEVEL: level 19 does not apply to xz compression (set by compression); ignoring it\n"},
+		{"env level out of range for flag algorithm", compressionChoice{algo: CompressXZ}, compressionChoice{}, compressionChoice{level: 19}, CompressXZ, 0, "warning: MKDEB_COMPRESSION_LEVEL: level 19 does not apply to xz compression (set by --compression); ignoring it\n"},
+		{"manifest level out of range for env algorithm", compressionChoice{}, compressionChoice{level: 19}, compressionChoice{algo: CompressXZ}, CompressXZ, 0, "warning: compressionLevel: level 19 does not apply to xz compression (set by MKDEB_COMPRESSION); ignoring it\n"},
+		{"env pair for another algorithm", compressionChoice{}, compressionChoice{algo: CompressXZ}, compressionChoice{algo: CompressZSTD, level: 19}, CompressXZ, 0, ""},
+		{"env pair for same algorithm", compressionChoice{}, compressionChoice{algo: CompressXZ}, compressionChoice{algo: CompressXZ, level: 4}, CompressXZ, 4, ""},
+		{"env pair alone", compressionChoice{}, compressionChoice{}, compressionChoice{algo: CompressZSTD, level: 19}, CompressZSTD, 19, ""},
+		{"env level with automatic algorithm", compressionChoice{}, compressionChoice{}, compressionChoice{level: 5}, CompressAuto, 5, ""},
+	}
+
+	for _, row := range testData {
+		flag := row.flag
+		flag.algoSource, flag.levelSource = "--compression", "--compression-level"
+		flag.isFlag = true
+		manifest := row.manifest
+		manifest.algoSource, manifest.levelSource = "compression", "compressionLevel"
+		env := row.env
+		env.algoSource, env.levelSource = "MKDEB_COMPRESSION", "MKDEB_COMPRESSION_LEVEL"
+
+		var stderr bytes.Buffer
+		algo, level := resolveCompressionChoice(&stderr, []compressionChoice{flag, manifest, env})
+		if algo != row.expectAlgo || level != row.expectLevel {
+			t.Errorf("%s: expected %v level %d, got %v level %d", row.name, row.expectAlgo, row.expectLevel, algo, level)
+		}
+		if stderr.String() != row.expectWarn {
+			t.Errorf("%s: expected warning %q, got %q", row.name, row.expectWarn, stderr.String())
+		}
+	}
+}
+
+func TestMainCompressionFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	js := testFooJSON(`"compression": "xz", "files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+	if err := os.WriteFile(manifestPath, []byte(js), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outPath := filepath.Join(dir, "foo.deb")
+
+	t.Setenv("MKDEB_COMPRESSION", "")
+	t.Setenv("MKDEB_COMPRESSION_LEVEL", "19")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "MKDEB_COMPRESSION_LEVEL: level 19 does not apply to xz") {
+		t.Errorf("expected a warning about the ignored level, got %q", stderr.String())
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	testArMemberData(t, testReadAr(t, data), "data.tar.xz")
+}
+
+func TestParseHashNames(t *testing.T) {
+	type testRow struct {
+		input     []string
+		expect    []HashAlgorithm
+		expectErr bool
+	}
+
+	testData := [...]testRow{
+		{nil, nil, false},
+		{[]string{"none"}, []HashAlgorithm{}, false},
+		{[]string{"NONE"}, []HashAlgorithm{}, false},
+		{[]string{"sha256", "md5"}, []HashAlgorithm{HashSHA256, HashMD5}, false},
+		{[]string{"md5", "MD5"}, []HashAlgorithm{HashMD5}, false},
+		{[]string{"none", "md5"}, nil, true},
+		{[]string{"crc32"}, nil, true},
+	}
+
+	for _, row := range testData {
+		actual, err := parseHashNames(row.input)
+		if (err != nil) != row.expectErr {
+			t.Errorf("parseHashNames(%q): unexpected error state: %v", row.input, err)
+			continue
+		}
+		if (actual == nil) != (row.expect == nil) || fmt.Sprint(actual) != fmt.Sprint(row.expect) {
+			t.Errorf("parseHashNames(%q): expected %#v, got %#v", row.input, row.expect, actual)
+		}
+	}
+}
+
+func TestMainHashNone(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	if err := os.WriteFile(manifestPath, []byte(testMainManifest), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outPath := filepath.Join(dir, "foo.deb")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath, "--hash", "none"})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	headers, _ := testMemberTar(t, data, "control.tar")
+	var names []string
+	for _, hdr := range headers {
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, " ") != "control" {
+		t.Errorf("expected only a control member, got %q", names)
+	}
+
+	stderr.Reset()
+	rc = Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath, "--hash", "md5", "--files-sha256", filepath.Join(dir, "sums")})
+	if rc != 1 || !strings.Contains(stderr.String(), "--files-sha256 requires SHA256") {
+		t.Errorf("expected --files-sha256 without SHA256 to fail, got %d, %q", rc, stderr.String())
+	}
+}
+
+func TestMainArches(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	js := `{
+		"package": "foo", "version": "1.0", "arches": ["amd64", "arm64"], "maintainer": "x <x@example.com>", "shortDescription": "foo bar",
+		"files": [
+			{"name": "etc/"},
+			{"name": "etc/common", "text": "common\n"},
+			{"name": "etc/only-amd64", "text": "amd64\n", "arches": ["amd64"]},
+			{"name": "etc/only-arm64", "text": "arm64\n", "arches": ["arm64"]}
+		]
+	}`
+	if err := os.WriteFile(manifestPath, []byte(js), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0o777); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outDir})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		data, err := os.ReadFile(filepath.Join(outDir, "foo_1.0_"+arch+".deb"))
+		if err != nil {
+			t.Errorf("%s: ReadFile: %v", arch, err)
+			continue
+		}
+
+		_, control := testMemberTar(t, data, "control.tar")
+		if !strings.Contains(string(control["control"]), "\nArchitecture: "+arch+"\n") {
+			t.Errorf("%s: control: expected Architecture %s, got %q", arch, arch, control["control"])
+		}
+
+		_, contents := testMemberTar(t, data, "data.tar")
+		var names []string
+		for name := range contents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		expect := "etc/ etc/common etc/only-" + arch
+		if strings.Join(names, " ") != expect {
+			t.Errorf("%s: data.tar: expected %q, got %q", arch, expect, names)
+		}
+	}
+}
+
+func TestMainPackagesStanza(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	if err := os.WriteFile(manifestPath, []byte(testMainManifest), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outPath := filepath.Join(dir, "foo_1.0_all.deb")
+	stanzaPath := filepath.Join(dir, "Packages")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath, "--packages-stanza", stanzaPath})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	stanza, err := os.ReadFile(stanzaPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	sha256sum := sha256.Sum256(data)
+	md5sum := md5.Sum(data)
+	for _, line := range []string{
+		"Package: foo\n",
+		"Filename: foo_1.0_all.deb\n",
+		"Size: " + strconv.Itoa(len(data)) + "\n",
+		"MD5sum: " + hex.EncodeToString(md5sum[:]) + "\n",
+		"SHA256: " + hex.EncodeToString(sha256sum[:]) + "\n",
+	} {
+		if !strings.Contains(string(stanza), line) {
+			t.Errorf("expected the stanza to contain %q, got %q", line, stanza)
+		}
+	}
+	if !strings.HasSuffix(string(stanza), "Description: foo bar\n") {
+		t.Errorf("expected the stanza to end with the Description field, got %q", stanza)
+	}
+}
+
+func TestMainReleaseOut(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	js := `{
+		"package": "foo", "version": "1.0", "arches": ["amd64", "arm64"], "maintainer": "x <x@example.com>", "shortDescription": "foo bar",
+		"files": [{"name": "etc/"}, {"name": "etc/only-amd64", "text": "amd64\n", "arches": ["amd64"]}]
+	}`
+	if err := os.WriteFile(manifestPath, []byte(js), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0o777); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	releasePath := filepath.Join(dir, "Release")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outDir, "--release-out", releasePath})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	release, err := os.ReadFile(releasePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	expect := "SHA256:\n"
+	for _, arch := range []string{"amd64", "arm64"} {
+		filename := "foo_1.0_" + arch + ".deb"
+		data, err := os.ReadFile(filepath.Join(outDir, filename))
+		if err != nil {
+			t.Fatalf("%s: ReadFile: %v", arch, err)
+		}
+		sum := sha256.Sum256(data)
+		expect += fmt.Sprintf(" %s %16d %s\n", hex.EncodeToString(sum[:]), len(data), filename)
+	}
+	if string(release) != expect {
+		t.Errorf("expected %q, got %q", expect, release)
+	}
+}
+
+func TestMainFilesSHA256(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	js := testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}, {"name": "etc/b", "text": "world\n"}]`)
+	if err := os.WriteFile(manifestPath, []byte(js), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outPath := filepath.Join(dir, "foo.deb")
+	sumsPath := filepath.Join(dir, "foo.sha256")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath, "--files-sha256", sumsPath})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	sidecar, err := os.ReadFile(sumsPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	_, control := testMemberTar(t, data, "control.tar")
+	member := control[HashSHA256.FileName()]
+	if len(member) == 0 || !bytes.Equal(sidecar, member) {
+		t.Errorf("expected the sidecar to match the %s member %q, got %q", HashSHA256.FileName(), member, sidecar)
+	}
+}
+
+func TestMainPackageFilenameMismatch(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	if err := os.WriteFile(manifestPath, []byte(testMainManifest), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	type testRow struct {
+		output       string
+		strict       bool
+		expectRC     int
+		expectStderr string
+	}
+
+	const mismatch = `package "foo" does not match output filename "bar_1.0_all.deb"`
+
+	testData := [...]testRow{
+		{"foo_1.0_all.deb", false, 0, ""},
+		{"foo.deb", false, 0, ""},
+		{"bar_1.0_all.deb", false, 0, mismatch},
+		{"bar_1.0_all.deb", true, 1, mismatch},
+	}
+
+	for _, row := range testData {
+		args := []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", filepath.Join(dir, row.output)}
+		if row.strict {
+			args = append(args, "--strict")
+		}
+		var stdout, stderr bytes.Buffer
+		rc := Main(&stdout, &stderr, args)
+		if rc != row.expectRC {
+			t.Errorf("%s strict=%v: expected exit status %d, got %d; stderr: %q", row.output, row.strict, row.expectRC, rc, stderr.String())
+		}
+		switch {
+		case row.expectStderr == "" && strings.Contains(stderr.String(), "does not match output filename"):
+			t.Errorf("%s strict=%v: unexpected warning: %q", row.output, row.strict, stderr.String())
+		case row.expectStderr != "" && !strings.Contains(stderr.String(), row.expectStderr):
+			t.Errorf("%s strict=%v: expected stderr containing %q, got %q", row.output, row.strict, row.expectStderr, stderr.String())
+		}
+	}
+
+	for _, name := range []string{"foo_1.0_all.deb", "foo_1.0_arm64.deb", "foo.deb"} {
+		if pkg, ok := packageFromFilename(name); !ok || pkg != "foo" {
+			t.Errorf("packageFromFilename(%q): expected \"foo\", got %q, %v", name, pkg, ok)
+		}
+	}
+	if _, ok := packageFromFilename("foo.tar"); ok {
+		t.Errorf("packageFromFilename(\"foo.tar\"): expected no package")
+	}
+}
+
+func TestMainPrintControl(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	if err := os.WriteFile(manifestPath, []byte(testMainManifest), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outPath := filepath.Join(dir, "foo_1.0_all.deb")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "--print-control"})
+	if rc != 0 {
+		t.Fatalf("--print-control: expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+	rc = Main(io.Discard, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	pkg, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if got := stdout.String(); got != string(control["control"]) {
+		t.Errorf("expected the packaged control file %q, got %q", control["control"], got)
+	}
+}
+
+func TestMainManifestCompression(t *testing.T) {
+	t.Setenv("MKDEB_COMPRESSION", "")
+	t.Setenv("MKDEB_COMPRESSION_LEVEL", "")
+
+	type testRow struct {
+		fields       string
+		args         []string
+		expectMember string
+		expectErr    string
+	}
+
+	testData := [...]testRow{
+		{`"compression": "xz"`, nil, "data.tar.xz", ""},
+		{`"compression": "xz", "compressionLevel": 3`, nil, "data.tar.xz", ""},
+		{`"compression": "xz", "compressionLevel": 3`, []string{"--compression", "gzip"}, "data.tar.gz", ""},
+		{`"compression": "xz", "compressionLevel": 10`, nil, "", "compressionLevel: level 10 is out of range for xz; expected 1..9"},
+		{`"compression": "lzma"`, nil, "", `failed to parse "lzma"`},
+		{`"compression": "bzip2"`, nil, "", "compression: compression algorithm bzip2 is not implemented"},
+	}
+
+	for _, row := range testData {
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "foo.json")
+		js := testFooJSON(row.fields + `, "files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+		if err := os.WriteFile(manifestPath, []byte(js), 0o666); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		outPath := filepath.Join(dir, "foo.deb")
+
+		var stdout, stderr bytes.Buffer
+		rc := Main(&stdout, &stderr, append([]string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath}, row.args...))
+		if row.expectErr != "" {
+			if rc == 0 || !strings.Contains(stderr.String(), row.expectErr) {
+				t.Errorf("%s %q: expected failure containing %q, got exit status %d; stderr: %q", row.fields, row.args, row.expectErr, rc, stderr.String())
+			}
+			continue
+		}
+		if rc != 0 {
+			t.Errorf("%s %q: expected exit status 0, got %d; stderr: %q", row.fields, row.args, rc, stderr.String())
+			continue
+		}
+
+		data, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		testArMemberData(t, testReadAr(t, data), row.expectMember)
+	}
+}
+
+func TestMainOutputMode(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	if err := os.WriteFile(manifestPath, []byte(testMainManifest), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	probePath := filepath.Join(dir, "probe")
+	if err := os.WriteFile(probePath, nil, 0o777); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	probe, err := os.Stat(probePath)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	umask := 0o777 &^ probe.Mode().Perm()
+
+	type testRow struct {
+		mode       string
+		expectPerm os.FileMode
+		expectErr  bool
+	}
+
+	testData := [...]testRow{
+		{"", 0o666, false},
+		{"0644", 0o644, false},
+		{"640", 0o640, false},
+		{"0600", 0o600, false},
+		{"0999", 0, true},
+		{"01000", 0, true},
+		{"rw-r--r--", 0, true},
+	}
+
+	for index, row := range testData {
+		outPath := filepath.Join(dir, "out"+strconv.Itoa(index)+".deb")
+		args := []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath}
+		if row.mode != "" {
+			args = append(args, "--output-mode", row.mode)
+		}
+
+		var stdout, stderr bytes.Buffer
+		rc := Main(&stdout, &stderr, args)
+		if row.expectErr {
+			if rc == 0 || !strings.Contains(stderr.String(), "--output-mode: expected an octal mode") {
+				t.Errorf("%q: expected an --output-mode error, got exit status %d; stderr: %q", row.mode, rc, stderr.String())
+			}
+			continue
+		}
+		if rc != 0 {
+			t.Errorf("%q: expected exit status 0, got %d; stderr: %q", row.mode, rc, stderr.String())
+			continue
+		}
+
+		fi, err := os.Stat(outPath)
+		if err != nil {
+			t.Fatalf("%q: Stat: %v", row.mode, err)
+		}
+		if expect := row.expectPerm &^ umask; fi.Mode().Perm() != expect {
+			t.Errorf("%q: expected mode %04o, got %04o", row.mode, expect, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestWritePackageMultipleOutputs(t *testing.T) {
+	var first, second bytes.Buffer
+	if err := (Builder{}).Build(io.MultiWriter(&first, &second), testManifest(t, testMainManifest)); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if first.Len() == 0 || !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("expected two byte-identical non-empty outputs, got %d and %d bytes", first.Len(), second.Len())
+	}
+
+	dir := t.TempDir()
+	filePaths := []string{filepath.Join(dir, "a.deb"), filepath.Join(dir, "b.deb")}
+	if _, err := writePackage(Builder{}, testManifest(t, testMainManifest), filePaths, 0o600); err != nil {
+		t.Fatalf("writePackage: %v", err)
+	}
+	for _, filePath := range filePaths {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if !bytes.Equal(data, first.Bytes()) {
+			t.Errorf("%s: expected the same bytes as Build", filepath.Base(filePath))
+		}
+	}
+
+	if err := os.WriteFile(filePaths[0], []byte("old"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	broken := testManifest(t, testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/missing"}]`))
+	if _, err := writePackage(Builder{Root: fstest.MapFS{}}, broken, filePaths, 0o600); err == nil {
+		t.Fatalf("writePackage: expected an error for a missing source file")
+	}
+	if data, err := os.ReadFile(filePaths[0]); err != nil || string(data) != "old" {
+		t.Errorf("%s: expected the existing file to be left untouched after a failed build, got %q, %v", filepath.Base(filePaths[0]), data, err)
+	}
+	if data, err := os.ReadFile(filePaths[1]); err != nil || !bytes.Equal(data, first.Bytes()) {
+		t.Errorf("%s: expected the existing file to be left untouched after a failed build, got %v", filepath.Base(filePaths[1]), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != len(filePaths) {
+		t.Errorf("expected no temporary files to be left behind, found %d entries", len(entries))
+	}
+}
+
+func TestMainDescribeOut(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "foo.json")
+	js := testFooJSON(`"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n", "user": "www-data#33"}, {"name": "etc/b", "type": "symlink", "link": "a"}]`)
+	if err := os.WriteFile(manifestPath, []byte(js), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	outPath := filepath.Join(dir, "foo.deb")
+	describePath := filepath.Join(dir, "foo.deb.json")
+
+	var stdout, stderr bytes.Buffer
+	rc := Main(&stdout, &stderr, []string{"mkdeb", "-R", dir, "-m", manifestPath, "-o", outPath, "--describe-out", describePath})
+	if rc != 0 {
+		t.Fatalf("expected exit status 0, got %d; stderr: %q", rc, stderr.String())
+	}
+
+	pkg, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	raw, err := os.ReadFile(describePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	type testFile struct {
+		Name   string            `json:"name"`
+		Type   string            `json:"type"`
+		Size   int64             `json:"size"`
+		Mode   string            `json:"mode"`
+		User   json.RawMessage   `json:"user"`
+		Link   string            `json:"link"`
+		Hashes map[string]string `json:"hashes"`
+	}
+	var desc struct {
+		Filename string            `json:"filename"`
+		Size     int64             `json:"size"`
+		Hashes   map[string]string `json:"hashes"`
+		Members  []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"members"`
+		Control []ControlField `json:"control"`
+		Files   []testFile     `json:"files"`
+	}
+	if err := json.Unmarshal(raw, &desc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	sum := sha256.Sum256(pkg)
+	if desc.Filename != "foo.deb" || desc.Size != int64(len(pkg)) || desc.Hashes["SHA256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected foo.deb with %d bytes and SHA256 %x, got %q with %d bytes and %v", len(pkg), sum, desc.Filename, desc.Size, desc.Hashes)
+	}
+
+	var memberNames []string
+	for _, member := range desc.Members {
+		memberNames = append(memberNames, member.Name)
+	}
+	if got := strings.Join(memberNames, " "); got != "debian-binary control.tar.gz data.tar.gz" {
+		t.Errorf("members: expected %q, got %q", "debian-binary control.tar.gz data.tar.gz", got)
+	}
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if got := string(formatControlFields(desc.Control)); got != string(control["control"]) {
+		t.Errorf("control: expected %q, got %q", control["control"], got)
+	}
+
+	md5Sum := md5.Sum([]byte("hello\n"))
+	expect := []testFile{
+		{Name: "etc/", Type: "DIR", Mode: "0755", User: json.RawMessage("0")},
+		{Name: "etc/a", Type: "REG", Size: 6, Mode: "0644", User: json.RawMessage(`"www-data#33"`), Hashes: map[string]string{"MD5": hex.EncodeToString(md5Sum[:])}},
+		{Name: "etc/b", Type: "LNK", Mode: "0777", User: json.RawMessage("0"), Link: "a"},
+	}
+	if len(desc.Files) != len(expect) {
+		t.Fatalf("files: expected %d entries, got %d", len(expect), len(desc.Files))
+	}
+	for index, want := range expect {
+		got := desc.Files[index]
+		if got.Name != want.Name || got.Type != want.Type || got.Size != want.Size || got.Mode != want.Mode || string(got.User) != string(want.User) || got.Link != want.Link {
+			t.Errorf("files[%d]: expected %+v, got %+v", index, want, got)
+		}
+		if want.Hashes != nil && got.Hashes["MD5"] != want.Hashes["MD5"] {
+			t.Errorf("files[%d]: expected MD5 %s, got %v", index, want.Hashes["MD5"], got.Hashes)
+		}
+		if want.Hashes == nil && got.Hashes != nil {
+			t.Errorf("files[%d]: expected no hashes, got %v", index, got.Hashes)
+		}
+	}
+}
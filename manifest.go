@@ -2,296 +2,638 @@ package mkdeb
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"fmt"
 	"io/fs"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Manifest struct {
-	Package          string   `json:"package"`
-	Version          string   `json:"version"`
-	Arch             string   `json:"arch"`
-	Section          string   `json:"section"`
-	Priority         string   `json:"priority"`
-	Essential        string   `json:"essential"`
-	Depends          string   `json:"depends"`
-	PreDepends       string   `json:"preDepends"`
-	Recommends       string   `json:"recommends"`
-	Suggests         string   `json:"suggests"`
-	Enhances         string   `json:"enhances"`
-	Breaks           string   `json:"breaks"`
-	Conflicts        string   `json:"conflicts"`
-	Maintainer       string   `json:"maintainer"`
-	HomePage         string   `json:"homePage"`
-	BuiltUsing       string   `json:"builtUsing"`
-	ShortDescription string   `json:"shortDescription"`
-	LongDescription  []string `json:"longDescription"`
-	ImplicitDirs     []string `json:"implicitDirs"`
-	Files            []File   `json:"files"`
-	PreInstall       []string `json:"preInstall"`
-	PostInstall      []string `json:"postInstall"`
-	PreRemove        []string `json:"preRemove"`
-	PostRemove       []string `json:"postRemove"`
-
-	isResolved    bool  `json:"-"`
-	installedSize int64 `json:"-"`
+	Package          string    `json:"package"`
+	Source           string    `json:"source"`
+	SourceVersion    string    `json:"sourceVersion"`
+	Version          string    `json:"version"`
+	Arch             string    `json:"arch"`
+	Arches           []string  `json:"arches"`
+	Section          string    `json:"section"`
+	Priority         string    `json:"priority"`
+	Essential        string    `json:"essential"`
+	Depends          Relations `json:"depends"`
+	PreDepends       Relations `json:"preDepends"`
+	Recommends       Relations `json:"recommends"`
+	Suggests         Relations `json:"suggests"`
+	Enhances         Relations `json:"enhances"`
+	Breaks           Relations `json:"breaks"`
+	Conflicts        Relations `json:"conflicts"`
+	Maintainer       string    `json:"maintainer"`
+	HomePage         string    `json:"homePage"`
+	BuiltUsing       string    `json:"builtUsing"`
+	ShortDescription string    `json:"shortDescription"`
+	LongDescription  []string  `json:"longDescription"`
+	ImplicitDirs     []string  `json:"implicitDirs"`
+	Files            []File    `json:"files"`
+	ExtraConffiles   []string  `json:"extraConffiles"`
+	PreInstall       []string  `json:"preInstall"`
+	PostInstall      []string  `json:"postInstall"`
+	PreRemove        []string  `json:"preRemove"`
+	PostRemove       []string  `json:"postRemove"`
+	ScriptUmask      string    `json:"scriptUmask"`
+
+	TranslatedDescriptions map[string][]string `json:"translatedDescriptions"`
+	DefaultMTime           time.Time           `json:"defaultMTime"`
+	Compression            CompressAlgorithm   `json:"compression"`
+	CompressionLevel       int                 `json:"compressionLevel"`
+	BuildIds               []string            `json:"buildIds"`
+
+	isResolved    bool      `json:"-"`
+	installedSize int64     `json:"-"`
+	warnings      []Warning `json:"-"`
 
 	isHashed bool `json:"-"`
 }
 
 func (manifest Manifest) Validate() error {
+	if len(manifest.Arches) == 0 {
+		if err := manifest.expandRelations(); err != nil {
+			return err
+		}
+	}
 	if err := manifest.validatePre(); err != nil {
 		return err
 	}
 
+	files := make([]File, len(manifest.Files))
 	for index, file := range manifest.Files {
-		if err := file.Validate(); err != nil {
-			return fmt.Errorf("files[%d]: %w", index, err)
+		if err := file.validateImpl(); err != nil {
+			return prefixValidationError(fmt.Sprintf("files[%d]", index), err)
 		}
+		files[index] = file
 	}
+	manifest.Files = files
 
+	for _, arch := range manifest.Arches {
+		archManifest := manifest.ForArch(arch)
+		if err := archManifest.expandRelations(); err != nil {
+			return fmt.Errorf("%s: %w", arch, err)
+		}
+		if err := archManifest.validatePost(); err != nil {
+			return fmt.Errorf("%s: %w", arch, err)
+		}
+	}
+	if len(manifest.Arches) != 0 {
+		return nil
+	}
 	return manifest.validatePost()
 }
 
+func (manifest Manifest) ForArch(arch string) *Manifest {
+	out := manifest
+	out.Arch = arch
+	out.Arches = nil
+	out.Files = make([]File, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if file.isForArch(arch) {
+			out.Files = append(out.Files, file)
+		}
+	}
+	out.isResolved = false
+	out.isHashed = false
+	return &out
+}
+
+func (manifest Manifest) DefaultFilename() string {
+	if manifest.Package == "" || manifest.Version == "" || manifest.Arch == "" {
+		panic(fmt.Errorf("must set package, version, and arch first"))
+	}
+
+	version := strings.ReplaceAll(manifest.Version, ":", "%3a")
+	return manifest.Package + "_" + version + "_" + manifest.Arch + ".deb"
+}
+
 func (manifest *Manifest) Resolve(fileSystem fs.FS) error {
-	if err := manifest.validatePre(); err != nil {
+	return manifest.resolve(context.Background(), fileSystem, nil)
+}
+
+func (manifest *Manifest) resolve(ctx context.Context, fileSystem fs.FS, fetch func(ctx context.Context, file *File) error) error {
+	if err := manifest.resolvePre(); err != nil {
 		return err
 	}
 
 	var installedSize int64
 	for index := range manifest.Files {
 		file := &manifest.Files[index]
+		if fetch != nil && file.URL != nil && file.fetched == nil {
+			if err := fetch(ctx, file); err != nil {
+				return fmt.Errorf("files[%d]: url: %w", index, err)
+			}
+		}
 		if err := file.Resolve(fileSystem); err != nil {
-			return fmt.Errorf("files[%d]: %w", index, err)
+			return prefixValidationError(fmt.Sprintf("files[%d]", index), err)
 		}
 		installedSize += padSigned(file.size, 12)
 	}
 
+	return manifest.resolvePost(installedSize)
+}
+
+func (manifest *Manifest) resolvePrebuilt(installedSize int64) error {
+	if err := manifest.resolvePre(); err != nil {
+		return err
+	}
+
+	for index := range manifest.Files {
+		if err := manifest.Files[index].validateImpl(); err != nil {
+			return prefixValidationError(fmt.Sprintf("files[%d]", index), err)
+		}
+	}
+
+	return manifest.resolvePost(installedSize)
+}
+
+func (manifest *Manifest) resolvePre() error {
+	if err := manifest.expandRelations(); err != nil {
+		return err
+	}
+	if err := manifest.validatePre(); err != nil {
+		return err
+	}
+	if len(manifest.Arches) != 0 {
+		return validationErrorf("arches", ValidationInvalid, "must select a single architecture with ForArch before resolving")
+	}
+
+	files := make([]File, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if file.isForArch(manifest.Arch) {
+			files = append(files, file)
+		}
+	}
+	manifest.Files = files
+	return nil
+}
+
+func (manifest *Manifest) resolvePost(installedSize int64) error {
 	if err := manifest.validatePost(); err != nil {
 		return err
 	}
 
 	manifest.installedSize = installedSize
+	manifest.warnings = manifest.Lint()
 	manifest.isResolved = true
 	return nil
 }
 
 func (manifest *Manifest) validatePre() error {
+	if err := manifest.validateControlValues(); err != nil {
+		return err
+	}
+
 	if manifest.Package == "" {
-		return fmt.Errorf("package: missing required field")
+		return validationErrorf("package", ValidationMissing, "missing required field")
 	}
-	if !isValidPackage(manifest.Package) {
-		return fmt.Errorf("package: invalid Debian package name %q", manifest.Package)
+	if err := checkPackageName(manifest.Package); err != nil {
+		return validationErrorf("package", ValidationInvalid, "invalid Debian package name %q: %w", manifest.Package, err)
 	}
 
 	if manifest.Version == "" {
-		return fmt.Errorf("version: missing required field")
+		return validationErrorf("version", ValidationMissing, "missing required field")
 	}
 	if !isValidVersion(manifest.Version) {
-		return fmt.Errorf("version: invalid Debian package version %q", manifest.Version)
+		return validationErrorf("version", ValidationInvalid, "invalid Debian package version %q", manifest.Version)
 	}
 
-	if manifest.Arch == "" {
-		return fmt.Errorf("arch: missing required field")
+	if manifest.Source != "" {
+		if err := checkPackageName(manifest.Source); err != nil {
+			return validationErrorf("source", ValidationInvalid, "invalid Debian source package name %q: %w", manifest.Source, err)
+		}
 	}
-	if !isValidArch(manifest.Arch) {
-		return fmt.Errorf("version: invalid Debian package architecture %q", manifest.Arch)
+	if manifest.SourceVersion != "" {
+		if manifest.Source == "" {
+			return validationErrorf("sourceVersion", ValidationConflict, "requires field \"source\"")
+		}
+		if !isValidVersion(manifest.SourceVersion) {
+			return validationErrorf("sourceVersion", ValidationInvalid, "invalid Debian package version %q", manifest.SourceVersion)
+		}
+	}
+
+	if len(manifest.Arches) != 0 {
+		if manifest.Arch != "" {
+			return validationErrorf("arch", ValidationConflict, "conflict with field \"arches\"")
+		}
+		for index, arch := range manifest.Arches {
+			if !isValidArch(arch) {
+				return validationErrorf(fmt.Sprintf("arches[%d]", index), ValidationInvalid, "invalid Debian package architecture %q", arch)
+			}
+			if arch == "source" {
+				return validationErrorf(fmt.Sprintf("arches[%d]", index), ValidationInvalid, "architecture \"source\" is only valid for source packages")
+			}
+		}
+	} else {
+		if manifest.Arch == "" {
+			return validationErrorf("arch", ValidationMissing, "missing required field")
+		}
+		if !isValidArch(manifest.Arch) {
+			return validationErrorf("arch", ValidationInvalid, "invalid Debian package architecture %q", manifest.Arch)
+		}
+		if manifest.Arch == "source" {
+			return validationErrorf("arch", ValidationInvalid, "architecture \"source\" is only valid for source packages")
+		}
 	}
 
 	if manifest.Section != "" && !isValidSection(manifest.Section) {
-		return fmt.Errorf("version: invalid Debian package section %q", manifest.Section)
+		return validationErrorf("section", ValidationInvalid, "invalid Debian package section %q", manifest.Section)
 	}
 
 	if manifest.Priority != "" && !isValidPriority(manifest.Priority) {
-		return fmt.Errorf("version: invalid Debian package priority %q", manifest.Priority)
+		return validationErrorf("priority", ValidationInvalid, "invalid Debian package priority %q", manifest.Priority)
 	}
 
 	if manifest.Essential != "" && !isValidDepends(manifest.Essential) {
-		return fmt.Errorf("essential: invalid Debian package dependency spec %q", manifest.Essential)
+		return validationErrorf("essential", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Essential)
 	}
-	if manifest.Depends != "" && !isValidDepends(manifest.Depends) {
-		return fmt.Errorf("depends: invalid Debian package dependency spec %q", manifest.Depends)
+	if manifest.Depends != "" && !isValidDepends(string(manifest.Depends)) {
+		return validationErrorf("depends", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Depends)
 	}
-	if manifest.PreDepends != "" && !isValidDepends(manifest.PreDepends) {
-		return fmt.Errorf("preDepends: invalid Debian package dependency spec %q", manifest.PreDepends)
+	if manifest.PreDepends != "" && !isValidDepends(string(manifest.PreDepends)) {
+		return validationErrorf("preDepends", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.PreDepends)
 	}
-	if manifest.Recommends != "" && !isValidDepends(manifest.Recommends) {
-		return fmt.Errorf("recommends: invalid Debian package dependency spec %q", manifest.Recommends)
+	if manifest.Recommends != "" && !isValidDepends(string(manifest.Recommends)) {
+		return validationErrorf("recommends", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Recommends)
 	}
-	if manifest.Suggests != "" && !isValidDepends(manifest.Suggests) {
-		return fmt.Errorf("suggests: invalid Debian package dependency spec %q", manifest.Suggests)
+	if manifest.Suggests != "" && !isValidDepends(string(manifest.Suggests)) {
+		return validationErrorf("suggests", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Suggests)
 	}
-	if manifest.Enhances != "" && !isValidDepends(manifest.Enhances) {
-		return fmt.Errorf("enhances: invalid Debian package dependency spec %q", manifest.Enhances)
+	if manifest.Enhances != "" && !isValidDepends(string(manifest.Enhances)) {
+		return validationErrorf("enhances", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Enhances)
 	}
-	if manifest.Breaks != "" && !isValidDepends(manifest.Breaks) {
-		return fmt.Errorf("breaks: invalid Debian package dependency spec %q", manifest.Breaks)
+	if manifest.Breaks != "" && !isValidDepends(string(manifest.Breaks)) {
+		return validationErrorf("breaks", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Breaks)
 	}
-	if manifest.Conflicts != "" && !isValidDepends(manifest.Conflicts) {
-		return fmt.Errorf("conflicts: invalid Debian package dependency spec %q", manifest.Conflicts)
+	if manifest.Conflicts != "" && !isValidDepends(string(manifest.Conflicts)) {
+		return validationErrorf("conflicts", ValidationInvalid, "invalid Debian package dependency spec %q", manifest.Conflicts)
 	}
 
 	if manifest.Maintainer == "" {
-		return fmt.Errorf("maintainer: missing required field")
+		return validationErrorf("maintainer", ValidationMissing, "missing required field")
 	}
 	if !isValidMaintainer(manifest.Maintainer) {
-		return fmt.Errorf("maintainer: invalid Maintainer line %q", manifest.Maintainer)
+		return validationErrorf("maintainer", ValidationInvalid, "invalid Maintainer line %q", manifest.Maintainer)
 	}
 
 	if manifest.HomePage != "" && !isValidURL(manifest.HomePage) {
-		return fmt.Errorf("homePage: invalid URL %q", manifest.HomePage)
+		return validationErrorf("homePage", ValidationInvalid, "invalid URL %q", manifest.HomePage)
 	}
 
 	if manifest.BuiltUsing != "" && !isValidBuiltUsing(manifest.BuiltUsing) {
-		return fmt.Errorf("builtUsing: invalid Built-Using line %q", manifest.BuiltUsing)
+		return validationErrorf("builtUsing", ValidationInvalid, "invalid Built-Using line %q", manifest.BuiltUsing)
+	}
+
+	for index, id := range manifest.BuildIds {
+		if !isValidBuildID(id) {
+			return validationErrorf(fmt.Sprintf("buildIds[%d]", index), ValidationInvalid, "invalid build ID %q; expected lowercase hex", id)
+		}
 	}
 
 	if manifest.ShortDescription == "" {
-		return fmt.Errorf("shortDescription: missing required field")
+		return validationErrorf("shortDescription", ValidationMissing, "missing required field")
 	}
 	if !isValidDescriptionLine(manifest.ShortDescription) {
-		return fmt.Errorf("shortDescription: invalid Description line %q", manifest.ShortDescription)
+		return validationErrorf("shortDescription", ValidationInvalid, "invalid Description line %q", manifest.ShortDescription)
 	}
 
 	for index, line := range manifest.LongDescription {
 		if !isValidDescriptionLine(line) {
-			return fmt.Errorf("longDescription[%d]: invalid Description continuation line %q", index, line)
+			return validationErrorf(fmt.Sprintf("longDescription[%d]", index), ValidationInvalid, "invalid Description continuation line %q", line)
+		}
+	}
+
+	for _, lang := range manifest.translationLanguages() {
+		lines := manifest.TranslatedDescriptions[lang]
+		if !isValidLanguage(lang) {
+			return validationErrorf("translatedDescriptions", ValidationInvalid, "invalid language code %q", lang)
+		}
+		if len(lines) == 0 || lines[0] == "" {
+			return validationErrorf(fmt.Sprintf("translatedDescriptions[%q]", lang), ValidationMissing, "missing synopsis line")
+		}
+		if !isValidDescriptionLine(lines[0]) {
+			return validationErrorf(fmt.Sprintf("translatedDescriptions[%q][0]", lang), ValidationInvalid, "invalid Description line %q", lines[0])
+		}
+		for index, line := range lines[1:] {
+			if !isValidDescriptionLine(line) {
+				return validationErrorf(fmt.Sprintf("translatedDescriptions[%q][%d]", lang, index+1), ValidationInvalid, "invalid Description continuation line %q", line)
+			}
+		}
+	}
+
+	if err := manifest.Compression.Validate(); err != nil {
+		return validationErrorf("compression", ValidationInvalid, "%w", err)
+	}
+	if manifest.CompressionLevel != 0 {
+		if manifest.Compression == CompressAuto {
+			return validationErrorf("compressionLevel", ValidationConflict, "requires field \"compression\"")
+		}
+		if err := manifest.Compression.ValidateLevel(manifest.CompressionLevel); err != nil {
+			return validationErrorf("compressionLevel", ValidationInvalid, "%w", err)
+		}
+	}
+
+	if manifest.ScriptUmask != "" {
+		if _, err := parseUmask(manifest.ScriptUmask); err != nil {
+			return validationErrorf("scriptUmask", ValidationInvalid, "%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (manifest *Manifest) validateControlValues() error {
+	type controlValue struct {
+		field     string
+		value     string
+		asciiOnly bool
+	}
+
+	values := []controlValue{
+		{"package", manifest.Package, true},
+		{"source", manifest.Source, true},
+		{"sourceVersion", manifest.SourceVersion, true},
+		{"version", manifest.Version, true},
+		{"arch", manifest.Arch, true},
+		{"section", manifest.Section, false},
+		{"priority", manifest.Priority, false},
+		{"essential", manifest.Essential, false},
+		{"depends", string(manifest.Depends), false},
+		{"preDepends", string(manifest.PreDepends), false},
+		{"recommends", string(manifest.Recommends), false},
+		{"suggests", string(manifest.Suggests), false},
+		{"enhances", string(manifest.Enhances), false},
+		{"breaks", string(manifest.Breaks), false},
+		{"conflicts", string(manifest.Conflicts), false},
+		{"maintainer", manifest.Maintainer, false},
+		{"homePage", manifest.HomePage, false},
+		{"builtUsing", manifest.BuiltUsing, false},
+		{"shortDescription", manifest.ShortDescription, false},
+	}
+	for index, arch := range manifest.Arches {
+		values = append(values, controlValue{fmt.Sprintf("arches[%d]", index), arch, true})
+	}
+	for index, line := range manifest.LongDescription {
+		values = append(values, controlValue{fmt.Sprintf("longDescription[%d]", index), line, false})
+	}
+
+	for _, lang := range manifest.translationLanguages() {
+		for index, line := range manifest.TranslatedDescriptions[lang] {
+			values = append(values, controlValue{fmt.Sprintf("translatedDescriptions[%q][%d]", lang, index), line, false})
 		}
 	}
 
+	for _, v := range values {
+		if err := validateControlValue(v.value, v.asciiOnly); err != nil {
+			return validationErrorf(v.field, ValidationInvalid, "%w", err)
+		}
+	}
 	return nil
 }
 
 func (manifest *Manifest) validatePost() error {
 	knownDirectories := make(map[string]struct{}, 64)
 	knownDirectories["."] = struct{}{}
+	implicitDirs := make(map[string]int, len(manifest.ImplicitDirs))
 	for index, dir := range manifest.ImplicitDirs {
+		if clean, ok := canonicalUnixPath(dir); !ok {
+			return validationErrorf(fmt.Sprintf("implicitDirs[%d]", index), ValidationInvalid, "non-canonical Unix path %q; did you mean %q?", dir, clean)
+		}
 		if !isValidUnixPath(dir) {
-			return fmt.Errorf("implicitDirs[%d]: invalid Unix path %q", index, dir)
+			return validationErrorf(fmt.Sprintf("implicitDirs[%d]", index), ValidationInvalid, "invalid Unix path %q", dir)
 		}
 		dir = strings.TrimRight(dir, "/")
+		if oldIndex, exists := implicitDirs[dir]; exists {
+			return validationErrorf(fmt.Sprintf("implicitDirs[%d]", index), ValidationDuplicate, "duplicate directory %q has the same name as implicitDirs[%d]", dir, oldIndex)
+		}
+		implicitDirs[dir] = index
 		knownDirectories[dir] = struct{}{}
 	}
 
 	seen := make(map[string]int, 64)
+	nonDirs := make(map[string]int, 64)
 	for index, file := range manifest.Files {
-		name := file.Name
+		name := file.archiveName()
 		if oldIndex, exists := seen[name]; exists {
-			return fmt.Errorf("files[%d]: duplicate file %q has the same name as files[%d]", index, name, oldIndex)
+			return validationErrorf(fmt.Sprintf("files[%d]", index), ValidationDuplicate, "duplicate file %q has the same name as files[%d]", name, oldIndex)
 		}
 		seen[name] = index
 
 		name = strings.TrimRight(name, "/")
+		if oldIndex, exists := implicitDirs[name]; exists {
+			if file.Type == TypeDIR {
+				return validationErrorf(fmt.Sprintf("files[%d]", index), ValidationDuplicate, "directory %q is also listed in implicitDirs[%d]", name, oldIndex)
+			}
+			return validationErrorf(fmt.Sprintf("files[%d]", index), ValidationDuplicate, "file %q has the same path as directory implicitDirs[%d]", name, oldIndex)
+		}
+		if file.Type == TypeDIR {
+			if oldIndex, exists := nonDirs[name]; exists {
+				return validationErrorf(fmt.Sprintf("files[%d]", index), ValidationDuplicate, "directory %q has the same path as files[%d]", name, oldIndex)
+			}
+		} else {
+			if oldIndex, exists := seen[name+"/"]; exists {
+				return validationErrorf(fmt.Sprintf("files[%d]", index), ValidationDuplicate, "file %q has the same path as directory files[%d]", name, oldIndex)
+			}
+			nonDirs[name] = index
+		}
 		dir := path.Dir(name)
 		if _, exists := knownDirectories[dir]; !exists {
-			return fmt.Errorf("files[%d]: directory %q might not exist yet", index, dir)
+			return validationErrorf(fmt.Sprintf("files[%d]", index), ValidationInvalid, "directory %q might not exist yet", dir)
 		}
 		if file.Type == TypeDIR {
 			knownDirectories[name] = struct{}{}
 		}
 	}
 
+	for index, name := range manifest.ExtraConffiles {
+		name = strings.TrimPrefix(name, "/")
+		if clean, ok := canonicalUnixPath(name); !ok {
+			return validationErrorf(fmt.Sprintf("extraConffiles[%d]", index), ValidationInvalid, "non-canonical Unix path %q; did you mean %q?", name, clean)
+		}
+		if !isValidUnixPath(name) {
+			return validationErrorf(fmt.Sprintf("extraConffiles[%d]", index), ValidationInvalid, "invalid Unix path %q", name)
+		}
+		fileIndex, exists := seen[name]
+		if !exists {
+			return validationErrorf(fmt.Sprintf("extraConffiles[%d]", index), ValidationInvalid, "conffile %q is not shipped in the package", name)
+		}
+		if manifest.Files[fileIndex].Type != TypeREG {
+			return validationErrorf(fmt.Sprintf("extraConffiles[%d]", index), ValidationInvalid, "conffile %q is not a regular file", name)
+		}
+	}
+
 	return nil
 }
 
-func (manifest Manifest) ControlFile() []byte {
-	if !manifest.isResolved {
-		panic(fmt.Errorf("must call Resolve first"))
+func (manifest Manifest) SourcePaths() []string {
+	seen := make(map[string]struct{}, len(manifest.Files))
+	list := make([]string, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		name, ok := file.sourcePath()
+		if !ok {
+			continue
+		}
+		if _, found := seen[name]; !found {
+			seen[name] = struct{}{}
+			list = append(list, name)
+		}
 	}
+	return list
+}
 
-	var buf bytes.Buffer
-	buf.WriteString("Package: ")
-	buf.WriteString(manifest.Package)
-	buf.WriteString("\n")
-	buf.WriteString("Version: ")
-	buf.WriteString(manifest.Version)
-	buf.WriteString("\n")
-	if manifest.Section != "" {
-		buf.WriteString("Section: ")
-		buf.WriteString(manifest.Section)
-		buf.WriteString("\n")
+func (manifest Manifest) MissingDirs() ([]string, error) {
+	known := make(map[string]struct{}, len(manifest.ImplicitDirs)+len(manifest.Files))
+	known["."] = struct{}{}
+	for _, dir := range manifest.ImplicitDirs {
+		known[strings.TrimRight(dir, "/")] = struct{}{}
 	}
-	if manifest.Priority != "" {
-		buf.WriteString("Priority: ")
-		buf.WriteString(manifest.Priority)
-		buf.WriteString("\n")
-	}
-	if manifest.Arch != "" {
-		buf.WriteString("Architecture: ")
-		buf.WriteString(manifest.Arch)
-		buf.WriteString("\n")
-	}
-	if manifest.Essential != "" {
-		buf.WriteString("Essential: ")
-		buf.WriteString(manifest.Essential)
-		buf.WriteString("\n")
+
+	files := make([]File, len(manifest.Files))
+	for index, file := range manifest.Files {
+		if err := file.validateImpl(); err != nil {
+			return nil, prefixValidationError(fmt.Sprintf("files[%d]", index), err)
+		}
+		if file.Type == TypeDIR {
+			known[strings.TrimRight(file.Name, "/")] = struct{}{}
+		}
+		files[index] = file
+	}
+
+	missing := make(map[string]struct{}, 16)
+	for _, file := range files {
+		dir := path.Dir(strings.TrimRight(file.Name, "/"))
+		for {
+			if _, found := known[dir]; found {
+				break
+			}
+			missing[dir+"/"] = struct{}{}
+			known[dir] = struct{}{}
+			dir = path.Dir(dir)
+		}
 	}
-	if manifest.Depends != "" {
-		buf.WriteString("Depends: ")
-		buf.WriteString(manifest.Depends)
-		buf.WriteString("\n")
+
+	list := make([]string, 0, len(missing))
+	for dir := range missing {
+		list = append(list, dir)
 	}
-	if manifest.PreDepends != "" {
-		buf.WriteString("Pre-Depends: ")
-		buf.WriteString(manifest.PreDepends)
-		buf.WriteString("\n")
+	sort.Strings(list)
+	return list, nil
+}
+
+type ControlField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (manifest Manifest) ControlFields() []ControlField {
+	if !manifest.isResolved {
+		panic(fmt.Errorf("must call Resolve first"))
 	}
-	if manifest.Recommends != "" {
-		buf.WriteString("Recommends: ")
-		buf.WriteString(manifest.Recommends)
-		buf.WriteString("\n")
+
+	fields := make([]ControlField, 0, 24)
+	add := func(name string, value string) {
+		fields = append(fields, ControlField{Name: name, Value: value})
 	}
-	if manifest.Suggests != "" {
-		buf.WriteString("Suggests: ")
-		buf.WriteString(manifest.Suggests)
-		buf.WriteString("\n")
+	addOptional := func(name string, value string) {
+		if value != "" {
+			add(name, value)
+		}
 	}
-	if manifest.Enhances != "" {
-		buf.WriteString("Enhances: ")
-		buf.WriteString(manifest.Enhances)
-		buf.WriteString("\n")
+
+	add("Package", manifest.Package)
+	addOptional("Source", manifest.sourceField())
+	add("Version", manifest.Version)
+	addOptional("Section", manifest.Section)
+	addOptional("Priority", manifest.Priority)
+	addOptional("Architecture", manifest.Arch)
+	addOptional("Essential", manifest.Essential)
+	addOptional("Depends", string(manifest.Depends))
+	addOptional("Pre-Depends", string(manifest.PreDepends))
+	addOptional("Recommends", string(manifest.Recommends))
+	addOptional("Suggests", string(manifest.Suggests))
+	addOptional("Enhances", string(manifest.Enhances))
+	addOptional("Breaks", string(manifest.Breaks))
+	addOptional("Conflicts", string(manifest.Conflicts))
+	add("Installed-Size", strconv.FormatInt(manifest.installedSize, 10))
+	add("Maintainer", manifest.Maintainer)
+	addOptional("Homepage", manifest.HomePage)
+	addOptional("Built-Using", manifest.BuiltUsing)
+	addOptional("Build-Ids", strings.Join(manifest.BuildIds, " "))
+	add("Description", formatDescription(manifest.ShortDescription, manifest.LongDescription))
+	for _, lang := range manifest.translationLanguages() {
+		lines := manifest.TranslatedDescriptions[lang]
+		add("Description-"+lang, formatDescription(lines[0], lines[1:]))
+	}
+	return fields
+}
+
+func (manifest Manifest) sourceField() string {
+	if manifest.SourceVersion == "" || manifest.SourceVersion == manifest.Version {
+		return manifest.Source
 	}
-	if manifest.Breaks != "" {
-		buf.WriteString("Breaks: ")
-		buf.WriteString(manifest.Breaks)
-		buf.WriteString("\n")
+	return manifest.Source + " (" + manifest.SourceVersion + ")"
+}
+
+func (manifest Manifest) translationLanguages() []string {
+	langs := make([]string, 0, len(manifest.TranslatedDescriptions))
+	for lang := range manifest.TranslatedDescriptions {
+		langs = append(langs, lang)
 	}
-	if manifest.Conflicts != "" {
-		buf.WriteString("Conflicts: ")
-		buf.WriteString(manifest.Conflicts)
-		buf.WriteString("\n")
+	sort.Strings(langs)
+	return langs
+}
+
+func (manifest Manifest) ControlFile() []byte {
+	return formatControlFields(manifest.ControlFields())
+}
+
+func insertControlFields(fields []ControlField, extra []ControlField) []ControlField {
+	if len(extra) == 0 {
+		return fields
 	}
-	buf.WriteString("Installed-Size: ")
-	buf.WriteString(strconv.FormatInt(manifest.installedSize, 10))
-	buf.WriteString("\n")
-	buf.WriteString("Maintainer: ")
-	buf.WriteString(manifest.Maintainer)
-	buf.WriteString("\n")
-	if manifest.HomePage != "" {
-		buf.WriteString("Homepage: ")
-		buf.WriteString(manifest.HomePage)
-		buf.WriteString("\n")
+
+	out := make([]ControlField, 0, len(fields)+len(extra))
+	for _, field := range fields {
+		if field.Name == "Description" {
+			out = append(out, extra...)
+			extra = nil
+		}
+		out = append(out, field)
 	}
-	if manifest.BuiltUsing != "" {
-		buf.WriteString("Built-Using: ")
-		buf.WriteString(manifest.BuiltUsing)
+	return append(out, extra...)
+}
+
+func formatControlFields(fields []ControlField) []byte {
+	var buf bytes.Buffer
+	for _, field := range fields {
+		buf.WriteString(field.Name)
+		buf.WriteString(": ")
+		buf.WriteString(field.Value)
 		buf.WriteString("\n")
 	}
-	buf.WriteString("Description: ")
-	buf.WriteString(manifest.ShortDescription)
-	buf.WriteString("\n")
-	for _, line := range manifest.LongDescription {
+	return buf.Bytes()
+}
+
+func formatDescription(short string, long []string) string {
+	var buf strings.Builder
+	buf.WriteString(short)
+	for _, line := range long {
 		if line == "" {
-			buf.WriteString(" .\n")
+			buf.WriteString("\n .")
 		} else {
-			buf.WriteString(" ")
+			buf.WriteString("\n ")
 			buf.WriteString(line)
-			buf.WriteString("\n")
 		}
 	}
-	return buf.Bytes()
+	return buf.String()
 }
 
 func (manifest Manifest) ConfFiles() []byte {
@@ -299,100 +641,79 @@ func (manifest Manifest) ConfFiles() []byte {
 		panic(fmt.Errorf("must call Resolve first"))
 	}
 
+	extra := manifest.extraConffileSet()
+
 	var buf bytes.Buffer
 	for _, file := range manifest.Files {
-		if file.IsConf {
-			buf.WriteString(file.Name)
+		_, isExtra := extra[file.archiveName()]
+		if file.IsConf || isExtra {
+			buf.WriteString(file.packagedName())
 			buf.WriteString("\n")
 		}
 	}
 	return buf.Bytes()
 }
 
-func (manifest Manifest) PreInstallScript() []byte {
-	if !manifest.isResolved {
-		panic(fmt.Errorf("must call Resolve first"))
-	}
-
-	lines := manifest.PreInstall
-	if len(lines) <= 0 {
-		return nil
+func (manifest Manifest) Checksums(algo HashAlgorithm) []byte {
+	if !manifest.isHashed {
+		panic(fmt.Errorf("must call BuildDataTarball first"))
 	}
 
 	var buf bytes.Buffer
-	buf.Grow(4096)
-	buf.WriteString("#!/bin/bash\n")
-	buf.WriteString("set -euo pipefail\n")
-	buf.WriteString("umask 022\n")
-	buf.WriteString("cd /\n")
-	for _, line := range lines {
-		buf.WriteString(line)
-		buf.WriteString("\n")
+	for _, file := range manifest.Files {
+		if sum, found := file.hashes[algo]; file.isHashed && found {
+			buf.WriteString(hex.EncodeToString(sum))
+			buf.WriteString("  ")
+			buf.WriteString(file.packagedName())
+			buf.WriteString("\n")
+		}
 	}
 	return buf.Bytes()
 }
 
-func (manifest Manifest) PostInstallScript() []byte {
-	if !manifest.isResolved {
-		panic(fmt.Errorf("must call Resolve first"))
+func (manifest Manifest) extraConffileSet() map[string]struct{} {
+	extra := make(map[string]struct{}, len(manifest.ExtraConffiles))
+	for _, name := range manifest.ExtraConffiles {
+		extra[strings.TrimPrefix(name, "/")] = struct{}{}
 	}
+	return extra
+}
 
-	lines := manifest.PostInstall
-	if len(lines) <= 0 {
-		return nil
-	}
+func (manifest Manifest) PreInstallScript() []byte {
+	return manifest.script(manifest.PreInstall)
+}
 
-	var buf bytes.Buffer
-	buf.Grow(4096)
-	buf.WriteString("#!/bin/bash\n")
-	buf.WriteString("set -euo pipefail\n")
-	buf.WriteString("umask 022\n")
-	buf.WriteString("cd /\n")
-	for _, line := range lines {
-		buf.WriteString(line)
-		buf.WriteString("\n")
-	}
-	return buf.Bytes()
+func (manifest Manifest) PostInstallScript() []byte {
+	return manifest.script(manifest.PostInstall)
 }
 
 func (manifest Manifest) PreRemoveScript() []byte {
-	if !manifest.isResolved {
-		panic(fmt.Errorf("must call Resolve first"))
-	}
-
-	lines := manifest.PreRemove
-	if len(lines) <= 0 {
-		return nil
-	}
-
-	var buf bytes.Buffer
-	buf.Grow(4096)
-	buf.WriteString("#!/bin/bash\n")
-	buf.WriteString("set -euo pipefail\n")
-	buf.WriteString("umask 022\n")
-	buf.WriteString("cd /\n")
-	for _, line := range lines {
-		buf.WriteString(line)
-		buf.WriteString("\n")
-	}
-	return buf.Bytes()
+	return manifest.script(manifest.PreRemove)
 }
 
 func (manifest Manifest) PostRemoveScript() []byte {
+	return manifest.script(manifest.PostRemove)
+}
+
+func (manifest Manifest) script(lines []string) []byte {
 	if !manifest.isResolved {
 		panic(fmt.Errorf("must call Resolve first"))
 	}
 
-	lines := manifest.PostRemove
 	if len(lines) <= 0 {
 		return nil
 	}
 
+	var umask uint64 = 0o022
+	if manifest.ScriptUmask != "" {
+		umask, _ = parseUmask(manifest.ScriptUmask)
+	}
+
 	var buf bytes.Buffer
 	buf.Grow(4096)
 	buf.WriteString("#!/bin/bash\n")
 	buf.WriteString("set -euo pipefail\n")
-	buf.WriteString("umask 022\n")
+	fmt.Fprintf(&buf, "umask %03o\n", umask)
 	buf.WriteString("cd /\n")
 	for _, line := range lines {
 		buf.WriteString(line)
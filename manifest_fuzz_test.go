@@ -0,0 +1,59 @@
+package mkdeb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func FuzzManifestDecode(f *testing.F) {
+	valid := `{
+		"package": "foo", "version": "1.0-1", "arch": "amd64", "maintainer": "x <x@example.com>",
+		"shortDescription": "foo bar", "depends": ["libc6 (>= 2.31)", {"name": "bar", "version": ">= 1"}],
+		"files": [
+			{"name": "etc/", "perm": "0755"},
+			{"name": "etc/foo.conf", "text": "a=b\n", "isConf": true},
+			{"name": "usr/"},
+			{"name": "usr/bin/"},
+			{"name": "usr/bin/foo", "bytes": "AAEC", "perm": 493, "user": 0, "group": "root"},
+			{"name": "usr/bin/bar", "type": "symlink", "link": "foo"}
+		]
+	}`
+	seeds := []string{
+		valid,
+		`{"package": "foo", "version": "1.0", "arch": "all", "maintainer": "x", "shortDescription": "foo bar"}`,
+		`{}`,
+		valid[:len(valid)/2],
+		`{"package": "foo", "files": [{"name": "a", "text": "x"`,
+		`{"package": 42}`,
+		`{"files": {"name": "a"}}`,
+		`{"files": [{"perm": true}]}`,
+		`{"files": [{"bytes": "not base64!"}]}`,
+		`{"depends": 7}`,
+		`{"files": [{"user": [1, 2]}]}`,
+		`{"compression": "lzma"}`,
+		`{"unknownField": 1}`,
+		`{"package": "foo"} {"package": "bar"}`,
+		`null`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		manifest, err := ManifestFromJSON(data)
+		if err != nil {
+			if manifest != nil {
+				t.Errorf("got both a manifest and error %v", err)
+			}
+			return
+		}
+		if manifest == nil {
+			t.Fatalf("got neither a manifest nor an error")
+		}
+		if !json.Valid(data) {
+			t.Errorf("accepted invalid JSON %q", data)
+		}
+		_ = manifest.Validate()
+	})
+}
@@ -0,0 +1,393 @@
+package mkdeb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestManifestValidate(t *testing.T) {
+	type testRow struct {
+		fields    string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{``, ""},
+		{`"implicitDirs": ["etc/", "usr/"], "files": [{"name": "etc/a", "text": "a\n"}]`, ""},
+		{`"implicitDirs": ["etc/", "usr/", "etc"]`, `implicitDirs[2]: duplicate directory "etc" has the same name as implicitDirs[0]`},
+		{`"implicitDirs": ["etc/"], "files": [{"name": "etc/"}]`, `files[0]: directory "etc" is also listed in implicitDirs[0]`},
+		{`"implicitDirs": ["etc/"], "files": [{"name": "etc", "text": "a\n"}]`, `files[0]: file "etc" has the same path as directory implicitDirs[0]`},
+		{`"implicitDirs": ["etc/", "etc/foo/"], "files": [{"name": "etc/foo", "text": "a\n"}]`, `files[0]: file "etc/foo" has the same path as directory implicitDirs[1]`},
+		{`"implicitDirs": ["etc/", "etc/foo/"], "files": [{"name": "etc/foo", "type": "symlink", "link": "bar"}]`, `files[0]: file "etc/foo" has the same path as directory implicitDirs[1]`},
+		{`"implicitDirs": ["etc/"], "files": [{"name": "etc/foo/"}, {"name": "etc/foo", "text": "a\n"}]`, `files[1]: file "etc/foo" has the same path as directory files[0]`},
+		{`"implicitDirs": ["etc/"], "files": [{"name": "etc/foo", "text": "a\n"}, {"name": "etc/foo/"}]`, `files[1]: directory "etc/foo" has the same path as files[0]`},
+	}
+
+	for _, row := range testData {
+		err := testFooManifest(t, row.fields).Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", row.fields, err)
+		case row.expectErr != "" && (err == nil || !strings.Contains(err.Error(), row.expectErr)):
+			t.Errorf("%s: expected error containing %q, got %v", row.fields, row.expectErr, err)
+		}
+	}
+}
+
+func TestControlFields(t *testing.T) {
+	manifest := testFooManifest(t, `"section": "misc", "depends": "libc6", "homePage": "https://example.com/",
+		"longDescription": ["Foo does things.", "", "More."],
+		"files": [{"name": "etc/"}, {"name": "etc/a", "text": "hello\n"}]`)
+	if err := manifest.Resolve(nil); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	expect := []ControlField{
+		{"Package", "foo"},
+		{"Version", "1.0"},
+		{"Section", "misc"},
+		{"Architecture", "all"},
+		{"Depends", "libc6"},
+		{"Installed-Size", "4096"},
+		{"Maintainer", "x <x@example.com>"},
+		{"Homepage", "https://example.com/"},
+		{"Description", "foo bar\n Foo does things.\n .\n More."},
+	}
+	actual := manifest.ControlFields()
+	if len(actual) != len(expect) {
+		t.Fatalf("expected %d fields %v, got %d fields %v", len(expect), expect, len(actual), actual)
+	}
+	for index := range actual {
+		if actual[index] != expect[index] {
+			t.Errorf("field %d: expected %v, got %v", index, expect[index], actual[index])
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(manifest.ControlFile()), "\n"), "\n") {
+		if strings.HasPrefix(line, " ") {
+			lines[len(lines)-1] += "\n" + line
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != len(actual) {
+		t.Fatalf("ControlFile: expected %d fields, got %d: %q", len(actual), len(lines), lines)
+	}
+	for index, field := range actual {
+		if want := field.Name + ": " + field.Value; lines[index] != want {
+			t.Errorf("ControlFile: field %d: expected %q, got %q", index, want, lines[index])
+		}
+	}
+}
+
+func TestScriptUmask(t *testing.T) {
+	type testRow struct {
+		fields    string
+		expect    string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`"postInstall": ["true"]`, "umask 022\n", ""},
+		{`"postInstall": ["true"], "scriptUmask": "077"`, "umask 077\n", ""},
+		{`"postInstall": ["true"], "scriptUmask": "0027"`, "umask 027\n", ""},
+		{`"postInstall": ["true"], "scriptUmask": "099"`, "", "scriptUmask: failed to parse"},
+		{`"postInstall": ["true"], "scriptUmask": "1777"`, "", "scriptUmask: umask \"1777\" is out of range"},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, row.fields)
+		err := manifest.Resolve(nil)
+		if row.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), row.expectErr) {
+				t.Errorf("%s: expected error containing %q, got %v", row.fields, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", row.fields, err)
+			continue
+		}
+
+		expect := "#!/bin/bash\nset -euo pipefail\n" + row.expect + "cd /\ntrue\n"
+		if got := string(manifest.PostInstallScript()); got != expect {
+			t.Errorf("%s: expected %q, got %q", row.fields, expect, got)
+		}
+	}
+}
+
+func TestManifestValidateControlValues(t *testing.T) {
+	type testRow struct {
+		name      string
+		edit      func(manifest *Manifest)
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{"clean", func(manifest *Manifest) {}, ""},
+		{"UTF-8 maintainer", func(manifest *Manifest) { manifest.Maintainer = "Zoë <zoe@example.com>" }, ""},
+		{"newline in maintainer", func(manifest *Manifest) { manifest.Maintainer = "x <x@example.com>\nEssential: yes" }, "maintainer: value must not contain a newline"},
+		{"carriage return in maintainer", func(manifest *Manifest) { manifest.Maintainer = "x <x@example.com>\r" }, "maintainer: value must not contain a carriage return"},
+		{"non-ASCII package", func(manifest *Manifest) { manifest.Package = "föo" }, "package: value must be ASCII"},
+		{"non-ASCII version", func(manifest *Manifest) { manifest.Version = "1.0é" }, "version: value must be ASCII"},
+		{"newline in long description", func(manifest *Manifest) { manifest.LongDescription = []string{"a\nb"} }, "longDescription[0]: value must not contain a newline"},
+		{"invalid UTF-8", func(manifest *Manifest) { manifest.ShortDescription = "foo \xff" }, "shortDescription: value is not valid UTF-8"},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, "")
+		row.edit(manifest)
+		err := manifest.Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", row.name, err)
+		case row.expectErr != "" && (err == nil || !strings.HasPrefix(err.Error(), row.expectErr)):
+			t.Errorf("%s: expected error starting with %q, got %v", row.name, row.expectErr, err)
+		}
+	}
+}
+
+func TestManifestValidateArch(t *testing.T) {
+	type testRow struct {
+		name       string
+		edit       func(manifest *Manifest)
+		expectErr  string
+		expectWarn bool
+	}
+
+	testData := [...]testRow{
+		{"amd64", func(manifest *Manifest) { manifest.Arch = "amd64" }, "", false},
+		{"all", func(manifest *Manifest) { manifest.Arch = "all" }, "", false},
+		{"any", func(manifest *Manifest) { manifest.Arch = "any" }, "", true},
+		{"source", func(manifest *Manifest) { manifest.Arch = "source" }, `arch: architecture "source" is only valid for source packages`, false},
+		{"arches with source", func(manifest *Manifest) { manifest.Arch, manifest.Arches = "", []string{"amd64", "source"} }, `arches[1]: architecture "source" is only valid for source packages`, false},
+		{"arches with any", func(manifest *Manifest) { manifest.Arch, manifest.Arches = "", []string{"amd64", "any"} }, "", true},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, "")
+		row.edit(manifest)
+		err := manifest.Validate()
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", row.name, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("%s: expected error %q, got %v", row.name, row.expectErr, err)
+		}
+
+		warned := false
+		for _, w := range manifest.Lint() {
+			if strings.HasPrefix(w.Field, "arch") && w.Severity == SeverityWarning {
+				warned = true
+			}
+		}
+		if warned != row.expectWarn {
+			t.Errorf("%s: expected arch warning %v, got %v", row.name, row.expectWarn, warned)
+		}
+	}
+}
+
+func TestDefaultFilename(t *testing.T) {
+	type testRow struct {
+		version string
+		arch    string
+		expect  string
+	}
+
+	testData := [...]testRow{
+		{"1.0", "all", "foo_1.0_all.deb"},
+		{"1.0-1", "amd64", "foo_1.0-1_amd64.deb"},
+		{"2:1.0-1", "amd64", "foo_2%3a1.0-1_amd64.deb"},
+		{"1:2.3~rc1+dfsg-4", "arm64", "foo_1%3a2.3~rc1+dfsg-4_arm64.deb"},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, "")
+		manifest.Version, manifest.Arch = row.version, row.arch
+		if err := manifest.Validate(); err != nil {
+			t.Errorf("%s: Validate: %v", row.version, err)
+			continue
+		}
+		if got := manifest.DefaultFilename(); got != row.expect {
+			t.Errorf("%s: expected %q, got %q", row.version, row.expect, got)
+		}
+	}
+}
+
+func TestTranslatedDescriptions(t *testing.T) {
+	manifest := testFooManifest(t, `"longDescription": ["Foo does things."],
+		"translatedDescriptions": {"fr": ["foo et bar", "Foo fait des choses.", "", "Ça marche."]}`)
+	if err := manifest.Resolve(nil); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	expect := "Description: foo bar\n Foo does things.\n" +
+		"Description-fr: foo et bar\n Foo fait des choses.\n .\n Ça marche.\n"
+	if control := string(manifest.ControlFile()); !strings.HasSuffix(control, expect) {
+		t.Errorf("expected control file ending in %q, got %q", expect, control)
+	}
+
+	manifest = testFooManifest(t, `"translatedDescriptions": {"fr": []}`)
+	if err := manifest.Validate(); err == nil || err.Error() != `translatedDescriptions["fr"]: missing synopsis line` {
+		t.Errorf("expected a missing synopsis error, got %v", err)
+	}
+	manifest = testFooManifest(t, `"translatedDescriptions": {"FR!": ["foo"]}`)
+	if err := manifest.Validate(); err == nil || err.Error() != `translatedDescriptions: invalid language code "FR!"` {
+		t.Errorf("expected an invalid language error, got %v", err)
+	}
+}
+
+func TestMissingDirs(t *testing.T) {
+	manifest := testFooManifest(t, `"implicitDirs": ["usr/"], "files": [
+		{"name": "usr/share/foo/a/b/c", "text": "c\n"},
+		{"name": "usr/share/foo/a/d", "text": "d\n"},
+		{"name": "etc/"},
+		{"name": "etc/foo/bar.conf", "text": "bar\n"},
+		{"name": "etc/baz.conf", "text": "baz\n"}
+	]`)
+
+	dirs, err := manifest.MissingDirs()
+	if err != nil {
+		t.Fatalf("MissingDirs: %v", err)
+	}
+	expect := "etc/foo/ usr/share/ usr/share/foo/ usr/share/foo/a/ usr/share/foo/a/b/"
+	if got := strings.Join(dirs, " "); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	if dirs, err := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}]`).MissingDirs(); err != nil || len(dirs) != 0 {
+		t.Errorf("expected no missing directories, got %q, %v", dirs, err)
+	}
+}
+
+func TestSourcePaths(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/defaulted.conf"},
+		{"name": "etc/explicit.conf", "path": "conf/explicit.conf"},
+		{"name": "etc/inline", "text": "inline\n"},
+		{"name": "etc/lines", "lines": ["a"]},
+		{"name": "etc/link", "type": "symlink", "link": "defaulted.conf"},
+		{"name": "etc/again.conf", "path": "conf/explicit.conf"},
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/foo", "path": "build/foo"}
+	]`)
+	if err := manifest.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	expect := "etc/defaulted.conf conf/explicit.conf build/foo"
+	if got := strings.Join(manifest.SourcePaths(), " "); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestSourceField(t *testing.T) {
+	type testRow struct {
+		fields    string
+		expect    string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{``, "", ""},
+		{`"source": "foo-src"`, "foo-src", ""},
+		{`"source": "foo-src", "sourceVersion": "1.0"`, "foo-src", ""},
+		{`"source": "foo-src", "sourceVersion": "1.0-2"`, "foo-src (1.0-2)", ""},
+		{`"source": "foo-src", "sourceVersion": "1:0.9"`, "foo-src (1:0.9)", ""},
+		{`"sourceVersion": "1.0-2"`, "", `sourceVersion: requires field "source"`},
+		{`"source": "foo-src", "sourceVersion": "not a version"`, "", `sourceVersion: invalid Debian package version "not a version"`},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, row.fields)
+		err := manifest.Resolve(nil)
+		if row.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), row.expectErr) {
+				t.Errorf("%s: expected error containing %q, got %v", row.fields, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", row.fields, err)
+			continue
+		}
+
+		control := string(manifest.ControlFile())
+		line := "\nSource: " + row.expect + "\n"
+		switch {
+		case row.expect == "" && strings.Contains(control, "\nSource: "):
+			t.Errorf("%s: expected no Source field, got %q", row.fields, control)
+		case row.expect != "" && !strings.Contains(control, line):
+			t.Errorf("%s: expected %q, got %q", row.fields, line, control)
+		}
+	}
+}
+
+func TestValidationErrorAs(t *testing.T) {
+	type testRow struct {
+		name        string
+		edit        func(manifest *Manifest)
+		expectField string
+		expectKind  ValidationErrorKind
+	}
+
+	testData := [...]testRow{
+		{"missing package", func(manifest *Manifest) { manifest.Package = "" }, "package", ValidationMissing},
+		{"newline in maintainer", func(manifest *Manifest) { manifest.Maintainer = "x <x@example.com>\nEssential: yes" }, "maintainer", ValidationInvalid},
+		{"symlink without link", func(manifest *Manifest) {
+			manifest.Files = []File{{Name: "etc/"}, {Name: "etc/a", Type: TypeLNK}}
+		}, "files[1].link", ValidationMissing},
+		{"sparse symlink", func(manifest *Manifest) {
+			link := "b"
+			manifest.Files = []File{{Name: "etc/"}, {Name: "etc/a", Type: TypeLNK, Link: &link, Sparse: true}}
+		}, "files[1].sparse", ValidationConflict},
+	}
+
+	for _, row := range testData {
+		manifest := testFooManifest(t, "")
+		row.edit(manifest)
+		err := manifest.Validate()
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: expected a *ValidationError, got %v", row.name, err)
+			continue
+		}
+		if verr.Field != row.expectField || verr.Kind != row.expectKind {
+			t.Errorf("%s: expected field %q kind %v, got field %q kind %v", row.name, row.expectField, row.expectKind, verr.Field, verr.Kind)
+		}
+		if !strings.HasPrefix(err.Error(), row.expectField+": ") {
+			t.Errorf("%s: expected the message to start with %q, got %q", row.name, row.expectField+": ", err.Error())
+		}
+	}
+}
+
+func TestInstalledSizePerArch(t *testing.T) {
+	js := `{"package": "foo", "version": "1.0", "arches": ["amd64", "arm64"], "maintainer": "x <x@example.com>", "shortDescription": "foo bar",
+		"files": [
+			{"name": "usr/"},
+			{"name": "usr/lib/"},
+			{"name": "usr/lib/foo/"},
+			{"name": "usr/lib/foo/common", "text": "common\n"},
+			{"name": "usr/lib/foo/amd64.so", "text": "` + strings.Repeat("a", 10000) + `", "arches": ["amd64"]},
+			{"name": "usr/lib/foo/arm64.so", "text": "` + strings.Repeat("b", 20000) + `", "arches": ["arm64"]}
+		]}`
+	manifest := testManifest(t, js)
+
+	expect := map[string]string{
+		"amd64": "Installed-Size: 16384\n",
+		"arm64": "Installed-Size: 24576\n",
+	}
+	for _, arch := range manifest.Arches {
+		archManifest := manifest.ForArch(arch)
+		if err := archManifest.Resolve(nil); err != nil {
+			t.Fatalf("%s: Resolve: %v", arch, err)
+		}
+		if control := string(archManifest.ControlFile()); !strings.Contains(control, expect[arch]) {
+			t.Errorf("%s: expected %q, got %q", arch, expect[arch], control)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package mkdeb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+func ManifestFromJSON(data []byte) (*Manifest, error) {
+	manifest := new(Manifest)
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	err := d.Decode(manifest)
+	if err != nil {
+		return nil, explainJSONError(data, err)
+	}
+	rest := data[d.InputOffset():]
+	if trimmed := bytes.TrimLeft(rest, " \t\r\n"); len(trimmed) != 0 {
+		offset := d.InputOffset() + int64(len(rest)-len(trimmed))
+		line, column := lineAndColumn(data, offset)
+		return nil, fmt.Errorf("unexpected data after the manifest at line %d, column %d; is this more than one JSON object?", line, column)
+	}
+	return manifest, nil
+}
+
+func explainJSONError(data []byte, err error) error {
+	var b64Err base64.CorruptInputError
+	if errors.As(err, &b64Err) {
+		return fmt.Errorf("bytes: expected base64-encoded string: %w", b64Err)
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := lineAndColumn(data, syntaxErr.Offset)
+		return fmt.Errorf("syntax error at line %d, column %d: %w", line, column, err)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		line, column := lineAndColumn(data, typeErr.Offset)
+		return fmt.Errorf("%s: expected %v but got JSON %s at line %d, column %d: %w", typeErr.Field, typeErr.Type, typeErr.Value, line, column, err)
+	}
+
+	return err
+}
+
+func lineAndColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	prefix := data[:offset]
+	line := 1 + bytes.Count(prefix, []byte("\n"))
+	column := 1 + len(prefix) - (bytes.LastIndexByte(prefix, '\n') + 1)
+	return line, column
+}
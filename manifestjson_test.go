@@ -0,0 +1,81 @@
+package mkdeb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManifestFromJSON(t *testing.T) {
+	obj := testFooJSON("")
+
+	type testRow struct {
+		input     string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{obj, ""},
+		{obj + "\n", ""},
+		{"\n" + obj + " \r\n\t\n", ""},
+		{obj + obj, "unexpected data after the manifest at line 1, column 118"},
+		{obj + "\n" + obj + "\n", "unexpected data after the manifest at line 2, column 1"},
+		{obj + " garbage", "unexpected data after the manifest at line 1, column 119"},
+		{obj + "\n}", "unexpected data after the manifest at line 2, column 1"},
+		{`{"package": 42}`, "package: expected string"},
+		{`{"package": "foo", "owner": "root"}`, "unknown field"},
+	}
+
+	for _, row := range testData {
+		manifest, err := ManifestFromJSON([]byte(row.input))
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("ManifestFromJSON(%q): unexpected error: %v", row.input, err)
+		case row.expectErr == "" && manifest.Package != "foo":
+			t.Errorf("ManifestFromJSON(%q): expected package %q, got %q", row.input, "foo", manifest.Package)
+		case row.expectErr != "" && err == nil:
+			t.Errorf("ManifestFromJSON(%q): expected error containing %q, got nil", row.input, row.expectErr)
+		case row.expectErr != "" && !strings.Contains(err.Error(), row.expectErr):
+			t.Errorf("ManifestFromJSON(%q): expected error containing %q, got %v", row.input, row.expectErr, err)
+		}
+	}
+}
+
+func TestManifestFromJSONBinaryContent(t *testing.T) {
+	type testRow struct {
+		file      string
+		expect    string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`{"name": "a", "bytes": "AAEC/w=="}`, "\x00\x01\x02\xff", ""},
+		{`{"name": "a", "bytes": ""}`, "", ""},
+		{`{"name": "a", "bytes": "not base64!"}`, "", "bytes: expected base64-encoded string: illegal base64 data at input byte 3"},
+		{`{"name": "a", "bytesHex": "000102ff"}`, "\x00\x01\x02\xff", ""},
+		{`{"name": "a", "bytesHex": "0g"}`, "", "files[0].bytesHex: expected hex-encoded string"},
+		{`{"name": "a", "bytesHex": "00", "bytes": "AA=="}`, "", "files[0].bytesHex: conflict with field \"bytes\""},
+	}
+
+	for _, row := range testData {
+		manifest, err := ManifestFromJSON([]byte(testFooJSON(`"files": [` + row.file + `]`)))
+		if err == nil {
+			err = manifest.Resolve(nil)
+		}
+		if row.expectErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), row.expectErr) {
+				t.Errorf("%s: expected error starting with %q, got %v", row.file, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", row.file, err)
+			continue
+		}
+
+		pkg := testBuild(t, Builder{}, manifest)
+		_, contents := testMemberTar(t, pkg, "data.tar")
+		if got := string(contents["a"]); got != row.expect {
+			t.Errorf("%s: expected content %q, got %q", row.file, row.expect, got)
+		}
+	}
+}
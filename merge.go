@@ -0,0 +1,124 @@
+package mkdeb
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (manifest *Manifest) Merge(other *Manifest) error {
+	files := make(map[string]string, len(manifest.Files)+len(other.Files))
+	for index, file := range manifest.Files {
+		files[strings.TrimRight(file.Name, "/")] = fmt.Sprintf("files[%d] in the base manifest", index)
+	}
+	for index, file := range other.Files {
+		name := strings.TrimRight(file.Name, "/")
+		if oldFile, exists := files[name]; exists {
+			return fmt.Errorf("files[%d]: duplicate file %q has the same name as %s", index, file.Name, oldFile)
+		}
+		files[name] = fmt.Sprintf("files[%d] in the merged manifest", index)
+	}
+
+	mergeString(&manifest.Package, other.Package)
+	mergeString(&manifest.Source, other.Source)
+	mergeString(&manifest.SourceVersion, other.SourceVersion)
+	mergeString(&manifest.Version, other.Version)
+	mergeString(&manifest.Arch, other.Arch)
+	mergeString(&manifest.Section, other.Section)
+	mergeString(&manifest.Priority, other.Priority)
+	mergeString(&manifest.Essential, other.Essential)
+	mergeString(&manifest.Maintainer, other.Maintainer)
+	mergeString(&manifest.HomePage, other.HomePage)
+	mergeString(&manifest.BuiltUsing, other.BuiltUsing)
+	mergeString(&manifest.ShortDescription, other.ShortDescription)
+	mergeString(&manifest.ScriptUmask, other.ScriptUmask)
+
+	mergeRelations(&manifest.Depends, other.Depends)
+	mergeRelations(&manifest.PreDepends, other.PreDepends)
+	mergeRelations(&manifest.Recommends, other.Recommends)
+	mergeRelations(&manifest.Suggests, other.Suggests)
+	mergeRelations(&manifest.Enhances, other.Enhances)
+	mergeRelations(&manifest.Breaks, other.Breaks)
+	mergeRelations(&manifest.Conflicts, other.Conflicts)
+
+	if !other.DefaultMTime.IsZero() {
+		manifest.DefaultMTime = other.DefaultMTime
+	}
+
+	if other.Compression != CompressAuto {
+		manifest.Compression = other.Compression
+	}
+	if other.CompressionLevel != 0 {
+		manifest.CompressionLevel = other.CompressionLevel
+	}
+
+	if len(other.Arches) != 0 {
+		manifest.Arches = append([]string(nil), other.Arches...)
+	}
+	if len(other.BuildIds) != 0 {
+		manifest.BuildIds = append([]string(nil), other.BuildIds...)
+	}
+	if len(other.LongDescription) != 0 {
+		manifest.LongDescription = append([]string(nil), other.LongDescription...)
+	}
+
+	for lang, lines := range other.TranslatedDescriptions {
+		if manifest.TranslatedDescriptions == nil {
+			manifest.TranslatedDescriptions = make(map[string][]string, len(other.TranslatedDescriptions))
+		}
+		manifest.TranslatedDescriptions[lang] = append([]string(nil), lines...)
+	}
+
+	for _, dir := range other.ImplicitDirs {
+		if !containsString(manifest.ImplicitDirs, dir) {
+			manifest.ImplicitDirs = append(manifest.ImplicitDirs, dir)
+		}
+	}
+
+	for _, name := range other.ExtraConffiles {
+		if !containsString(manifest.ExtraConffiles, name) {
+			manifest.ExtraConffiles = append(manifest.ExtraConffiles, name)
+		}
+	}
+
+	manifest.Files = append(manifest.Files, other.Files...)
+	manifest.PreInstall = append(manifest.PreInstall, other.PreInstall...)
+	manifest.PostInstall = append(manifest.PostInstall, other.PostInstall...)
+	manifest.PreRemove = append(manifest.PreRemove, other.PreRemove...)
+	manifest.PostRemove = append(manifest.PostRemove, other.PostRemove...)
+
+	manifest.isResolved = false
+	manifest.isHashed = false
+	return nil
+}
+
+func mergeString(out *string, value string) {
+	if value != "" {
+		*out = value
+	}
+}
+
+func mergeRelations(out *Relations, value Relations) {
+	if value == "" {
+		return
+	}
+
+	var list []string
+	for _, str := range [...]Relations{*out, value} {
+		for _, item := range strings.Split(string(str), ",") {
+			item = strings.TrimSpace(item)
+			if item != "" && !containsString(list, item) {
+				list = append(list, item)
+			}
+		}
+	}
+	*out = Relations(strings.Join(list, ", "))
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
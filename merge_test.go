@@ -0,0 +1,82 @@
+package mkdeb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := testFooManifest(t, `"section": "misc", "depends": "libc6, foo-data (= 1.0)",
+		"longDescription": ["Base."],
+		"files": [{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}],
+		"postInstall": ["echo base"]`)
+	other := testManifest(t, `{
+		"version": "2.0", "section": "", "shortDescription": "foo baz",
+		"depends": "foo-data (= 1.0), libssl3", "recommends": "bar",
+		"files": [{"name": "etc/b", "text": "b\n"}],
+		"postInstall": ["echo other"]
+	}`)
+
+	if err := base.Merge(other); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	type testRow struct {
+		field  string
+		actual string
+		expect string
+	}
+
+	testData := [...]testRow{
+		{"package", base.Package, "foo"},
+		{"version", base.Version, "2.0"},
+		{"section", base.Section, "misc"},
+		{"shortDescription", base.ShortDescription, "foo baz"},
+		{"longDescription", strings.Join(base.LongDescription, "|"), "Base."},
+		{"depends", string(base.Depends), "libc6, foo-data (= 1.0), libssl3"},
+		{"recommends", string(base.Recommends), "bar"},
+		{"postInstall", strings.Join(base.PostInstall, "|"), "echo base|echo other"},
+	}
+
+	for _, row := range testData {
+		if row.actual != row.expect {
+			t.Errorf("%s: expected %q, got %q", row.field, row.expect, row.actual)
+		}
+	}
+
+	var names []string
+	for _, file := range base.Files {
+		names = append(names, file.Name)
+	}
+	if got := strings.Join(names, " "); got != "etc/ etc/a etc/b" {
+		t.Errorf("files: expected %q, got %q", "etc/ etc/a etc/b", got)
+	}
+
+	if err := base.Resolve(nil); err != nil {
+		t.Errorf("Resolve: %v", err)
+	}
+}
+
+func TestMergeDuplicateFile(t *testing.T) {
+	type testRow struct {
+		other     string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{`{"files": [{"name": "etc/a", "text": "x\n"}]}`, `files[0]: duplicate file "etc/a" has the same name as files[1] in the base manifest`},
+		{`{"files": [{"name": "etc"}]}`, `files[0]: duplicate file "etc" has the same name as files[0] in the base manifest`},
+		{`{"files": [{"name": "var/"}, {"name": "var/b", "text": "x\n"}, {"name": "var/b", "text": "y\n"}]}`, `files[2]: duplicate file "var/b" has the same name as files[1] in the merged manifest`},
+	}
+
+	for _, row := range testData {
+		base := testFooManifest(t, `"files": [{"name": "etc/"}, {"name": "etc/a", "text": "a\n"}]`)
+		err := base.Merge(testManifest(t, row.other))
+		if err == nil || err.Error() != row.expectErr {
+			t.Errorf("%s: expected error %q, got %v", row.other, row.expectErr, err)
+		}
+		if len(base.Files) != 2 {
+			t.Errorf("%s: expected the base manifest to be left unchanged, got %d files", row.other, len(base.Files))
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package mkdeb
 
 import (
+	"bytes"
 	"encoding"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type OwnerType byte
@@ -13,6 +15,7 @@ const (
 	OwnerUnspecified OwnerType = iota
 	OwnerByID
 	OwnerByName
+	OwnerByIDAndName
 )
 
 type Owner struct {
@@ -29,8 +32,20 @@ func Name(name string) Owner {
 	return Owner{Type: OwnerByName, Name: name}
 }
 
+func IDAndName(id int, name string) Owner {
+	return Owner{Type: OwnerByIDAndName, ID: id, Name: name}
+}
+
+func (owner Owner) HasID() bool {
+	return owner.Type == OwnerByID || owner.Type == OwnerByIDAndName
+}
+
+func (owner Owner) HasName() bool {
+	return owner.Type == OwnerByName || owner.Type == OwnerByIDAndName
+}
+
 func (owner Owner) IsZero() bool {
-	return owner.Type != OwnerByID && owner.Type != OwnerByName
+	return !owner.HasID() && !owner.HasName()
 }
 
 func (owner Owner) Equal(other Owner) bool {
@@ -39,6 +54,8 @@ func (owner Owner) Equal(other Owner) bool {
 		return other.Type == OwnerByID && owner.ID == other.ID
 	case OwnerByName:
 		return other.Type == OwnerByName && owner.Name == other.Name
+	case OwnerByIDAndName:
+		return other.Type == OwnerByIDAndName && owner.ID == other.ID && owner.Name == other.Name
 	default:
 		return other.IsZero()
 	}
@@ -50,6 +67,8 @@ func (owner Owner) GoString() string {
 		return fmt.Sprintf("mkdeb.ID(%d)", owner.ID)
 	case OwnerByName:
 		return fmt.Sprintf("mkdeb.Name(%q)", owner.Name)
+	case OwnerByIDAndName:
+		return fmt.Sprintf("mkdeb.IDAndName(%d, %q)", owner.ID, owner.Name)
 	default:
 		return "mkdeb.Owner{}"
 	}
@@ -61,6 +80,8 @@ func (owner Owner) String() string {
 		return fmt.Sprintf("#%d", owner.ID)
 	case OwnerByName:
 		return owner.Name
+	case OwnerByIDAndName:
+		return fmt.Sprintf("%s#%d", owner.Name, owner.ID)
 	default:
 		return ""
 	}
@@ -75,8 +96,8 @@ func (owner Owner) MarshalJSON() ([]byte, error) {
 	switch owner.Type {
 	case OwnerByID:
 		return json.Marshal(owner.ID)
-	case OwnerByName:
-		return json.Marshal(owner.Name)
+	case OwnerByName, OwnerByIDAndName:
+		return json.Marshal(owner.String())
 	default:
 		return []byte("null"), nil
 	}
@@ -87,12 +108,18 @@ func (owner *Owner) Parse(input string) error {
 	if input == "" {
 		return nil
 	}
-	if input[0] == '#' {
+	if input[0] == '#' && isDigits(input[1:]) {
 		if i64, err := strconv.ParseInt(input[1:], 10, 0); err == nil {
 			*owner = ID(int(i64))
 			return nil
 		}
 	}
+	if i := strings.LastIndexByte(input, '#'); i > 0 && isDigits(input[i+1:]) {
+		if i64, err := strconv.ParseInt(input[i+1:], 10, 0); err == nil {
+			*owner = IDAndName(int(i64), input[:i])
+			return nil
+		}
+	}
 	*owner = Name(input)
 	return nil
 }
@@ -103,6 +130,10 @@ func (owner *Owner) UnmarshalText(input []byte) error {
 
 func (owner *Owner) UnmarshalJSON(input []byte) error {
 	*owner = Owner{}
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return fmt.Errorf("failed to parse empty JSON value as mkdeb.Owner")
+	}
 	if jsonIsNull(input) {
 		return nil
 	}
@@ -0,0 +1,141 @@
+package mkdeb
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOwnerUnmarshalJSON(t *testing.T) {
+	type testRow struct {
+		input     string
+		expect    Owner
+		expectErr bool
+	}
+
+	testData := [...]testRow{
+		{``, Owner{}, true},
+		{`"`, Owner{}, true},
+		{" \t\n", Owner{}, true},
+		{`null`, Owner{}, false},
+		{`""`, Owner{}, false},
+		{`0`, ID(0), false},
+		{` 1000 `, ID(1000), false},
+		{`"root"`, Name("root"), false},
+		{`"1000"`, Name("1000"), false},
+		{`true`, Owner{}, true},
+		{`"unterminated`, Owner{}, true},
+	}
+
+	for _, row := range testData {
+		var owner Owner
+		err := owner.UnmarshalJSON([]byte(row.input))
+		if (err != nil) != row.expectErr {
+			t.Errorf("UnmarshalJSON(%q): unexpected error state: %v", row.input, err)
+			continue
+		}
+		if owner != row.expect {
+			t.Errorf("UnmarshalJSON(%q): expected %#v, got %#v", row.input, row.expect, owner)
+		}
+	}
+}
+
+func TestOwnerParse(t *testing.T) {
+	type testRow struct {
+		input  string
+		expect Owner
+	}
+
+	testData := [...]testRow{
+		{"", Owner{}},
+		{"root", Name("root")},
+		{"#0", ID(0)},
+		{"#1000", ID(1000)},
+		{"www-data#33", IDAndName(33, "www-data")},
+		{"a#b#7", IDAndName(7, "a#b")},
+		{"#abc", Name("#abc")},
+		{"#+5", Name("#+5")},
+		{"build#", Name("build#")},
+		{"build#+5", Name("build#+5")},
+		{"build#-1", Name("build#-1")},
+		{"build# 5", Name("build# 5")},
+		{"c#sharp", Name("c#sharp")},
+	}
+
+	for _, row := range testData {
+		var owner Owner
+		if err := owner.Parse(row.input); err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", row.input, err)
+			continue
+		}
+		if owner != row.expect {
+			t.Errorf("Parse(%q): expected %#v, got %#v", row.input, row.expect, owner)
+		}
+	}
+}
+
+func TestOwnerTarHeader(t *testing.T) {
+	manifest := testFooManifest(t, `"files": [
+		{"name": "var/"},
+		{"name": "var/www/", "user": "www-data#33", "group": "www-data#33"},
+		{"name": "var/www/index.html", "text": "hi\n", "user": "www-data#33", "group": "adm#4"}
+	]`)
+	pkg := testBuild(t, Builder{}, manifest)
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	expect := map[string][4]string{
+		"var/www/":           {"33", "www-data", "33", "www-data"},
+		"var/www/index.html": {"33", "www-data", "4", "adm"},
+	}
+	for _, hdr := range headers {
+		want, found := expect[hdr.Name]
+		if !found {
+			continue
+		}
+		got := [4]string{strconv.Itoa(hdr.Uid), hdr.Uname, strconv.Itoa(hdr.Gid), hdr.Gname}
+		if got != want {
+			t.Errorf("%s: expected uid/uname/gid/gname %q, got %q", hdr.Name, want, got)
+		}
+		delete(expect, hdr.Name)
+	}
+	for name := range expect {
+		t.Errorf("%s: missing from data.tar", name)
+	}
+}
+
+func TestDefaultNumericOwner(t *testing.T) {
+	manifest := testFooManifest(t, `"arch": "arm64", "files": [
+		{"name": "etc/"},
+		{"name": "etc/a", "text": "a\n"},
+		{"name": "etc/b", "type": "symlink", "link": "a"},
+		{"name": "srv/", "user": "#1000", "group": "www-data#33", "keepEmpty": true}
+	]`)
+	pkg := testBuild(t, Builder{DefaultNumericOwner: true}, manifest)
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if !strings.Contains(string(control["control"]), "\nArchitecture: arm64\n") {
+		t.Errorf("expected an arm64 package, got %q", control["control"])
+	}
+
+	headers, _ := testMemberTar(t, pkg, "data.tar")
+	if len(headers) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(headers))
+	}
+	for _, hdr := range headers {
+		expect := [4]string{"0", "root", "0", "root"}
+		if hdr.Name == "srv/" {
+			expect = [4]string{"1000", "", "33", ""}
+		}
+		got := [4]string{strconv.Itoa(hdr.Uid), hdr.Uname, strconv.Itoa(hdr.Gid), hdr.Gname}
+		if got != expect {
+			t.Errorf("%s: expected uid/uname/gid/gname %q, got %q", hdr.Name, expect, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Builder{DefaultNumericOwner: true}.Build(&buf, testFooManifest(t, `"files": [{"name": "srv/", "user": "www-data", "keepEmpty": true}]`))
+	if err == nil || !strings.Contains(err.Error(), `user: owner "www-data" has no numeric ID`) {
+		t.Errorf("expected a name-only owner to be rejected, got %v", err)
+	}
+}
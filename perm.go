@@ -1,6 +1,7 @@
 package mkdeb
 
 import (
+	"bytes"
 	"encoding"
 	"encoding/json"
 	"fmt"
@@ -47,6 +48,10 @@ func (perm *Perm) UnmarshalText(input []byte) error {
 
 func (perm *Perm) UnmarshalJSON(input []byte) error {
 	*perm = 0
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return fmt.Errorf("failed to parse empty JSON value as mkdeb.Perm")
+	}
 	if jsonIsNull(input) {
 		return nil
 	}
@@ -0,0 +1,39 @@
+package mkdeb
+
+import (
+	"testing"
+)
+
+func TestPermUnmarshalJSON(t *testing.T) {
+	type testRow struct {
+		input     string
+		expect    Perm
+		expectErr bool
+	}
+
+	testData := [...]testRow{
+		{``, 0, true},
+		{`"`, 0, true},
+		{" \t\n", 0, true},
+		{`null`, 0, false},
+		{`""`, 0, false},
+		{`493`, 0o755, false},
+		{`"0644"`, 0o644, false},
+		{`"04755"`, 0o4755, false},
+		{`-1`, 0, true},
+		{`"rwx"`, 0, true},
+		{`"unterminated`, 0, true},
+	}
+
+	for _, row := range testData {
+		var perm Perm
+		err := perm.UnmarshalJSON([]byte(row.input))
+		if (err != nil) != row.expectErr {
+			t.Errorf("UnmarshalJSON(%q): unexpected error state: %v", row.input, err)
+			continue
+		}
+		if perm != row.expect {
+			t.Errorf("UnmarshalJSON(%q): expected %#v, got %#v", row.input, row.expect, perm)
+		}
+	}
+}
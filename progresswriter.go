@@ -0,0 +1,22 @@
+package mkdeb
+
+import (
+	"io"
+)
+
+type progressWriter struct {
+	file     io.Writer
+	callback func(done, total int64, currentFile string)
+	done     *int64
+	total    int64
+	name     string
+}
+
+func (pw progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.file.Write(p)
+	if n > 0 {
+		*pw.done += int64(n)
+		pw.callback(*pw.done, pw.total, pw.name)
+	}
+	return n, err
+}
@@ -0,0 +1,189 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const defaultMaxMemberSize = 4 << 30
+
+var ErrMemberTooLarge = errors.New("decompressed member exceeds the size limit")
+
+type PackageReader struct {
+	MaxMemberSize int64
+}
+
+type Package struct {
+	DebianBinary       []byte
+	ControlCompression CompressAlgorithm
+	DataCompression    CompressAlgorithm
+	Control            []PackageEntry
+	Data               []PackageEntry
+}
+
+type PackageEntry struct {
+	Header  tar.Header
+	Content []byte
+}
+
+func (pkg *Package) ControlFile(name string) ([]byte, bool) {
+	return findPackageEntry(pkg.Control, name)
+}
+
+func (pkg *Package) DataFile(name string) ([]byte, bool) {
+	return findPackageEntry(pkg.Data, name)
+}
+
+func findPackageEntry(entries []PackageEntry, name string) ([]byte, bool) {
+	for _, entry := range entries {
+		if strings.TrimPrefix(entry.Header.Name, "./") == name {
+			return entry.Content, true
+		}
+	}
+	return nil, false
+}
+
+func ReadPackage(r io.Reader) (*Package, error) {
+	return PackageReader{}.Read(r)
+}
+
+func (pr PackageReader) maxMemberSize() int64 {
+	if pr.MaxMemberSize > 0 {
+		return pr.MaxMemberSize
+	}
+	return defaultMaxMemberSize
+}
+
+func (pr PackageReader) Read(r io.Reader) (*Package, error) {
+	var magic [len(arMagic)]byte
+	_, err := io.ReadFull(r, magic[:])
+	if err != nil {
+		return nil, fmt.Errorf("ar: failed to read magic header: %w", err)
+	}
+	if string(magic[:]) != arMagic {
+		return nil, fmt.Errorf("ar: missing magic header")
+	}
+
+	var pkg Package
+	var haveControl, haveData bool
+	for index := 0; ; index++ {
+		name, size, err := readArHeader(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ar: member %d: %w", index, err)
+		}
+
+		member := io.LimitReader(r, size)
+		switch {
+		case index == 0 && name != "debian-binary":
+			return nil, fmt.Errorf("ar: first member is %q, expected %q", name, "debian-binary")
+
+		case name == "debian-binary":
+			pkg.DebianBinary, err = io.ReadAll(&limitedReader{r: member, remaining: pr.maxMemberSize()})
+
+		case strings.HasPrefix(name, "control.tar"):
+			pkg.ControlCompression, pkg.Control, err = pr.readTarMember(member, name, "control.tar")
+			haveControl = true
+
+		case strings.HasPrefix(name, "data.tar"):
+			pkg.DataCompression, pkg.Data, err = pr.readTarMember(member, name, "data.tar")
+			haveData = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ar: %s: %w", name, err)
+		}
+
+		_, err = io.Copy(io.Discard, member)
+		if err == nil && size&1 != 0 {
+			_, err = io.CopyN(io.Discard, r, 1)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ar: %s: %w", name, err)
+		}
+	}
+
+	if !haveControl {
+		return nil, fmt.Errorf("ar: missing control.tar member")
+	}
+	if !haveData {
+		return nil, fmt.Errorf("ar: missing data.tar member")
+	}
+	return &pkg, nil
+}
+
+func (pr PackageReader) readTarMember(r io.Reader, name string, base string) (CompressAlgorithm, []PackageEntry, error) {
+	algo, found := ParseCompressionSuffix(name[len(base):])
+	if !found {
+		return CompressAuto, nil, fmt.Errorf("unknown compression suffix %q", name[len(base):])
+	}
+
+	cr, err := algo.NewReader(r)
+	if err != nil {
+		return algo, nil, err
+	}
+	defer cr.Close()
+
+	var entries []PackageEntry
+	tr := tar.NewReader(&limitedReader{r: cr, remaining: pr.maxMemberSize()})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return algo, entries, nil
+		}
+		if err != nil {
+			return algo, nil, err
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, tr)
+		if err != nil {
+			return algo, nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		entries = append(entries, PackageEntry{Header: *hdr, Content: buf.Bytes()})
+	}
+}
+
+func readArHeader(r io.Reader) (string, int64, error) {
+	var hdr [arHeaderSize]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err == io.EOF {
+		return "", 0, io.EOF
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(hdr[58:60]) != "`\n" {
+		return "", 0, fmt.Errorf("malformed header")
+	}
+
+	name := strings.TrimSuffix(strings.TrimRight(string(hdr[0:16]), " "), "/")
+	size, err := strconv.ParseInt(strings.TrimRight(string(hdr[48:58]), " "), 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("malformed size %q", hdr[48:58])
+	}
+	return name, size, nil
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	if int64(n) > lr.remaining {
+		lr.remaining = 0
+		return 0, ErrMemberTooLarge
+	}
+	lr.remaining -= int64(n)
+	return n, err
+}
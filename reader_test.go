@@ -0,0 +1,94 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+func TestReadPackage(t *testing.T) {
+	for _, algo := range []CompressAlgorithm{CompressNone, CompressGZIP, CompressXZ, CompressZSTD} {
+		t.Run(algo.String(), func(t *testing.T) {
+			manifest := testFooManifest(t, `"implicitDirs": ["usr/", "usr/share/", "usr/share/foo/"], "files": [{"name": "usr/share/foo/bar", "text": "bar\n"}]`)
+			data := testBuild(t, Builder{Compression: algo}, manifest)
+
+			pkg, err := ReadPackage(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("ReadPackage: %v", err)
+			}
+			if !bytes.Equal(pkg.DebianBinary, defaultDebianBinary) {
+				t.Errorf("debian-binary: expected %q, got %q", defaultDebianBinary, pkg.DebianBinary)
+			}
+			if pkg.DataCompression != algo {
+				t.Errorf("expected %v for data.tar, got %v", algo, pkg.DataCompression)
+			}
+			if control, found := pkg.ControlFile("control"); !found || !bytes.Contains(control, []byte("Package: foo\n")) {
+				t.Errorf("control: expected a stanza for foo, got %q", control)
+			}
+			if content, found := pkg.DataFile("usr/share/foo/bar"); !found || string(content) != "bar\n" {
+				t.Errorf("usr/share/foo/bar: expected %q, got %q (found=%v)", "bar\n", content, found)
+			}
+		})
+	}
+}
+
+func TestReadPackageMemberLimit(t *testing.T) {
+	var control bytes.Buffer
+	tw := tar.NewWriter(&control)
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar.Writer.Close: %v", err)
+	}
+
+	const bombSize = 16 << 20
+	var data bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&data, gzip.BestCompression)
+	tw = tar.NewWriter(gw)
+	err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "zeroes", Mode: 0o644, Size: bombSize})
+	if err != nil {
+		t.Fatalf("tar.Writer.WriteHeader: %v", err)
+	}
+	if _, err := tw.Write(make([]byte, bombSize)); err != nil {
+		t.Fatalf("tar.Writer.Write: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar.Writer.Close: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip.Writer.Close: %v", err)
+	}
+	if data.Len() >= bombSize/100 {
+		t.Fatalf("expected a highly compressible member, got %d bytes", data.Len())
+	}
+
+	var pkg bytes.Buffer
+	pkg.WriteString(arMagic)
+	attrs := Builder{}.arAttrs()
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", defaultDebianBinary},
+		{"control.tar", control.Bytes()},
+		{"data.tar.gz", data.Bytes()},
+	} {
+		err := writeArEntry(&pkg, member.name, int64(len(member.data)), attrs, bytes.NewReader(member.data))
+		if err != nil {
+			t.Fatalf("writeArEntry: %s: %v", member.name, err)
+		}
+	}
+
+	_, err = PackageReader{MaxMemberSize: 1 << 20}.Read(bytes.NewReader(pkg.Bytes()))
+	if !errors.Is(err, ErrMemberTooLarge) {
+		t.Errorf("expected ErrMemberTooLarge with a 1MiB limit, got %v", err)
+	}
+
+	read, err := ReadPackage(bytes.NewReader(pkg.Bytes()))
+	if err != nil {
+		t.Fatalf("expected the default limit to allow %d bytes, got %v", bombSize, err)
+	}
+	if content, _ := read.DataFile("zeroes"); len(content) != bombSize {
+		t.Errorf("zeroes: expected %d bytes, got %d", bombSize, len(content))
+	}
+}
@@ -0,0 +1,209 @@
+package mkdeb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type Relations string
+
+type Relation struct {
+	Name    string `json:"name"`
+	Arch    string `json:"arch"`
+	Version string `json:"version"`
+}
+
+func (rel Relation) String() string {
+	var buf strings.Builder
+	buf.WriteString(rel.Name)
+	if rel.Arch != "" {
+		buf.WriteString(":")
+		buf.WriteString(rel.Arch)
+	}
+	if rel.Version != "" {
+		op, version := splitVersionConstraint(rel.Version)
+		buf.WriteString(" (")
+		buf.WriteString(op)
+		buf.WriteString(" ")
+		buf.WriteString(version)
+		buf.WriteString(")")
+	}
+	return buf.String()
+}
+
+func (rel Relation) validate() error {
+	if rel.Name == "" {
+		return fmt.Errorf("name: missing required field")
+	}
+	if !isValidPackage(rel.Name) {
+		return fmt.Errorf("name: invalid Debian package name %q", rel.Name)
+	}
+	if rel.Arch != "" && !isValidArch(rel.Arch) && !substVarRx.MatchString(rel.Arch) {
+		return fmt.Errorf("arch: invalid Debian package architecture %q", rel.Arch)
+	}
+	if rel.Version != "" {
+		op, version := splitVersionConstraint(rel.Version)
+		if _, found := versionOperators[op]; !found {
+			return fmt.Errorf("version: invalid relation operator %q", op)
+		}
+		if !isValidVersion(version) && !substVarRx.MatchString(version) {
+			return fmt.Errorf("version: invalid Debian package version %q", version)
+		}
+	}
+	return nil
+}
+
+func (manifest *Manifest) expandRelations() error {
+	sourceVersion := manifest.SourceVersion
+	if sourceVersion == "" {
+		sourceVersion = manifest.Version
+	}
+
+	vars := map[string]string{
+		"Version":        manifest.Version,
+		"binary:Version": manifest.Version,
+		"Source-Version": sourceVersion,
+		"Architecture":   manifest.Arch,
+	}
+
+	fields := []struct {
+		name  string
+		value *Relations
+	}{
+		{"depends", &manifest.Depends},
+		{"preDepends", &manifest.PreDepends},
+		{"recommends", &manifest.Recommends},
+		{"suggests", &manifest.Suggests},
+		{"enhances", &manifest.Enhances},
+		{"breaks", &manifest.Breaks},
+		{"conflicts", &manifest.Conflicts},
+	}
+
+	for _, field := range fields {
+		var err error
+		expanded := substVarRx.ReplaceAllStringFunc(string(*field.value), func(token string) string {
+			value, found := vars[token[2:len(token)-1]]
+			if !found && err == nil {
+				err = validationErrorf(field.name, ValidationInvalid, "unknown substitution variable %q", token)
+			}
+			if value == "" {
+				return token
+			}
+			return value
+		})
+		if err != nil {
+			return err
+		}
+		*field.value = Relations(expanded)
+	}
+	return nil
+}
+
+var versionOperators = map[string]struct{}{
+	"<<": {},
+	"<=": {},
+	"=":  {},
+	">=": {},
+	">>": {},
+}
+
+func splitVersionConstraint(str string) (string, string) {
+	str = strings.TrimSpace(str)
+	i := strings.IndexFunc(str, func(ch rune) bool {
+		return ch != '<' && ch != '=' && ch != '>'
+	})
+	if i < 0 {
+		return str, ""
+	}
+	if i == 0 {
+		return "=", str
+	}
+	return str[:i], strings.TrimSpace(str[i:])
+}
+
+func (rels *Relations) UnmarshalJSON(input []byte) error {
+	*rels = ""
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return fmt.Errorf("failed to parse empty JSON value as mkdeb.Relations")
+	}
+	if jsonIsNull(input) {
+		return nil
+	}
+
+	if input[0] == '"' {
+		var str string
+		if err := json.Unmarshal(input, &str); err != nil {
+			return fmt.Errorf("failed to parse JSON value %q as string: %w", input, err)
+		}
+		*rels = Relations(str)
+		return nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(input, &items); err != nil {
+		return fmt.Errorf("failed to parse JSON value %q as string or array: %w", input, err)
+	}
+
+	groups := make([]string, 0, len(items))
+	for index, item := range items {
+		group, err := parseRelationGroup(item)
+		if err != nil {
+			return fmt.Errorf("[%d]: %w", index, err)
+		}
+		groups = append(groups, group)
+	}
+	*rels = Relations(strings.Join(groups, ", "))
+	return nil
+}
+
+func parseRelationGroup(input json.RawMessage) (string, error) {
+	input = bytes.TrimSpace(input)
+	if len(input) > 0 && input[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(input, &items); err != nil {
+			return "", fmt.Errorf("failed to parse JSON value %q as array: %w", input, err)
+		}
+		if len(items) == 0 {
+			return "", fmt.Errorf("empty list of alternatives")
+		}
+		alts := make([]string, 0, len(items))
+		for index, item := range items {
+			alt, err := parseRelation(item)
+			if err != nil {
+				return "", fmt.Errorf("[%d]: %w", index, err)
+			}
+			alts = append(alts, alt)
+		}
+		return strings.Join(alts, " | "), nil
+	}
+	return parseRelation(input)
+}
+
+func parseRelation(input json.RawMessage) (string, error) {
+	if len(input) > 0 && input[0] == '"' {
+		var str string
+		if err := json.Unmarshal(input, &str); err != nil {
+			return "", fmt.Errorf("failed to parse JSON value %q as string: %w", input, err)
+		}
+		return str, nil
+	}
+
+	var rel Relation
+	d := json.NewDecoder(bytes.NewReader(input))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&rel); err != nil {
+		return "", fmt.Errorf("failed to parse JSON value %q as relation: %w", input, err)
+	}
+	if err := rel.validate(); err != nil {
+		return "", err
+	}
+	return rel.String(), nil
+}
+
+var (
+	_ fmt.Stringer     = Relation{}
+	_ json.Unmarshaler = (*Relations)(nil)
+)
@@ -0,0 +1,125 @@
+package mkdeb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRelationsForms(t *testing.T) {
+	type testRow struct {
+		str        string
+		structured string
+	}
+
+	testData := [...]testRow{
+		{`"foo"`, `[{"name": "foo"}]`},
+		{`"foo (>= 1.0), bar"`, `[{"name": "foo", "version": ">= 1.0"}, {"name": "bar"}]`},
+		{`"foo (= 2:1.0-1), bar:any"`, `[{"name": "foo", "version": "2:1.0-1"}, {"name": "bar", "arch": "any"}]`},
+		{`"foo | bar (<< 3), baz"`, `[[{"name": "foo"}, {"name": "bar", "version": "<<3"}], "baz"]`},
+	}
+
+	for _, row := range testData {
+		var controls [2]string
+		for index, depends := range [2]string{row.str, row.structured} {
+			manifest := testFooManifest(t, `"depends": `+depends)
+			if err := manifest.Resolve(nil); err != nil {
+				t.Fatalf("%s: Resolve: %v", depends, err)
+			}
+			controls[index] = string(manifest.ControlFile())
+		}
+		if controls[0] != controls[1] {
+			t.Errorf("%s: string and structured forms differ:\n%s\nvs\n%s", row.structured, controls[0], controls[1])
+		}
+		if !strings.Contains(controls[1], "\nDepends: ") {
+			t.Errorf("%s: expected a Depends field, got %q", row.structured, controls[1])
+		}
+	}
+}
+
+func TestRelationsErrors(t *testing.T) {
+	testData := [...]string{
+		`[{"version": ">= 1"}]`,
+		`[{"name": "Foo"}]`,
+		`[{"name": "foo", "version": "~> 1"}]`,
+		`[{"name": "foo", "arch": "not an arch"}]`,
+		`[{"name": "foo", "extra": true}]`,
+		`[[]]`,
+		`42`,
+	}
+
+	for _, depends := range testData {
+		_, err := ManifestFromJSON([]byte(testFooJSON(`"depends": ` + depends)))
+		if err == nil {
+			t.Errorf("%s: expected error", depends)
+		}
+	}
+}
+
+func TestRelationsSubstitution(t *testing.T) {
+	type testRow struct {
+		fields    string
+		expect    map[string]string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{
+			`"arch": "amd64", "depends": "libfoo (= ${Version})"`,
+			map[string]string{"amd64": "libfoo (= 1.0)"},
+			"",
+		},
+		{
+			`"arch": "amd64", "source": "foo-src", "sourceVersion": "2.0", "depends": ["libfoo:${Architecture} (= ${binary:Version})", "foo-data (>= ${Source-Version})"]`,
+			map[string]string{"amd64": "libfoo:amd64 (= 1.0), foo-data (>= 2.0)"},
+			"",
+		},
+		{
+			`"arches": ["amd64", "arm64"], "depends": [{"name": "libfoo", "arch": "${Architecture}", "version": "= ${Version}"}]`,
+			map[string]string{"amd64": "libfoo:amd64 (= 1.0)", "arm64": "libfoo:arm64 (= 1.0)"},
+			"",
+		},
+		{
+			`"arch": "amd64", "depends": "libfoo (= ${Bogus})"`,
+			nil,
+			`depends: unknown substitution variable "${Bogus}"`,
+		},
+		{
+			`"arches": ["amd64", "arm64"], "depends": "libfoo (= ${Bogus})"`,
+			nil,
+			`amd64: depends: unknown substitution variable "${Bogus}"`,
+		},
+	}
+
+	for _, row := range testData {
+		js := `{"package": "foo", "version": "1.0", "maintainer": "x <x@example.com>", "shortDescription": "foo bar", ` + row.fields + `}`
+		manifest := testManifest(t, js)
+		err := manifest.Validate()
+		if row.expectErr != "" {
+			if err == nil || err.Error() != row.expectErr {
+				t.Errorf("%s: expected error %q, got %v", row.fields, row.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Validate: %v", row.fields, err)
+			continue
+		}
+
+		for arch, expect := range row.expect {
+			archManifest := manifest
+			if len(manifest.Arches) != 0 {
+				archManifest = manifest.ForArch(arch)
+			}
+			if err := archManifest.Resolve(nil); err != nil {
+				t.Errorf("%s: %s: Resolve: %v", row.fields, arch, err)
+				continue
+			}
+			if got := string(archManifest.Depends); got != expect {
+				t.Errorf("%s: %s: expected %q, got %q", row.fields, arch, expect, got)
+			}
+			if control := string(archManifest.ControlFile()); !strings.Contains(control, "\nDepends: "+expect+"\n") {
+				t.Errorf("%s: %s: expected the control file to contain %q, got %q", row.fields, arch, expect, control)
+			}
+		}
+	}
+}
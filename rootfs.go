@@ -0,0 +1,68 @@
+package mkdeb
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+func isArchivePath(rootPath string) bool {
+	lc := strings.ToLower(rootPath)
+	for _, suffix := range [...]string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lc, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func openRootFS(rootPath string) (fs.FS, io.Closer, error) {
+	lc := strings.ToLower(rootPath)
+
+	if strings.HasSuffix(lc, ".zip") {
+		zr, err := zip.OpenReader(rootPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zip archive: %q: %w", rootPath, err)
+		}
+		return zr, zr, nil
+	}
+
+	isTar := strings.HasSuffix(lc, ".tar")
+	isTarGZ := strings.HasSuffix(lc, ".tar.gz") || strings.HasSuffix(lc, ".tgz")
+	if !isTar && !isTarGZ {
+		return os.DirFS(rootPath), nil, nil
+	}
+
+	f, err := os.Open(rootPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open tar archive: %q: %w", rootPath, err)
+	}
+
+	if isTarGZ {
+		defer func() {
+			_ = f.Close()
+		}()
+
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open tar archive: %q: gzip.NewReader: %w", rootPath, err)
+		}
+
+		tfs, err := newTarFS(gr, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read tar archive: %q: %w", rootPath, err)
+		}
+		return tfs, nil, nil
+	}
+
+	tfs, err := newTarFS(f, f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("failed to read tar archive: %q: %w", rootPath, err)
+	}
+	return tfs, f, nil
+}
@@ -0,0 +1,104 @@
+package mkdeb
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildFromZipRoot(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"etc/foo.conf":    "a=b\n",
+		"usr/bin/foo":     "#!/bin/sh\n",
+		"usr/share/doc/x": "docs\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/foo.conf", "isConf": true},
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/foo", "perm": "0755"},
+		{"name": "usr/share/"},
+		{"name": "usr/share/doc/"},
+		{"name": "usr/share/doc/foo", "path": "usr/share/doc/x"}
+	]`)
+	pkg := testBuild(t, Builder{Root: zr}, manifest)
+
+	_, contents := testMemberTar(t, pkg, "data.tar")
+	expect := map[string]string{
+		"etc/foo.conf":      "a=b\n",
+		"usr/bin/foo":       "#!/bin/sh\n",
+		"usr/share/doc/foo": "docs\n",
+	}
+	for name, want := range expect {
+		if got := string(contents[name]); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	_, control := testMemberTar(t, pkg, "control.tar")
+	if got := string(control["conffiles"]); got != "etc/foo.conf\n" {
+		t.Errorf("conffiles: expected %q, got %q", "etc/foo.conf\n", got)
+	}
+}
+
+func TestOpenRootFSTar(t *testing.T) {
+	rootPath := filepath.Join(t.TempDir(), "root.tar")
+	if err := os.WriteFile(rootPath, testTarArchive(t), 0o666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if !isArchivePath(rootPath) {
+		t.Fatalf("%q: expected an archive path", rootPath)
+	}
+
+	rootFS, closer, err := openRootFS(rootPath)
+	if err != nil {
+		t.Fatalf("openRootFS: %v", err)
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+
+	manifest := testFooManifest(t, `"files": [
+		{"name": "etc/"},
+		{"name": "etc/a"},
+		{"name": "etc/c"},
+		{"name": "usr/"},
+		{"name": "usr/bin/"},
+		{"name": "usr/bin/tool", "path": "bin/tool"}
+	]`)
+	pkg := testBuild(t, Builder{Root: rootFS}, manifest)
+
+	_, contents := testMemberTar(t, pkg, "data.tar")
+	expect := map[string]string{
+		"etc/a":        "hello\n",
+		"etc/c":        "hello\n",
+		"usr/bin/tool": "tool\n",
+	}
+	for name, want := range expect {
+		if got := string(contents[name]); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package mkdeb
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+func selfTestManifest() *Manifest {
+	text := func(str string) *string { return &str }
+	return &Manifest{
+		Package:          "mkdeb-selftest",
+		Version:          "1.0-1",
+		Arch:             "all",
+		Maintainer:       "mkdeb <mkdeb@example.com>",
+		ShortDescription: "mkdeb self-test package",
+		ImplicitDirs:     []string{"usr/", "usr/share/", "usr/share/doc/"},
+		Files: []File{
+			{Name: "usr/share/doc/mkdeb-selftest/", Type: TypeDIR},
+			{Name: "usr/share/doc/mkdeb-selftest/README", Text: text("mkdeb self-test\n")},
+			{Name: "usr/share/doc/mkdeb-selftest/empty", Text: text("")},
+			{Name: "usr/share/doc/mkdeb-selftest/link", Type: TypeLNK, Link: text("README")},
+		},
+	}
+}
+
+func SelfTest() error {
+	return selfTest(Builder{Compression: CompressNone})
+}
+
+func selfTest(builder Builder) error {
+	manifest := selfTestManifest()
+	expectContent := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if file.Text != nil {
+			expectContent[file.Name] = *file.Text
+		}
+	}
+
+	var buf bytes.Buffer
+	artifact, err := builder.BuildArtifact(context.Background(), &buf, manifest)
+	if err != nil {
+		return fmt.Errorf("build: %w", err)
+	}
+
+	data := buf.Bytes()
+	if artifact.Size != int64(len(data)) {
+		return fmt.Errorf("artifact: size is %d, but wrote %d bytes", artifact.Size, len(data))
+	}
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(artifact.Hashes[HashSHA256], sum[:]) {
+		return fmt.Errorf("artifact: SHA-256 mismatch")
+	}
+	if !bytes.HasPrefix(data, []byte(arMagic)) {
+		return fmt.Errorf("ar: missing magic header")
+	}
+
+	var names []string
+	for _, member := range artifact.Members {
+		names = append(names, member.Name)
+	}
+	expectNames := []string{"debian-binary", "control.tar", "data.tar"}
+	if strings.Join(names, " ") != strings.Join(expectNames, " ") {
+		return fmt.Errorf("ar: members are %q, expected %q", names, expectNames)
+	}
+
+	pkg, err := ReadPackage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if !bytes.Equal(pkg.DebianBinary, defaultDebianBinary) {
+		return fmt.Errorf("debian-binary: contents are %q, expected %q", pkg.DebianBinary, defaultDebianBinary)
+	}
+
+	control, _ := pkg.ControlFile("control")
+	md5sums, _ := pkg.ControlFile("md5sums")
+
+	expectControl := formatControlFields(builder.controlFields(manifest))
+	if !bytes.Equal(control, expectControl) {
+		return fmt.Errorf("control.tar: control: contents are %q, expected %q", control, expectControl)
+	}
+	for _, line := range []string{
+		"Package: " + manifest.Package,
+		"Version: " + manifest.Version,
+		"Architecture: " + manifest.Arch,
+	} {
+		if !bytes.Contains(control, []byte(line+"\n")) {
+			return fmt.Errorf("control.tar: control: missing field %q", line)
+		}
+	}
+
+	var expectMd5sums bytes.Buffer
+	for _, file := range manifest.Files {
+		content, found := expectContent[file.Name]
+		if !found {
+			continue
+		}
+		actual, found := pkg.DataFile(file.Name)
+		if !found {
+			return fmt.Errorf("data.tar: missing file %q", file.Name)
+		}
+		if string(actual) != content {
+			return fmt.Errorf("data.tar: %s: contents are %q, expected %q", file.Name, actual, content)
+		}
+		md5sum := md5.Sum([]byte(content))
+		fmt.Fprintf(&expectMd5sums, "%s  %s\n", hex.EncodeToString(md5sum[:]), file.Name)
+	}
+	if !bytes.Equal(md5sums, expectMd5sums.Bytes()) {
+		return fmt.Errorf("control.tar: md5sums: contents are %q, expected %q", md5sums, expectMd5sums.Bytes())
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const tarBlockSize = 512
+
+type sparseEntry struct {
+	Offset int64
+	Length int64
+}
+
+func scanSparse(r io.Reader) ([]sparseEntry, int64, error) {
+	var entries []sparseEntry
+	var offset int64
+	buf := make([]byte, 128*tarBlockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i < n; i += tarBlockSize {
+			j := i + tarBlockSize
+			if j > n {
+				j = n
+			}
+			block := buf[i:j]
+			if !isZeroBlock(block) {
+				blockOffset := offset + int64(i)
+				last := len(entries) - 1
+				if last >= 0 && entries[last].Offset+entries[last].Length == blockOffset {
+					entries[last].Length += int64(len(block))
+				} else {
+					entries = append(entries, sparseEntry{Offset: blockOffset, Length: int64(len(block))})
+				}
+			}
+		}
+		offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	last := len(entries) - 1
+	if last < 0 || entries[last].Offset+entries[last].Length != offset {
+		entries = append(entries, sparseEntry{Offset: offset})
+	}
+	return entries, offset, nil
+}
+
+func isZeroBlock(block []byte) bool {
+	for _, ch := range block {
+		if ch != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func writeSparseEntry(w io.Writer, hdr *tar.Header, entries []sparseEntry, realSize int64, r io.Reader) error {
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(entries))
+	for _, entry := range entries {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", entry.Offset, entry.Length)
+	}
+	mapSize := padSigned(int64(sparseMap.Len()), 9)
+	sparseMap.Write(make([]byte, mapSize-int64(sparseMap.Len())))
+
+	dataSize := mapSize
+	for _, entry := range entries {
+		dataSize += entry.Length
+	}
+
+	dir, base := path.Split(hdr.Name)
+
+	var records bytes.Buffer
+	writePAXRecord(&records, "GNU.sparse.major", "1")
+	writePAXRecord(&records, "GNU.sparse.minor", "0")
+	writePAXRecord(&records, "GNU.sparse.name", hdr.Name)
+	writePAXRecord(&records, "GNU.sparse.realsize", strconv.FormatInt(realSize, 10))
+	if hdr.ModTime.Nanosecond() != 0 || !fitsOctal(hdr.ModTime.Unix(), 12) {
+		writePAXRecord(&records, "mtime", formatPAXTime(hdr.ModTime))
+	}
+	if hdr.Uname != "" {
+		writePAXRecord(&records, "uname", hdr.Uname)
+	}
+	if hdr.Gname != "" {
+		writePAXRecord(&records, "gname", hdr.Gname)
+	}
+	if !fitsOctal(int64(hdr.Uid), 8) {
+		writePAXRecord(&records, "uid", strconv.Itoa(hdr.Uid))
+	}
+	if !fitsOctal(int64(hdr.Gid), 8) {
+		writePAXRecord(&records, "gid", strconv.Itoa(hdr.Gid))
+	}
+	if !fitsOctal(dataSize, 12) {
+		writePAXRecord(&records, "size", strconv.FormatInt(dataSize, 10))
+	}
+
+	paxHdr := *hdr
+	paxHdr.Name = dir + "PaxHeaders.0/" + base
+	paxHdr.Mode = 0o644
+	err := writeUSTARBlock(w, &paxHdr, tar.TypeXHeader, int64(records.Len()))
+	if err != nil {
+		return err
+	}
+	err = writePadded(w, records.Bytes())
+	if err != nil {
+		return err
+	}
+
+	sparseHdr := *hdr
+	sparseHdr.Name = dir + "GNUSparseFile.0/" + base
+	err = writeUSTARBlock(w, &sparseHdr, tar.TypeReg, dataSize)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(sparseMap.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for _, entry := range entries {
+		_, err = io.CopyN(io.Discard, r, entry.Offset-offset)
+		if err != nil {
+			return fmt.Errorf("skip hole: %w", err)
+		}
+		_, err = io.CopyN(w, r, entry.Length)
+		if err != nil {
+			return fmt.Errorf("copy data: %w", err)
+		}
+		offset = entry.Offset + entry.Length
+	}
+
+	_, err = w.Write(make([]byte, padSigned(dataSize, 9)-dataSize))
+	return err
+}
+
+func writePAXRecord(buf *bytes.Buffer, key, value string) {
+	const padding = 3 // space, '=', newline
+	size := len(key) + len(value) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	if len(record) != size {
+		size = len(record)
+		record = strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	}
+	buf.WriteString(record)
+}
+
+func formatPAXTime(t time.Time) string {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+
+	sign := ""
+	if sec < 0 {
+		sign = "-"
+		sec = -(sec + 1)
+		nsec = 1e9 - nsec
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%09d", sign, sec, nsec), "0")
+}
+
+func writePadded(w io.Writer, data []byte) error {
+	size := int64(len(data))
+	_, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(make([]byte, padSigned(size, 9)-size))
+	return err
+}
+
+func fitsOctal(x int64, width int) bool {
+	return x >= 0 && x < int64(1)<<(3*(width-1))
+}
+
+func writeUSTARBlock(w io.Writer, hdr *tar.Header, typeflag byte, size int64) error {
+	var block [tarBlockSize]byte
+
+	name := hdr.Name
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	copy(block[0:100], name)
+
+	uid, gid := int64(hdr.Uid), int64(hdr.Gid)
+	if !fitsOctal(uid, 8) {
+		uid = 0
+	}
+	if !fitsOctal(gid, 8) {
+		gid = 0
+	}
+	if !fitsOctal(size, 12) {
+		size = 0
+	}
+	mtime := hdr.ModTime.Unix()
+	if !fitsOctal(mtime, 12) {
+		mtime = 0
+	}
+
+	formatOctal(block[100:108], hdr.Mode&0o7777)
+	formatOctal(block[108:116], uid)
+	formatOctal(block[116:124], gid)
+	formatOctal(block[124:136], size)
+	formatOctal(block[136:148], mtime)
+	block[156] = typeflag
+	copy(block[257:263], "ustar\x00")
+	copy(block[263:265], "00")
+	if len(hdr.Uname) < 32 {
+		copy(block[265:297], hdr.Uname)
+	}
+	if len(hdr.Gname) < 32 {
+		copy(block[297:329], hdr.Gname)
+	}
+	formatOctal(block[329:337], 0)
+	formatOctal(block[337:345], 0)
+
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, ch := range block {
+		sum += int64(ch)
+	}
+	formatOctal(block[148:155], sum)
+	block[155] = ' '
+
+	_, err := w.Write(block[:])
+	return err
+}
+
+func formatOctal(b []byte, x int64) {
+	s := strconv.FormatInt(x, 8)
+	for len(s) < len(b)-1 {
+		s = "0" + s
+	}
+	copy(b, s)
+	b[len(b)-1] = 0
+}
@@ -0,0 +1,134 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSparseEntryModTime(t *testing.T) {
+	content := append(append([]byte("head"), make([]byte, 8192)...), "tail"...)
+	entries, realSize, err := scanSparse(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("scanSparse: %v", err)
+	}
+
+	testData := []time.Time{
+		time.Unix(1577836800, 0),
+		time.Unix(1577836800, 250000000),
+		time.Unix(-86400, 0),
+		time.Unix(-86400, 500000000),
+		time.Unix(1<<34, 0),
+	}
+
+	for _, mtime := range testData {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "var/lib/sparse",
+			Mode:     0o644,
+			Size:     realSize,
+			ModTime:  mtime,
+		}
+
+		var buf bytes.Buffer
+		err = writeSparseEntry(&buf, hdr, entries, realSize, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("%v: writeSparseEntry: %v", mtime, err)
+		}
+		buf.Write(make([]byte, 2*tarBlockSize))
+
+		tr := tar.NewReader(&buf)
+		got, err := tr.Next()
+		if err != nil {
+			t.Fatalf("%v: tar.Next: %v", mtime, err)
+		}
+		if got.Name != hdr.Name {
+			t.Errorf("%v: expected name %q, got %q", mtime, hdr.Name, got.Name)
+		}
+		if !got.ModTime.Equal(mtime) {
+			t.Errorf("%v: expected mtime %v, got %v", mtime, mtime, got.ModTime)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("%v: ReadAll: %v", mtime, err)
+		}
+		if !bytes.Equal(body, content) {
+			t.Errorf("%v: content mismatch", mtime)
+		}
+	}
+}
+
+func TestFormatPAXTime(t *testing.T) {
+	type testRow struct {
+		input  time.Time
+		expect string
+	}
+
+	testData := [...]testRow{
+		{time.Unix(0, 0), "0"},
+		{time.Unix(1577836800, 0), "1577836800"},
+		{time.Unix(1577836800, 250000000), "1577836800.25"},
+		{time.Unix(-86400, 0), "-86400"},
+		{time.Unix(-86400, 500000000), "-86399.5"},
+	}
+
+	for _, row := range testData {
+		actual := formatPAXTime(row.input)
+		if actual != row.expect {
+			t.Errorf("formatPAXTime(%v): expected %q, got %q", row.input, row.expect, actual)
+		}
+	}
+}
+
+func TestBuildSparse(t *testing.T) {
+	const realSize = 1 << 20
+	content := make([]byte, realSize)
+	copy(content, "head")
+	copy(content[realSize/2:], "middle")
+	copy(content[realSize-4:], "tail")
+
+	root := fstest.MapFS{"var/lib/foo/image": {Data: content, Mode: 0o644}}
+	manifest := testFooManifest(t, `"files": [
+		{"name": "var/"},
+		{"name": "var/lib/"},
+		{"name": "var/lib/foo/"},
+		{"name": "var/lib/foo/image", "sparse": true}
+	]`)
+	pkg := testBuild(t, Builder{Root: root, TarFormat: tar.FormatPAX, Compression: CompressNone}, manifest)
+
+	_, data := testArMemberData(t, testReadAr(t, pkg), "data.tar")
+	for _, record := range []string{
+		"GNU.sparse.major=1\n",
+		"GNU.sparse.minor=0\n",
+		"GNU.sparse.name=var/lib/foo/image\n",
+		"GNU.sparse.realsize=" + strconv.Itoa(realSize) + "\n",
+	} {
+		if !bytes.Contains(data, []byte(record)) {
+			t.Errorf("data.tar: missing PAX record %q", record)
+		}
+	}
+	if len(data) >= realSize {
+		t.Errorf("data.tar: expected fewer than %d bytes, got %d", realSize, len(data))
+	}
+
+	headers, files := testReadTar(t, data)
+	found := false
+	for _, hdr := range headers {
+		if hdr.Name == "var/lib/foo/image" {
+			found = true
+			if hdr.Size != realSize {
+				t.Errorf("var/lib/foo/image: expected size %d, got %d", realSize, hdr.Size)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("data.tar: missing var/lib/foo/image")
+	}
+	if !bytes.Equal(files["var/lib/foo/image"], content) {
+		t.Errorf("var/lib/foo/image: extracted content does not match")
+	}
+}
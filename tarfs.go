@@ -0,0 +1,217 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+type tarFS struct {
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	hdr    tar.Header
+	data   []byte
+	ra     io.ReaderAt
+	offset int64
+}
+
+// newTarFS indexes the tar stream r.  If ra is non-nil, it must read the same
+// bytes as r, and regular file contents are read from it on demand.
+// Otherwise, as for a compressed archive, the contents of every regular file
+// are held in memory for the life of the tarFS.
+func newTarFS(r io.Reader, ra io.ReaderAt) (*tarFS, error) {
+	tfs := &tarFS{entries: make(map[string]*tarEntry, 64)}
+	tfs.entries["."] = &tarEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: ".", Mode: 0o755}}
+
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar.Next: %w", err)
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("tar: invalid member name %q", hdr.Name)
+		}
+
+		entry := &tarEntry{hdr: *hdr}
+		entry.hdr.Name = name
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if ra != nil && !isSparseTarHeader(hdr) {
+				entry.ra = ra
+				entry.offset = cr.n
+				break
+			}
+			entry.data, err = io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("tar: %q: ReadAll: %w", hdr.Name, err)
+			}
+
+		case tar.TypeLink:
+			target := path.Clean(strings.TrimPrefix(hdr.Linkname, "/"))
+			orig, found := tfs.entries[target]
+			if !found || orig.hdr.Typeflag != tar.TypeReg {
+				return nil, fmt.Errorf("tar: %q: hard link to unknown regular file %q", hdr.Name, hdr.Linkname)
+			}
+			entry.hdr = orig.hdr
+			entry.hdr.Name = name
+			entry.data = orig.data
+			entry.ra = orig.ra
+			entry.offset = orig.offset
+
+		case tar.TypeDir, tar.TypeSymlink:
+
+		case tar.TypeXGlobalHeader:
+			continue
+
+		default:
+			return nil, fmt.Errorf("tar: %q: unsupported member type %q", hdr.Name, hdr.Typeflag)
+		}
+
+		tfs.entries[name] = entry
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, found := tfs.entries[dir]; found {
+				break
+			}
+			tfs.entries[dir] = &tarEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0o755}}
+		}
+	}
+	return tfs, nil
+}
+
+func (tfs *tarFS) Open(name string) (fs.File, error) {
+	entry, err := tfs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &tarFile{entry: entry, r: entry.reader()}, nil
+}
+
+func (entry *tarEntry) reader() io.ReadSeeker {
+	if entry.ra != nil {
+		return io.NewSectionReader(entry.ra, entry.offset, entry.hdr.Size)
+	}
+	return bytes.NewReader(entry.data)
+}
+
+func (tfs *tarFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := tfs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.hdr.FileInfo(), nil
+}
+
+const maxSymlinkHops = 40
+
+func (tfs *tarFS) lookup(op string, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved := "."
+	rest := name
+	if rest == "." {
+		rest = ""
+	}
+
+	hops := 0
+	for rest != "" {
+		var elem string
+		elem, rest, _ = strings.Cut(rest, "/")
+		next := path.Join(resolved, elem)
+		entry, found := tfs.entries[next]
+		if !found {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if entry.hdr.Typeflag != tar.TypeSymlink {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+
+		target := entry.hdr.Linkname
+		if strings.HasPrefix(target, "/") {
+			target = path.Clean(strings.TrimPrefix(target, "/"))
+		} else {
+			target = path.Join(resolved, target)
+		}
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		resolved = "."
+		switch {
+		case target == ".":
+		case rest == "":
+			rest = target
+		default:
+			rest = target + "/" + rest
+		}
+	}
+	return tfs.entries[resolved], nil
+}
+
+type tarFile struct {
+	entry *tarEntry
+	r     io.ReadSeeker
+}
+
+func (tf *tarFile) Stat() (fs.FileInfo, error) {
+	return tf.entry.hdr.FileInfo(), nil
+}
+
+func (tf *tarFile) Read(p []byte) (int, error) {
+	if tf.entry.hdr.Typeflag == tar.TypeDir {
+		return 0, &fs.PathError{Op: "read", Path: tf.entry.hdr.Name, Err: errors.New("is a directory")}
+	}
+	return tf.r.Read(p)
+}
+
+func (tf *tarFile) Close() error {
+	return nil
+}
+
+func isSparseTarHeader(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+var (
+	_ fs.FS     = (*tarFS)(nil)
+	_ fs.StatFS = (*tarFS)(nil)
+	_ fs.File   = (*tarFile)(nil)
+)
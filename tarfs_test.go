@@ -0,0 +1,108 @@
+package mkdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func testTarArchive(t *testing.T) []byte {
+	t.Helper()
+	longName := "usr/share/" + strings.Repeat("long", 40) + "/file"
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0o755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/a", Mode: 0o644, Size: 6}, "hello\n"},
+		{tar.Header{Typeflag: tar.TypeLink, Name: "etc/b", Linkname: "etc/a"}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: longName, Mode: 0o644, Size: 5, Format: tar.FormatPAX}, "long\n"},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "bin/tool", Mode: 0o755, Size: 5}, "tool\n"},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/c", Linkname: "a"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "lib", Linkname: "/usr/share"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/loop", Linkname: "loop"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/escape", Linkname: "../../etc/a"}, ""},
+	}
+	for _, entry := range entries {
+		hdr := entry.hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("WriteHeader: %v", err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTarFS(t *testing.T) {
+	data := testTarArchive(t)
+	longName := "usr/share/" + strings.Repeat("long", 40) + "/file"
+	expect := map[string]string{
+		"etc/a":    "hello\n",
+		"etc/b":    "hello\n",
+		longName:   "long\n",
+		"bin/tool": "tool\n",
+		"etc/c":    "hello\n",
+		"lib/" + strings.Repeat("long", 40) + "/file": "long\n",
+	}
+
+	for _, mode := range []string{"stream", "seek"} {
+		t.Run(mode, func(t *testing.T) {
+			var tfs *tarFS
+			var err error
+			if mode == "seek" {
+				tfs, err = newTarFS(bytes.NewReader(data), bytes.NewReader(data))
+			} else {
+				tfs, err = newTarFS(bytes.NewReader(data), nil)
+			}
+			if err != nil {
+				t.Fatalf("newTarFS: %v", err)
+			}
+
+			for name, want := range expect {
+				got, err := fs.ReadFile(tfs, name)
+				if err != nil {
+					t.Errorf("%s: ReadFile: %v", name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("%s: expected %q, got %q", name, want, got)
+				}
+			}
+
+			info, err := fs.Stat(tfs, "usr/share")
+			if err != nil || !info.IsDir() {
+				t.Errorf("usr/share: expected implicit directory, got %v, %v", info, err)
+			}
+			for _, name := range []string{"etc/missing", "etc/loop", "etc/escape", "lib/missing"} {
+				if _, err := fs.ReadFile(tfs, name); err == nil {
+					t.Errorf("%s: expected error", name)
+				}
+			}
+		})
+	}
+}
+
+func TestTarFSUnsupportedMember(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeFifo, Name: "run/fifo", Mode: 0o644}); err != nil {
+		t.Fatalf("WriteHeader: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, err := newTarFS(bytes.NewReader(buf.Bytes()), nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported member type") {
+		t.Errorf("expected an unsupported member error, got %v", err)
+	}
+}
@@ -1,14 +1,17 @@
 package mkdeb
 
 import (
+	"fmt"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 func jsonIsNull(input []byte) bool {
-	if len(input) <= 0 {
-		return true
-	}
 	if len(input) == 4 && input[0] == 'n' && input[1] == 'u' && input[2] == 'l' && input[3] == 'l' {
 		return true
 	}
@@ -19,21 +22,61 @@ const nameComponent = `(?:[.-]|_+)?[0-9A-Za-z]+(?:(?:[.-]|_+)[0-9A-Za-z]+)*`
 
 var (
 	nameRx     = regexp.MustCompile(`^(?:[.]|(?:` + nameComponent + `/)*` + nameComponent + `)/?$`)
-	packageRx  = regexp.MustCompile(`^[0-9a-z][0-9a-z]+(?:[.+-][0-9a-z]+)*$`)
+	packageRx  = regexp.MustCompile(`^[0-9a-z][0-9a-z.+-]+$`)
 	versionRx  = regexp.MustCompile(`^(?:[1-9][0-9]*[:])?[0-9][0-9A-Za-z]*(?:[.~+-][0-9A-Za-z]+)*$`)
 	archRx     = regexp.MustCompile(`^[0-9A-Za-z]+(?:[-][0-9A-Za-z]+)*$`)
 	sectionRx  = regexp.MustCompile(`^[0-9a-z]+(?:[/-][0-9a-z]+)*$`)
 	priorityRx = regexp.MustCompile(`^(?:required|important|standard|optional|extra)$`)
+	langRx     = regexp.MustCompile(`^[a-z]{2,3}(?:_[A-Z]{2})?$`)
+	substVarRx = regexp.MustCompile(`\$\{([^{}]*)\}`)
+	buildIDRx  = regexp.MustCompile(`^(?:[0-9a-f]{2})+$`)
+	digitsRx   = regexp.MustCompile(`^[0-9]+$`)
 )
 
 func isValidUnixPath(str string) bool {
 	return nameRx.MatchString(str)
 }
 
+func canonicalUnixPath(str string) (string, bool) {
+	trimmed := strings.TrimSuffix(str, "/")
+	clean := path.Clean(trimmed)
+	if trimmed != str {
+		clean += "/"
+	}
+	return clean, clean == str
+}
+
+func isValidLanguage(str string) bool {
+	return langRx.MatchString(str)
+}
+
 func isValidPackage(str string) bool {
 	return packageRx.MatchString(str)
 }
 
+func checkPackageName(str string) error {
+	if len(str) < 2 {
+		return fmt.Errorf("too short: must be at least 2 characters")
+	}
+	for index, ch := range str {
+		switch {
+		case ch >= 'a' && ch <= 'z':
+		case ch >= '0' && ch <= '9':
+		case ch >= 'A' && ch <= 'Z':
+			return fmt.Errorf("uppercase letters are not allowed: %q at offset %d", ch, index)
+		case index == 0:
+			return fmt.Errorf("must start with a lowercase letter or digit, not %q", ch)
+		case ch == '.' || ch == '+' || ch == '-':
+		default:
+			return fmt.Errorf("invalid character %q at offset %d", ch, index)
+		}
+	}
+	if !isValidPackage(str) {
+		return fmt.Errorf("does not match %s", packageRx)
+	}
+	return nil
+}
+
 func isValidVersion(str string) bool {
 	return versionRx.MatchString(str)
 }
@@ -66,6 +109,14 @@ func isValidBuiltUsing(str string) bool {
 	return true
 }
 
+func isValidBuildID(str string) bool {
+	return buildIDRx.MatchString(str)
+}
+
+func isDigits(str string) bool {
+	return digitsRx.MatchString(str)
+}
+
 func isValidDescriptionLine(str string) bool {
 	spaceCount := 0
 	for _, ch := range str {
@@ -80,3 +131,79 @@ func isValidDescriptionLine(str string) bool {
 	}
 	return (spaceCount <= 0)
 }
+
+func relativeLink(name string, target string) string {
+	if !strings.HasPrefix(target, "/") {
+		return target
+	}
+
+	dir := path.Dir("/" + strings.TrimRight(name, "/"))
+	from := strings.Split(strings.Trim(dir, "/"), "/")
+	to := strings.Split(strings.Trim(target, "/"), "/")
+	if from[0] == "" {
+		from = nil
+	}
+	if to[0] == "" {
+		to = nil
+	}
+
+	common := 0
+	for common < len(from) && common < len(to) && from[common] == to[common] {
+		common++
+	}
+
+	parts := make([]string, 0, len(from)-common+len(to)-common)
+	for i := common; i < len(from); i++ {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to[common:]...)
+	if len(parts) == 0 {
+		if len(from) == 0 {
+			return target
+		}
+		return "../" + from[len(from)-1]
+	}
+	return strings.Join(parts, "/")
+}
+
+func parseUmask(str string) (uint64, error) {
+	num, err := strconv.ParseUint(str, 8, 16)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q as octal umask: %w", str, err)
+	}
+	if num > 0o777 {
+		return 0, fmt.Errorf("umask %q is out of range", str)
+	}
+	return num, nil
+}
+
+func validateControlValue(str string, asciiOnly bool) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("value is not valid UTF-8: %q", str)
+	}
+	for _, ch := range str {
+		switch {
+		case ch == '\n':
+			return fmt.Errorf("value must not contain a newline: %q", str)
+		case ch == '\r':
+			return fmt.Errorf("value must not contain a carriage return: %q", str)
+		case asciiOnly && ch >= utf8.RuneSelf:
+			return fmt.Errorf("value must be ASCII: %q", str)
+		}
+	}
+	return nil
+}
+
+func isInBinDir(name string) bool {
+	dir := path.Base(path.Dir(strings.TrimRight(name, "/")))
+	return dir == "bin" || dir == "sbin"
+}
+
+func sortLines(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
@@ -0,0 +1,75 @@
+package mkdeb
+
+import (
+	"testing"
+)
+
+func TestRelativeLink(t *testing.T) {
+	type testRow struct {
+		name   string
+		target string
+		expect string
+	}
+
+	testData := [...]testRow{
+		{"usr/bin/foo", "/usr/bin/bar", "bar"},
+		{"usr/bin/foo", "/usr/lib/foo/foo", "../lib/foo/foo"},
+		{"usr/lib/foo", "/usr/lib", "../lib"},
+		{"usr/lib/foo/", "/usr/lib", "../lib"},
+		{"usr/lib/foo", "/usr", ".."},
+		{"usr/lib/foo", "/", "../.."},
+		{"foo", "/", "/"},
+		{"foo", "/etc/foo", "etc/foo"},
+		{"usr/lib/foo", "bar", "bar"},
+	}
+
+	for _, row := range testData {
+		actual := relativeLink(row.name, row.target)
+		if actual != row.expect {
+			t.Errorf("relativeLink(%q, %q): expected %q, got %q", row.name, row.target, row.expect, actual)
+		}
+	}
+}
+
+func TestCheckPackageName(t *testing.T) {
+	type testRow struct {
+		input     string
+		expectErr string
+	}
+
+	testData := [...]testRow{
+		{"foo", ""},
+		{"0ad", ""},
+		{"g++", ""},
+		{"libfoo1.2-dev", ""},
+		{"", "too short: must be at least 2 characters"},
+		{"x", "too short: must be at least 2 characters"},
+		{"Foo", "uppercase letters are not allowed: 'F' at offset 0"},
+		{"libFoo", "uppercase letters are not allowed: 'F' at offset 3"},
+		{"-foo", "must start with a lowercase letter or digit, not '-'"},
+		{".foo", "must start with a lowercase letter or digit, not '.'"},
+		{"foo_bar", "invalid character '_' at offset 3"},
+		{"foo bar", "invalid character ' ' at offset 3"},
+	}
+
+	for _, row := range testData {
+		err := checkPackageName(row.input)
+		switch {
+		case row.expectErr == "" && err != nil:
+			t.Errorf("checkPackageName(%q): unexpected error: %v", row.input, err)
+		case row.expectErr != "" && (err == nil || err.Error() != row.expectErr):
+			t.Errorf("checkPackageName(%q): expected error %q, got %v", row.input, row.expectErr, err)
+		}
+	}
+
+	err := testFooManifest(t, "").Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	manifest := testFooManifest(t, "")
+	manifest.Package = "Foo"
+	err = manifest.Validate()
+	if err == nil || err.Error() != `package: invalid Debian package name "Foo": uppercase letters are not allowed: 'F' at offset 0` {
+		t.Errorf("Validate: expected an uppercase error, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package mkdeb
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+)
+
+type ValidationErrorKind byte
+
+const (
+	ValidationInvalid ValidationErrorKind = iota
+	ValidationMissing
+	ValidationConflict
+	ValidationDuplicate
+	ValidationUnexpected
+)
+
+var validationErrorKindGoNameArray = [...]string{
+	"mkdeb.ValidationInvalid",
+	"mkdeb.ValidationMissing",
+	"mkdeb.ValidationConflict",
+	"mkdeb.ValidationDuplicate",
+	"mkdeb.ValidationUnexpected",
+}
+
+var validationErrorKindNameArray = [...]string{
+	"invalid",
+	"missing",
+	"conflict",
+	"duplicate",
+	"unexpected",
+}
+
+func (kind ValidationErrorKind) GoString() string {
+	if kind < ValidationErrorKind(len(validationErrorKindGoNameArray)) {
+		return validationErrorKindGoNameArray[kind]
+	}
+	return fmt.Sprintf("mkdeb.ValidationErrorKind(0x%02x)", byte(kind))
+}
+
+func (kind ValidationErrorKind) String() string {
+	if kind < ValidationErrorKind(len(validationErrorKindNameArray)) {
+		return validationErrorKindNameArray[kind]
+	}
+	return fmt.Sprintf("validation#%02x", byte(kind))
+}
+
+func (kind ValidationErrorKind) MarshalText() ([]byte, error) {
+	str := kind.String()
+	return []byte(str), nil
+}
+
+var (
+	_ fmt.GoStringer         = ValidationErrorKind(0)
+	_ fmt.Stringer           = ValidationErrorKind(0)
+	_ encoding.TextMarshaler = ValidationErrorKind(0)
+)
+
+type ValidationError struct {
+	Field   string              `json:"field"`
+	Kind    ValidationErrorKind `json:"kind"`
+	Message string              `json:"message"`
+	Err     error               `json:"-"`
+}
+
+func validationErrorf(field string, kind ValidationErrorKind, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return &ValidationError{
+		Field:   field,
+		Kind:    kind,
+		Message: err.Error(),
+		Err:     errors.Unwrap(err),
+	}
+}
+
+func prefixValidationError(prefix string, err error) error {
+	if verr, ok := err.(*ValidationError); ok {
+		out := *verr
+		out.Field = prefix + "." + verr.Field
+		return &out
+	}
+	return fmt.Errorf("%s: %w", prefix, err)
+}
+
+func (err *ValidationError) Error() string {
+	return err.Field + ": " + err.Message
+}
+
+func (err *ValidationError) Unwrap() error {
+	return err.Err
+}
+
+var _ error = (*ValidationError)(nil)